@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/RicheyJang/key_keeper/client"
+	"github.com/RicheyJang/key_keeper/logic"
+	"github.com/RicheyJang/key_keeper/utils/logger"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/core/router"
+	irisRecover "github.com/kataras/iris/v12/middleware/recover"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+func AgentServer(config *viper.Viper) {
+	if config == nil {
+		log.Fatal("agent config is nil")
+	}
+	// 初始化上游客户端
+	c, err := client.New(client.Option{
+		Endpoints:  config.GetStringSlice("upstreams"),
+		Identifier: config.GetString("identifier"),
+		CA:         config.GetString("ca"),
+		Cert:       config.GetString("cert"),
+		Private:    config.GetString("private"),
+		Retry:      1,
+		Grace:      config.GetDuration("grace"),
+	})
+	if err != nil {
+		log.Fatal("Failed to create agent client: ", err)
+	}
+	agent, err := logic.NewAgent(logic.AgentOption{
+		Client:   c,
+		Secret:   config.GetString("secret"),
+		SealFile: config.GetString("sealFile"),
+		SealWith: config.GetString("private"),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 初始化Iris
+	app := iris.New()
+	app.Use(irisRecover.New())
+	app.Use(logger.Iris("[Agent]")) // 日志
+
+	// 设置路由：与InnerServer保持一致
+	app.PartyFunc("/api/inner", func(inner router.Party) {
+		inner.Post("/key", agent.GetKeyInfo)
+		inner.Post("/version", agent.GetLatestVersionKey)
+	})
+
+	// 启动：TCP监听须配置共享密钥，否则同机任意进程均可获取密钥
+	listen := config.GetString("listen")
+	if !strings.HasPrefix(listen, logic.AgentUnixPrefix) && len(config.GetString("secret")) == 0 {
+		log.Fatal("agent.secret is required when agent listens on TCP")
+	}
+	l, err := logic.AgentListener(listen)
+	if err != nil {
+		log.Fatal("Failed to listen: ", err)
+	}
+	log.Fatal(app.Run(iris.Listener(l),
+		iris.WithoutPathCorrectionRedirection,
+		iris.WithOptimizations))
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/utils"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 服务端证书、私钥及客户端CA，文件或配置路径变更时自动重新加载，加载失败时沿用旧证书
+var serverCerts = new(certStore)
+
+type certStore struct {
+	sync.RWMutex
+	ca, self, private string // 当前使用的文件路径
+	cert              *tls.Certificate
+	pool              *x509.CertPool
+
+	watcher *fsnotify.Watcher
+}
+
+// 读取证书并开始监听文件变更
+func (store *certStore) setup() error {
+	if err := store.load(); err != nil {
+		return err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	store.Lock()
+	store.watcher = watcher
+	store.Unlock()
+	store.watch()
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create) != 0 && store.isWatched(event.Name) {
+					store.reload()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("watch certificates error: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// 按当前配置读取证书
+func (store *certStore) load() error {
+	caPath, self, private := viper.GetString("cert.ca"), viper.GetString("cert.self"), viper.GetString("cert.private")
+	crt, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(crt)
+	cert, err := utils.LoadCertificate(self, private)
+	if err != nil {
+		return err
+	}
+	store.Lock()
+	store.ca, store.self, store.private = caPath, self, private
+	store.cert, store.pool = cert, pool
+	store.Unlock()
+	return nil
+}
+
+// 检查服务端证书已加载且距过期不少于health.certExpiry
+func (store *certStore) check() error {
+	store.RLock()
+	cert := store.cert
+	store.RUnlock()
+	if cert == nil || len(cert.Certificate) == 0 {
+		return errors.New(-1, "server certificate is not loaded")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if time.Until(leaf.NotAfter) < viper.GetDuration("health.certExpiry") {
+		return errors.Newf(-1, "server certificate expires at %v", leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// 重新加载证书，失败时沿用旧证书
+func (store *certStore) reload() {
+	store.RLock()
+	ready := store.cert != nil
+	store.RUnlock()
+	if !ready { // 尚未启用（如Agent模式）
+		return
+	}
+	if err := store.load(); err != nil {
+		log.Errorf("reload certificates error, keep using the previous ones: %v", err)
+		return
+	}
+	store.watch() // 配置路径可能已变更
+	log.Infof("certificates are reloaded")
+}
+
+// 监听证书文件所在目录，以兼容替换文件式的更新
+func (store *certStore) watch() {
+	store.RLock()
+	defer store.RUnlock()
+	if store.watcher == nil {
+		return
+	}
+	for _, file := range []string{store.ca, store.self, store.private} {
+		if err := store.watcher.Add(filepath.Dir(file)); err != nil {
+			log.Errorf("watch %v error: %v", file, err)
+		}
+	}
+}
+
+func (store *certStore) isWatched(name string) bool {
+	store.RLock()
+	defer store.RUnlock()
+	name = filepath.Clean(name)
+	for _, file := range []string{store.ca, store.self, store.private} {
+		if filepath.Clean(file) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCertificate 用于tls.Config，返回当前服务端证书
+func (store *certStore) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	store.RLock()
+	defer store.RUnlock()
+	return store.cert, nil
+}
+
+// GetConfigForClient 生成用于tls.Config的函数，以base为模板并使用当前的客户端CA
+func (store *certStore) GetConfigForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	var mu sync.Mutex
+	var config *tls.Config
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		store.RLock()
+		pool := store.pool
+		store.RUnlock()
+		mu.Lock()
+		defer mu.Unlock()
+		if config == nil || config.ClientCAs != pool { // 客户端CA变更后重新生成
+			config = base.Clone()
+			config.ClientCAs = pool
+			config.GetConfigForClient = nil
+		}
+		return config, nil
+	}
+}
@@ -0,0 +1,205 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/keeper"
+)
+
+type cacheIndex struct {
+	ID      uint
+	Version uint
+}
+
+// 清理过期缓存项的最小间隔
+const evictInterval = time.Minute
+
+// 默认缓存的密钥数量上限
+const defaultCacheSize = 4096
+
+// 密钥缓存：以KeyInfo.Timeout作为过期时间，Timeout为0代表不轮替、永不过期
+// 过期后的缓存项仍会保留grace时长，仅在无法连接key keeper时使用
+type keyCache struct {
+	mu        sync.RWMutex
+	grace     time.Duration
+	size      int       // versions、latest各自的数量上限
+	evictedAt time.Time // 上次清理过期缓存项的时间
+	revision  uint64    // 每次写入缓存时递增
+
+	versions map[cacheIndex]keeper.KeyInfo // (ID, 版本) -> 密钥
+	latest   map[uint]keeper.KeyInfo       // ID -> 最新版本密钥
+}
+
+// CacheSnapshot 缓存快照，用于持久化缓存
+type CacheSnapshot struct {
+	Versions []keeper.KeyInfo `json:"versions"`
+	Latest   []keeper.KeyInfo `json:"latest"`
+}
+
+func newKeyCache(grace time.Duration, size int) *keyCache {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	return &keyCache{
+		grace:    grace,
+		size:     size,
+		versions: make(map[cacheIndex]keeper.KeyInfo),
+		latest:   make(map[uint]keeper.KeyInfo),
+	}
+}
+
+// get 获取指定版本的缓存项，stale为真时允许返回宽限期内的过期项
+func (c *keyCache) get(id uint, version uint, stale bool) (keeper.KeyInfo, bool) {
+	if c == nil {
+		return keeper.KeyInfo{}, false
+	}
+	c.mu.RLock()
+	info, ok := c.versions[cacheIndex{ID: id, Version: version}]
+	c.mu.RUnlock()
+	if !ok || !c.usable(info, time.Now(), stale) {
+		return keeper.KeyInfo{}, false
+	}
+	return info, true
+}
+
+// getLatest 获取最新版本的缓存项，stale为真时允许返回宽限期内的过期项
+func (c *keyCache) getLatest(id uint, stale bool) (keeper.KeyInfo, bool) {
+	if c == nil {
+		return keeper.KeyInfo{}, false
+	}
+	c.mu.RLock()
+	info, ok := c.latest[id]
+	c.mu.RUnlock()
+	if !ok || !c.usable(info, time.Now(), stale) {
+		return keeper.KeyInfo{}, false
+	}
+	return info, true
+}
+
+func (c *keyCache) put(info keeper.KeyInfo, isLatest bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revision++
+	c.versions[cacheIndex{ID: info.ID, Version: info.Version}] = info
+	if isLatest {
+		c.latest[info.ID] = info
+	}
+	if now := time.Now(); now.Sub(c.evictedAt) >= evictInterval {
+		c.evictLocked(now)
+	}
+	c.trimLocked()
+}
+
+// remove 删除指定ID的所有缓存项，用于密钥已不存在时
+func (c *keyCache) remove(id uint) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revision++
+	for index := range c.versions {
+		if index.ID == id {
+			delete(c.versions, index)
+		}
+	}
+	delete(c.latest, id)
+}
+
+func (c *keyCache) flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.revision++
+	c.versions = make(map[cacheIndex]keeper.KeyInfo)
+	c.latest = make(map[uint]keeper.KeyInfo)
+	c.mu.Unlock()
+}
+
+func (c *keyCache) getRevision() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.revision
+}
+
+func (c *keyCache) snapshot() CacheSnapshot {
+	var snapshot CacheSnapshot
+	if c == nil {
+		return snapshot
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, info := range c.versions {
+		snapshot.Versions = append(snapshot.Versions, info)
+	}
+	for _, info := range c.latest {
+		snapshot.Latest = append(snapshot.Latest, info)
+	}
+	return snapshot
+}
+
+func (c *keyCache) restore(snapshot CacheSnapshot) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, info := range snapshot.Versions {
+		c.versions[cacheIndex{ID: info.ID, Version: info.Version}] = info
+	}
+	for _, info := range snapshot.Latest {
+		c.latest[info.ID] = info
+	}
+	c.evictLocked(time.Now())
+	c.trimLocked()
+}
+
+// 清理已超出宽限期的缓存项
+func (c *keyCache) evictLocked(now time.Time) {
+	c.evictedAt = now
+	for index, info := range c.versions {
+		if !c.usable(info, now, true) {
+			delete(c.versions, index)
+		}
+	}
+	for id, info := range c.latest {
+		if !c.usable(info, now, true) {
+			delete(c.latest, id)
+		}
+	}
+}
+
+// 超出数量上限时随机淘汰
+func (c *keyCache) trimLocked() {
+	for index := range c.versions {
+		if len(c.versions) <= c.size {
+			break
+		}
+		delete(c.versions, index)
+	}
+	for id := range c.latest {
+		if len(c.latest) <= c.size {
+			break
+		}
+		delete(c.latest, id)
+	}
+}
+
+func (c *keyCache) usable(info keeper.KeyInfo, now time.Time, stale bool) bool {
+	if info.Timeout == 0 {
+		return true
+	}
+	deadline := time.Unix(int64(info.Timeout), 0)
+	if stale {
+		deadline = deadline.Add(c.grace)
+	}
+	return now.Before(deadline)
+}
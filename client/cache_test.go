@@ -0,0 +1,47 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/keeper"
+)
+
+func TestKeyCacheSize(t *testing.T) {
+	c := newKeyCache(time.Hour, 3)
+	for id := uint(1); id <= 10; id++ {
+		c.put(keeper.KeyInfo{ID: id, Version: 1}, true)
+	}
+	if len(c.versions) != 3 || len(c.latest) != 3 {
+		t.Fatalf("cache is not bounded: %d versions, %d latest", len(c.versions), len(c.latest))
+	}
+}
+
+func TestKeyCacheRemove(t *testing.T) {
+	c := newKeyCache(time.Hour, 0)
+	c.put(keeper.KeyInfo{ID: 1, Version: 1}, false)
+	c.put(keeper.KeyInfo{ID: 1, Version: 2}, true)
+	c.put(keeper.KeyInfo{ID: 2, Version: 1}, true)
+	c.remove(1)
+	if _, ok := c.get(1, 1, true); ok {
+		t.Fatal("removed version is still cached")
+	}
+	if _, ok := c.getLatest(1, true); ok {
+		t.Fatal("removed latest version is still cached")
+	}
+	if _, ok := c.getLatest(2, false); !ok {
+		t.Fatal("other keys should be kept")
+	}
+}
+
+func TestKeyCacheGrace(t *testing.T) {
+	c := newKeyCache(time.Hour, 0)
+	expired := keeper.KeyInfo{ID: 1, Version: 1, Timeout: uint(time.Now().Add(-time.Minute).Unix())}
+	c.put(expired, true)
+	if _, ok := c.getLatest(1, false); ok {
+		t.Fatal("expired key should not be fresh")
+	}
+	if _, ok := c.getLatest(1, true); !ok {
+		t.Fatal("expired key within grace should be usable as stale")
+	}
+}
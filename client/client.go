@@ -0,0 +1,262 @@
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/utils"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+)
+
+// Option 创建Client时的参数
+type Option struct {
+	Endpoints  []string // key keeper内部服务地址列表，如 https://127.0.0.1:7709 ，按顺序故障转移
+	Identifier string   // 实例标识符
+
+	CA        string      // CA证书路径
+	Cert      string      // 客户端证书路径
+	Private   string      // 客户端私钥路径
+	TLSConfig *tls.Config // 若不为空，则忽略CA、Cert、Private
+
+	Timeout   time.Duration // 单次请求超时时长
+	Retry     int           // 所有地址均失败后的重试轮数
+	RetryWait time.Duration // 每轮重试之间的等待时长
+	NoCache   bool          // 不使用内存缓存
+	CacheSize int           // 内存缓存的密钥数量上限，默认4096
+	Grace     time.Duration // 无法连接key keeper时，允许继续使用过期缓存的宽限时长
+}
+
+// Client key keeper内部API客户端：负责mTLS、实例标识、回包解析、缓存及故障转移
+type Client struct {
+	endpoints  []string
+	identifier string
+	httpClient *http.Client
+	retry      int
+	retryWait  time.Duration
+
+	current int        // 最近一次成功的地址下标
+	mu      sync.Mutex // 保护current
+
+	cache *keyCache // 为空则不缓存
+}
+
+// New 创建Client
+func New(option Option) (*Client, error) {
+	if len(option.Endpoints) == 0 {
+		return nil, errors.New(errors.CodeRequest, "client endpoints is empty")
+	}
+	if len(option.Identifier) == 0 {
+		return nil, errors.New(errors.CodeRequest, "client identifier is empty")
+	}
+	tlsConfig := option.TLSConfig
+	if tlsConfig == nil {
+		var err error
+		if tlsConfig, err = loadTLSConfig(option.CA, option.Cert, option.Private); err != nil {
+			return nil, err
+		}
+	}
+	if option.Timeout <= 0 {
+		option.Timeout = 5 * time.Second
+	}
+	if option.Retry < 0 {
+		option.Retry = 0
+	}
+	c := &Client{
+		identifier: option.Identifier,
+		httpClient: &http.Client{
+			Timeout:   option.Timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		retry:     option.Retry,
+		retryWait: option.RetryWait,
+	}
+	for _, endpoint := range option.Endpoints {
+		c.endpoints = append(c.endpoints, strings.TrimSuffix(endpoint, "/"))
+	}
+	if !option.NoCache {
+		c.cache = newKeyCache(option.Grace, option.CacheSize)
+	}
+	return c, nil
+}
+
+// GetKeyInfo 获取指定版本的密钥
+func (c *Client) GetKeyInfo(id uint, version uint) (keeper.KeyInfo, error) {
+	if info, ok := c.cache.get(id, version, false); ok {
+		return info, nil
+	}
+	info, err := c.request("/api/inner/key", keeper.KeyRequest{ID: id, Version: version})
+	if err != nil {
+		if isNoSuchKey(err) { // 密钥已销毁：不再使用缓存
+			c.cache.remove(id)
+			return keeper.KeyInfo{}, err
+		}
+		if stale, ok := c.cache.get(id, version, true); ok && shouldFailover(err) {
+			return stale, nil
+		}
+		return keeper.KeyInfo{}, err
+	}
+	c.cache.put(info, false)
+	return info, nil
+}
+
+// GetLatestVersionKey 获取特定密钥ID下的最新版本密钥
+func (c *Client) GetLatestVersionKey(id uint) (keeper.KeyInfo, error) {
+	if info, ok := c.cache.getLatest(id, false); ok {
+		return info, nil
+	}
+	info, err := c.request("/api/inner/version", keeper.KeyRequest{ID: id})
+	if err != nil {
+		if isNoSuchKey(err) { // 密钥已销毁：不再使用缓存
+			c.cache.remove(id)
+			return keeper.KeyInfo{}, err
+		}
+		if stale, ok := c.cache.getLatest(id, true); ok && shouldFailover(err) {
+			return stale, nil
+		}
+		return keeper.KeyInfo{}, err
+	}
+	c.cache.put(info, true)
+	return info, nil
+}
+
+// FlushCache 清空内存缓存
+func (c *Client) FlushCache() {
+	c.cache.flush()
+}
+
+// CacheSnapshot 导出当前内存缓存
+func (c *Client) CacheSnapshot() CacheSnapshot {
+	return c.cache.snapshot()
+}
+
+// CacheRevision 获取内存缓存的修订号，缓存每次写入后递增
+func (c *Client) CacheRevision() uint64 {
+	return c.cache.getRevision()
+}
+
+// RestoreCache 从快照中恢复内存缓存，已超出宽限期的缓存项将被丢弃
+func (c *Client) RestoreCache(snapshot CacheSnapshot) {
+	c.cache.restore(snapshot)
+}
+
+// Identifier 获取该客户端所使用的实例标识符
+func (c *Client) Identifier() string {
+	return c.identifier
+}
+
+// 回包格式，与logic中responseSuccess、responseError保持一致
+type response struct {
+	Code int            `json:"code"`
+	Msg  string         `json:"msg"`
+	Key  keeper.KeyInfo `json:"key"`
+}
+
+// 依次尝试各个地址发起请求，请求本身有误时不再故障转移
+func (c *Client) request(path string, req keeper.KeyRequest) (keeper.KeyInfo, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return keeper.KeyInfo{}, err
+	}
+	c.mu.Lock()
+	start := c.current
+	c.mu.Unlock()
+
+	var lastErr error
+	for round := 0; round <= c.retry; round++ {
+		if round > 0 && c.retryWait > 0 {
+			time.Sleep(c.retryWait)
+		}
+		for i := range c.endpoints {
+			index := (start + i) % len(c.endpoints)
+			info, err := c.requestOnce(c.endpoints[index]+path, body)
+			if err == nil {
+				c.mu.Lock()
+				c.current = index
+				c.mu.Unlock()
+				return info, nil
+			}
+			lastErr = err
+			if !shouldFailover(err) {
+				return keeper.KeyInfo{}, err
+			}
+		}
+	}
+	return keeper.KeyInfo{}, lastErr
+}
+
+func (c *Client) requestOnce(url string, body []byte) (keeper.KeyInfo, error) {
+	httpReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return keeper.KeyInfo{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("identifier", c.identifier)
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return keeper.KeyInfo{}, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return keeper.KeyInfo{}, err
+	}
+	var res response
+	if err = json.Unmarshal(data, &res); err != nil {
+		return keeper.KeyInfo{}, fmt.Errorf("unexpected response from %s (status %d): %v", url, resp.StatusCode, err)
+	}
+	if res.Code != 0 {
+		return keeper.KeyInfo{}, decodeError(res.Code, res.Msg)
+	}
+	return res.Key, nil
+}
+
+// 将回包中的错误码还原为errors.Error，可直接与errors包中的预定义错误比较
+func decodeError(code int, msg string) error {
+	return errors.New(code, msg)
+}
+
+// 请求本身有误时各地址的结果相同，无需故障转移；网络错误、内部错误及密封、未就绪、限流等仅与所访问的副本有关
+func shouldFailover(err error) bool {
+	e, ok := err.(errors.Error)
+	if !ok {
+		return true
+	}
+	switch e.Code {
+	case errors.CodeKey, errors.CodeRequest, errors.CodePermission, errors.CodeInstanceFrozen, errors.CodeKeeperSupport:
+		return false
+	}
+	return true
+}
+
+func isNoSuchKey(err error) bool {
+	e, ok := err.(errors.Error)
+	return ok && e.Code == errors.CodeKey
+}
+
+func loadTLSConfig(ca, cert, private string) (*tls.Config, error) {
+	crt, err := ioutil.ReadFile(ca)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(crt) {
+		return nil, fmt.Errorf("no valid certificate in %s", ca)
+	}
+	clientCert, err := utils.LoadCertificate(cert, private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client certificate: %v", err)
+	}
+	return &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{*clientCert},
+	}, nil
+}
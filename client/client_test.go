@@ -0,0 +1,222 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+)
+
+// 模拟的key keeper副本：依次返回replies中的回包，用尽后重复最后一个
+type fakeServer struct {
+	*httptest.Server
+	hits    int32
+	replies []interface{}
+}
+
+func newFakeServer(t *testing.T, replies ...interface{}) *fakeServer {
+	t.Helper()
+	s := &fakeServer{replies: replies}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit := int(atomic.AddInt32(&s.hits, 1))
+		reply := s.replies[len(s.replies)-1]
+		if hit <= len(s.replies) {
+			reply = s.replies[hit-1]
+		}
+		if text, ok := reply.(string); ok { // 非JSON回包，如反向代理的错误页
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte(text))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(reply)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeServer) hitCount() int {
+	return int(atomic.LoadInt32(&s.hits))
+}
+
+func success(info keeper.KeyInfo) map[string]interface{} {
+	return map[string]interface{}{"code": 0, "msg": "success", "key": info}
+}
+
+func failure(err errors.Error) map[string]interface{} {
+	return map[string]interface{}{"code": err.Code, "msg": err.Msg}
+}
+
+func newTestClient(t *testing.T, option Option, servers ...*fakeServer) *Client {
+	t.Helper()
+	for _, s := range servers {
+		option.Endpoints = append(option.Endpoints, s.URL)
+	}
+	option.Identifier = "ins"
+	option.TLSConfig = &tls.Config{}
+	c, err := New(option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// 生成自签名的客户端证书
+func newClientCert(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	want := keeper.KeyInfo{ID: 1, Version: 2, Key: "00", Length: 1, Algorithm: keeper.AlgorithmAESGCM}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req keeper.KeyRequest
+		switch {
+		case r.Method != http.MethodPost || r.URL.Path != "/api/inner/key":
+			t.Errorf("unexpected request %v %v", r.Method, r.URL.Path)
+		case r.Header.Get("Content-Type") != "application/json" || r.Header.Get("identifier") != "ins":
+			t.Errorf("unexpected headers %v", r.Header)
+		case len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "ins":
+			t.Error("client certificate is not presented")
+		case json.NewDecoder(r.Body).Decode(&req) != nil || req.ID != 1 || req.Version != 2:
+			t.Errorf("unexpected body %+v", req)
+		}
+		_ = json.NewEncoder(w).Encode(success(want))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	c, err := New(Option{
+		Endpoints:  []string{server.URL + "/"},
+		Identifier: "ins",
+		TLSConfig:  &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{newClientCert(t, "ins")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetKeyInfo(1, 2)
+	if err != nil || got != want {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	for _, want := range []errors.Error{errors.NoSuchKey, errors.PermissionDeny, errors.InstanceFrozen} {
+		c := newTestClient(t, Option{NoCache: true}, newFakeServer(t, failure(want)))
+		if _, err := c.GetLatestVersionKey(1); err != want { // 可直接与预定义错误比较
+			t.Fatalf("got %v, want %v", err, want)
+		}
+	}
+	// 非JSON回包
+	c := newTestClient(t, Option{NoCache: true}, newFakeServer(t, "bad gateway"))
+	if _, err := c.GetLatestVersionKey(1); err == nil {
+		t.Fatal("expected an error")
+	} else if _, ok := err.(errors.Error); ok {
+		t.Fatalf("unexpected api error %v", err)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	key := success(keeper.KeyInfo{ID: 1, Version: 1})
+	cases := []struct {
+		name    string
+		replies []interface{} // 各副本的回包
+		hits    []int
+		err     error
+	}{
+		{"sealed", []interface{}{failure(errors.Sealed), key}, []int{1, 1}, nil},
+		{"not ready and rate limited", []interface{}{failure(errors.NotReady), failure(errors.RateLimited), key}, []int{1, 1, 1}, nil},
+		{"internal error", []interface{}{failure(errors.Unknown), "bad gateway", key}, []int{1, 1, 1}, nil},
+		{"no such key", []interface{}{failure(errors.NoSuchKey), key}, []int{1, 0}, errors.NoSuchKey},
+		{"permission deny", []interface{}{failure(errors.PermissionDeny), key}, []int{1, 0}, errors.PermissionDeny},
+		{"invalid request", []interface{}{failure(errors.NoSuchInstance), key}, []int{1, 0}, errors.NoSuchInstance},
+		{"all sealed", []interface{}{failure(errors.Sealed), failure(errors.Sealed)}, []int{1, 1}, errors.Sealed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var servers []*fakeServer
+			for _, reply := range tc.replies {
+				servers = append(servers, newFakeServer(t, reply))
+			}
+			c := newTestClient(t, Option{NoCache: true}, servers...)
+			if _, err := c.GetLatestVersionKey(1); err != tc.err {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			for i, s := range servers {
+				if s.hitCount() != tc.hits[i] {
+					t.Fatalf("endpoint %d is hit %d times, want %d", i, s.hitCount(), tc.hits[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFailoverSticky(t *testing.T) {
+	key := success(keeper.KeyInfo{ID: 1, Version: 1})
+	first := newFakeServer(t, failure(errors.Sealed), key)
+	second := newFakeServer(t, key)
+	c := newTestClient(t, Option{NoCache: true, Retry: 1}, first, second)
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetLatestVersionKey(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 成功后的请求从最近一次成功的地址开始
+	if first.hitCount() != 1 || second.hitCount() != 3 {
+		t.Fatalf("endpoints are hit %d and %d times", first.hitCount(), second.hitCount())
+	}
+}
+
+func TestStaleCacheGrace(t *testing.T) {
+	expired := keeper.KeyInfo{ID: 1, Version: 1, Key: "00", Timeout: uint(time.Now().Add(-time.Minute).Unix())}
+	server := newFakeServer(t, success(expired), failure(errors.Sealed), failure(errors.RateLimited), failure(errors.NoSuchKey), failure(errors.Sealed))
+	c := newTestClient(t, Option{Grace: time.Hour}, server)
+	if got, err := c.GetLatestVersionKey(1); err != nil || got != expired {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+	// 已过期：重新请求，各副本均不可用时在宽限期内使用过期缓存
+	for _, reason := range []string{"sealed", "rate limited"} {
+		if got, err := c.GetLatestVersionKey(1); err != nil || got != expired {
+			t.Fatalf("%v: got %+v, %v", reason, got, err)
+		}
+	}
+	// 密钥已销毁：不再使用缓存
+	if _, err := c.GetLatestVersionKey(1); err != errors.NoSuchKey {
+		t.Fatalf("got %v, want NoSuchKey", err)
+	}
+	if _, err := c.GetLatestVersionKey(1); err != errors.Sealed {
+		t.Fatalf("destroyed key is served from cache: %v", err)
+	}
+	if server.hitCount() != 5 {
+		t.Fatalf("server is hit %d times, want 5", server.hitCount())
+	}
+}
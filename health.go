@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/RicheyJang/key_keeper/logic"
+	"github.com/kataras/iris/v12"
+	irisRecover "github.com/kataras/iris/v12/middleware/recover"
+	log "github.com/sirupsen/logrus"
+)
+
+// HealthServer 于独立的HTTP端口提供存活及就绪检查，使内部API可强制要求客户端证书
+func HealthServer(manager *logic.Manager, addr string) {
+	app := iris.New()
+	app.Use(irisRecover.New())
+	app.Get("/healthz", manager.HandlerOfHealthz)
+	app.Get("/readyz", manager.HandlerOfReadyz)
+	log.Infof("health server is listening on %v", addr)
+	log.Fatal(app.Run(iris.Addr(addr),
+		iris.WithoutStartupLog,
+		iris.WithoutPathCorrectionRedirection,
+		iris.WithOptimizations))
+}
@@ -2,21 +2,19 @@ package main
 
 import (
 	"crypto/tls"
-	"crypto/x509"
-	"io/ioutil"
 	stdlog "log"
 	"net/http"
 	"os"
 
 	"github.com/RicheyJang/key_keeper/logic"
-	"github.com/RicheyJang/key_keeper/utils"
 	"github.com/RicheyJang/key_keeper/utils/logger"
+	"github.com/RicheyJang/key_keeper/utils/metrics"
+	"github.com/RicheyJang/key_keeper/utils/tracing"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/core/host"
 	"github.com/kataras/iris/v12/core/router"
 	irisRecover "github.com/kataras/iris/v12/middleware/recover"
 	log "github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 )
 
 func InnerServer(manager *logic.Manager, addr string) {
@@ -24,48 +22,49 @@ func InnerServer(manager *logic.Manager, addr string) {
 	app := iris.New()
 	app.Use(irisRecover.New())
 	app.Use(logger.Iris("[Inner]")) // 日志
+	app.Use(metrics.Iris("inner"))  // 监控指标
+	app.Use(tracing.Iris("inner"))  // 链路追踪
 
 	// 设置路由
 	app.PartyFunc("/api/inner", func(inner router.Party) {
 		inner.Use(manager.PreRouterOfSetKeeper)
 		inner.Post("/key", manager.GetKeyInfo)
 		inner.Post("/version", manager.GetLatestVersionKey)
+
+		inner.Post("/encrypt", manager.Encrypt)
+		inner.Post("/decrypt", manager.Decrypt)
+		inner.Post("/generate-data-key", manager.GenerateDataKey)
+
+		inner.Post("/sign", manager.Sign)
+		inner.Post("/verify", manager.Verify)
 	})
 
 	// 启动
-	log.Fatal(app.Run(getRunner(addr),
+	log.Fatal(app.Run(getRunner(manager, addr),
 		iris.WithoutPathCorrectionRedirection,
 		iris.WithOptimizations))
 }
 
-func getRunner(addr string, hostConfigs ...host.Configurator) iris.Runner {
-	// 读取证书
-	pool := x509.NewCertPool()
-	crt, err := ioutil.ReadFile(viper.GetString("cert.ca"))
-	if err != nil {
-		log.Fatal("Failed to read CA certificate: ", err.Error())
-	}
-	pool.AppendCertsFromPEM(crt)
-	cert, err := utils.LoadCertificate(viper.GetString("cert.self"), viper.GetString("cert.private"))
-	if err != nil {
-		log.Fatal("Failed to read Server certificate: ", err.Error())
-	}
+func getRunner(manager *logic.Manager, addr string, hostConfigs ...host.Configurator) iris.Runner {
 	// 设置证书认证
 	logw, err := logger.GetWriter()
 	if err != nil {
 		logw = os.Stderr
 	}
+	tlsConfig := &tls.Config{
+		ClientAuth:            tls.RequireAndVerifyClientCert, // 须提供并检验客户端证书，健康检查由独立端口提供
+		VerifyPeerCertificate: manager.VerifyPeerCertificate,  // 检查客户端证书是否已被吊销
+		GetCertificate:        serverCerts.GetCertificate,     // 证书可热加载
+		// NextProtos: []string{"h2", "http/1.1"},
+	}
+	if err = getTLSPolicy("inner").Apply(tlsConfig); err != nil {
+		log.Fatal("Invalid TLS policy: ", err.Error())
+	}
+	tlsConfig.GetConfigForClient = serverCerts.GetConfigForClient(tlsConfig) // 客户端CA可热加载
 	s := &http.Server{
-		Addr: addr,
-		TLSConfig: &tls.Config{
-			ClientCAs:  pool,
-			ClientAuth: tls.RequireAndVerifyClientCert, // 检验客户端证书
-			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
-				return cert, nil
-			},
-			// NextProtos: []string{"h2", "http/1.1"},
-		},
-		ErrorLog: stdlog.New(logw, "[kk] ", stdlog.LstdFlags),
+		Addr:      addr,
+		TLSConfig: tlsConfig,
+		ErrorLog:  stdlog.New(logw, "[kk] ", stdlog.LstdFlags),
 	}
 	// 生成Runner
 	return func(app *iris.Application) error {
@@ -0,0 +1,135 @@
+package keeper
+
+import (
+	"strings"
+
+	"github.com/RicheyJang/key_keeper/utils/errors"
+)
+
+// Algorithm 密钥算法
+type Algorithm string
+
+const (
+	AlgorithmAESCBC           Algorithm = "aes-cbc"
+	AlgorithmAESGCM           Algorithm = "aes-gcm"
+	AlgorithmAESCTR           Algorithm = "aes-ctr"
+	AlgorithmAESXTS           Algorithm = "aes-xts"
+	AlgorithmChaCha20Poly1305 Algorithm = "chacha20-poly1305"
+	AlgorithmHMACSHA256       Algorithm = "hmac-sha256"
+	AlgorithmHMACSHA512       Algorithm = "hmac-sha512"
+	AlgorithmRSA2048          Algorithm = "rsa-2048"
+	AlgorithmRSA3072          Algorithm = "rsa-3072"
+	AlgorithmRSA4096          Algorithm = "rsa-4096"
+	AlgorithmECDSAP256        Algorithm = "ecdsa-p256"
+	AlgorithmECDSAP384        Algorithm = "ecdsa-p384"
+	AlgorithmEd25519          Algorithm = "ed25519"
+)
+
+// 算法类别
+const (
+	AlgorithmKindCipher = "cipher" // 对称加密
+	AlgorithmKindMAC    = "mac"    // 消息认证码
+	AlgorithmKindPair   = "pair"   // 非对称密钥对
+)
+
+// AlgorithmInfo 算法信息
+type AlgorithmInfo struct {
+	Name    Algorithm `json:"name"`
+	Kind    string    `json:"kind"`
+	Lengths []uint    `json:"lengths"` // 合法的密钥长度（字节），非对称算法为私钥长度
+}
+
+// IsAsymmetric 是否为非对称算法
+func (info AlgorithmInfo) IsAsymmetric() bool {
+	return info.Kind == AlgorithmKindPair
+}
+
+// ValidLength 检查密钥长度对该算法是否合法
+func (info AlgorithmInfo) ValidLength(length uint) bool {
+	for _, l := range info.Lengths {
+		if l == length {
+			return true
+		}
+	}
+	return false
+}
+
+// 已注册的算法列表
+var algorithms = []AlgorithmInfo{
+	{Name: AlgorithmAESCBC, Kind: AlgorithmKindCipher, Lengths: []uint{16, 24, 32}},
+	{Name: AlgorithmAESGCM, Kind: AlgorithmKindCipher, Lengths: []uint{16, 24, 32}},
+	{Name: AlgorithmAESCTR, Kind: AlgorithmKindCipher, Lengths: []uint{16, 24, 32}},
+	{Name: AlgorithmAESXTS, Kind: AlgorithmKindCipher, Lengths: []uint{32, 64}}, // 两个AES-128或AES-256密钥
+	{Name: AlgorithmChaCha20Poly1305, Kind: AlgorithmKindCipher, Lengths: []uint{32}},
+	{Name: AlgorithmHMACSHA256, Kind: AlgorithmKindMAC, Lengths: []uint{32, 64}},
+	{Name: AlgorithmHMACSHA512, Kind: AlgorithmKindMAC, Lengths: []uint{64, 128}},
+	{Name: AlgorithmRSA2048, Kind: AlgorithmKindPair, Lengths: []uint{256}},
+	{Name: AlgorithmRSA3072, Kind: AlgorithmKindPair, Lengths: []uint{384}},
+	{Name: AlgorithmRSA4096, Kind: AlgorithmKindPair, Lengths: []uint{512}},
+	{Name: AlgorithmECDSAP256, Kind: AlgorithmKindPair, Lengths: []uint{32}},
+	{Name: AlgorithmECDSAP384, Kind: AlgorithmKindPair, Lengths: []uint{48}},
+	{Name: AlgorithmEd25519, Kind: AlgorithmKindPair, Lengths: []uint{32}},
+}
+
+// Algorithms 获取所有已注册的算法
+func Algorithms() []AlgorithmInfo {
+	res := make([]AlgorithmInfo, len(algorithms))
+	copy(res, algorithms)
+	return res
+}
+
+// LookupAlgorithm 根据名称（不区分大小写）查找已注册的算法
+func LookupAlgorithm(name Algorithm) (AlgorithmInfo, bool) {
+	normalized := Algorithm(strings.ToLower(strings.TrimSpace(string(name))))
+	for _, info := range algorithms {
+		if info.Name == normalized {
+			return info, true
+		}
+	}
+	return AlgorithmInfo{}, false
+}
+
+// CheckAlgorithm 校验算法及密钥长度，返回已注册的算法信息
+func CheckAlgorithm(name Algorithm, length uint) (AlgorithmInfo, error) {
+	info, ok := LookupAlgorithm(name)
+	if !ok {
+		return AlgorithmInfo{}, errors.UnsupportedAlgorithm
+	}
+	if !info.ValidLength(length) {
+		return AlgorithmInfo{}, errors.InvalidKeyLength
+	}
+	return info, nil
+}
+
+// MigrateAlgorithm 将旧版本中自由填写的算法名称（如AES-256-GCM、aes_gcm）映射为已注册的算法
+func MigrateAlgorithm(name string) (Algorithm, bool) {
+	if info, ok := LookupAlgorithm(Algorithm(name)); ok {
+		return info.Name, true
+	}
+	compacted := compactAlgorithm(name)
+	for _, info := range algorithms {
+		if compactAlgorithm(string(info.Name)) == compacted {
+			return info.Name, true
+		}
+	}
+	return "", false
+}
+
+// 仅保留小写字母及数字，并去除AES名称中的密钥位数
+func compactAlgorithm(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	compacted := b.String()
+	if strings.HasPrefix(compacted, "aes") {
+		for _, bits := range []string{"128", "192", "256"} {
+			if strings.HasPrefix(compacted[3:], bits) {
+				return "aes" + compacted[3+len(bits):]
+			}
+		}
+	}
+	return compacted
+}
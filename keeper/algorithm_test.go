@@ -0,0 +1,58 @@
+package keeper
+
+import (
+	"testing"
+
+	"github.com/RicheyJang/key_keeper/utils/errors"
+)
+
+func TestMigrateAlgorithm(t *testing.T) {
+	cases := []struct {
+		name string
+		want Algorithm
+		ok   bool
+	}{
+		{"aes-gcm", AlgorithmAESGCM, true},
+		{" AES-GCM ", AlgorithmAESGCM, true},
+		{"AES-256-GCM", AlgorithmAESGCM, true},
+		{"aes_128_cbc", AlgorithmAESCBC, true},
+		{"aes256ctr", AlgorithmAESCTR, true},
+		{"ChaCha20Poly1305", AlgorithmChaCha20Poly1305, true},
+		{"HMAC_SHA256", AlgorithmHMACSHA256, true},
+		{"RSA2048", AlgorithmRSA2048, true},
+		{"AES-256-XTS", AlgorithmAESXTS, true},
+		{"aes", "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		got, ok := MigrateAlgorithm(c.name)
+		if got != c.want || ok != c.ok {
+			t.Errorf("MigrateAlgorithm(%q) = %q, %v; want %q, %v", c.name, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestCheckAlgorithm(t *testing.T) {
+	cases := []struct {
+		name   Algorithm
+		length uint
+		err    error
+	}{
+		{"aes-gcm", 32, nil},
+		{"AES-GCM", 16, nil},
+		{"aes-gcm", 20, errors.InvalidKeyLength},
+		{"ed25519", 32, nil},
+		{"ed25519", 64, errors.InvalidKeyLength},
+		{"aes-xts", 64, nil},
+		{"aes-xts", 32, nil},
+		{"aes-xts", 48, errors.InvalidKeyLength},
+		{"aes-xts", 16, errors.InvalidKeyLength},
+		{"aes-siv", 64, errors.UnsupportedAlgorithm},
+		{"", 32, errors.UnsupportedAlgorithm},
+	}
+	for _, c := range cases {
+		if _, err := CheckAlgorithm(c.name, c.length); err != c.err {
+			t.Errorf("CheckAlgorithm(%q, %d) = %v; want %v", c.name, c.length, err, c.err)
+		}
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"github.com/RicheyJang/key_keeper/utils/errors"
 )
 
+// Algorithms Example keeper仅支持的算法
+var Algorithms = []keeper.Algorithm{keeper.AlgorithmAESCBC}
+
 type KeeperEx struct{}
 
 var templateError = errors.KeeperNotSupport
@@ -15,7 +18,7 @@ var staticKey = keeper.KeyInfo{
 	Version:   1,
 	Key:       "98f318d8ed245606332427ea92011ec0",
 	Length:    16,
-	Algorithm: "aes-cbc",
+	Algorithm: keeper.AlgorithmAESCBC,
 	Timeout:   math.MaxUint32,
 }
 
@@ -43,6 +46,14 @@ func (k KeeperEx) DistributeKey(request keeper.DistributeKeyRequest) (keeper.Key
 	return staticKey, templateError
 }
 
+func (k KeeperEx) Sign(request keeper.SignRequest) (keeper.Signature, error) {
+	return keeper.Signature{}, templateError
+}
+
+func (k KeeperEx) Verify(request keeper.VerifyRequest) (bool, error) {
+	return false, templateError
+}
+
 func (k KeeperEx) FreezeKey(id uint, beFrozen bool) error {
 	return templateError
 }
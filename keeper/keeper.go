@@ -1,6 +1,9 @@
 package keeper
 
 import (
+	"context"
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -12,12 +15,13 @@ type KeyRequest struct {
 
 // KeyInfo 密钥信息
 type KeyInfo struct {
-	ID        uint   `json:"id"`        // 密钥ID
-	Version   uint   `json:"version"`   // 密钥版本
-	Key       string `json:"key"`       // 密钥内容（以16进制字符串格式）
-	Length    uint   `json:"length"`    // 密钥长度
-	Algorithm string `json:"algorithm"` // 加密算法
-	Timeout   uint   `json:"timeout"`   // 超时时间戳（需轮替）
+	ID        uint      `json:"id"`                  // 密钥ID
+	Version   uint      `json:"version"`             // 密钥版本
+	Key       string    `json:"key"`                 // 密钥内容（以16进制字符串格式）
+	Length    uint      `json:"length"`              // 密钥长度（字节），非对称算法为名义私钥长度（如rsa-2048为256），而非Key中PKCS#8 DER的长度
+	Algorithm Algorithm `json:"algorithm"`           // 加密算法
+	Timeout   uint      `json:"timeout"`             // 超时时间戳（需轮替）
+	PublicKey string    `json:"publicKey,omitempty"` // 非对称算法的公钥（PEM格式），此时Key为PKCS#8格式私钥
 }
 
 // KeysFilter 过滤要求
@@ -29,10 +33,10 @@ type KeysFilter struct {
 
 // DistributeKeyRequest 密钥派发请求
 type DistributeKeyRequest struct {
-	ID        uint   `json:"id"`
-	Length    uint   `json:"length"`       // 密钥长度
-	Algorithm string `json:"algorithm"`    // 加密算法
-	Rotation  uint   `json:"rotationTime"` // 轮替时长（单位秒）（为0则不轮替）
+	ID        uint      `json:"id"`
+	Length    uint      `json:"length"`       // 密钥长度
+	Algorithm Algorithm `json:"algorithm"`    // 加密算法
+	Rotation  uint      `json:"rotationTime"` // 轮替时长（单位秒）（为0则不轮替）
 }
 
 // KeyKeeper 密钥保管器：负责生成密钥、加密保存自己的密钥集、备份密钥等
@@ -47,10 +51,57 @@ type KeyKeeper interface {
 	Destroy() error // 熔断
 }
 
+// SignRequest 签名请求
+type SignRequest struct {
+	ID      uint   `json:"id"`
+	Version uint   `json:"version"` // 为0则使用最新版本
+	Message []byte `json:"message"`
+}
+
+// VerifyRequest 验签请求
+type VerifyRequest struct {
+	ID        uint   `json:"id"`
+	Version   uint   `json:"version"`
+	Message   []byte `json:"message"`
+	Signature []byte `json:"signature"`
+}
+
+// Signature 签名结果
+type Signature struct {
+	ID        uint      `json:"id"`
+	Version   uint      `json:"version"`
+	Algorithm Algorithm `json:"algorithm"`
+	Signature []byte    `json:"signature"`
+}
+
+// Signer 签名器（可选能力）：使用非对称或HMAC密钥签名及验签，密钥内容不离开keeper
+type Signer interface {
+	Sign(request SignRequest) (Signature, error)
+	Verify(request VerifyRequest) (bool, error)
+}
+
+// Shredder 密钥粉碎器（可选能力）：紧急熔断时立即销毁派生所有密钥所需的材料
+type Shredder interface {
+	Shred(escrow bool) error // escrow为真时保留一份托管副本以便恢复
+}
+
+// ContextBinder 上下文绑定（可选能力）：返回在ctx下执行数据库等操作的keeper，用于传递链路追踪信息
+type ContextBinder interface {
+	WithContext(ctx context.Context) KeyKeeper
+}
+
 // Option 生成Keeper时的参数
 type Option struct {
 	Identifier string
 	DB         *gorm.DB
+	RootKey    func() ([]byte, error) // 获取保护keeper主密钥的根密钥，为空代表未启用密封模式
+	Cache      CacheOption            // 密钥元数据缓存参数，keeper可忽略
+}
+
+// CacheOption 密钥元数据缓存参数
+type CacheOption struct {
+	Size    int           // 每个实例缓存的密钥数量上限，0代表不缓存
+	Refresh time.Duration // 检查其它副本修改的间隔，0代表不检查（单副本部署）
 }
 
 // Generator 生成器，用于生成一个Keeper实例
@@ -0,0 +1,122 @@
+package safer
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// 密钥元数据缓存：LRU淘汰；ModelKey创建后不再修改，仅需在派发、删除密钥时失效，
+// 数据库中实例的变更版本用于感知其它副本的修改
+type keyCache struct {
+	sync.Mutex
+	size    int
+	refresh time.Duration // 检查变更版本的间隔，不大于0代表不检查（单副本部署）
+
+	entries map[uint]*list.Element
+	lru     *list.List // 元素为ModelKey，最近使用的在前
+
+	generation uint64    // 每次清空时递增，避免并发读取将清空前的数据写回
+	version    uint64    // 已知的数据库变更版本
+	checkedAt  time.Time // 上次检查变更版本的时间
+}
+
+// 创建缓存，size不大于0时返回nil，代表不缓存
+func newKeyCache(size int, refresh time.Duration) *keyCache {
+	if size <= 0 {
+		return nil
+	}
+	return &keyCache{
+		size:    size,
+		refresh: refresh,
+		entries: make(map[uint]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// 获取缓存的密钥及当前代数，未命中时应以该代数调用put
+func (cache *keyCache) get(id uint) (ModelKey, uint64, bool) {
+	if cache == nil {
+		return ModelKey{}, 0, false
+	}
+	cache.Lock()
+	defer cache.Unlock()
+	if element, ok := cache.entries[id]; ok {
+		cache.lru.MoveToFront(element)
+		return element.Value.(ModelKey), cache.generation, true
+	}
+	return ModelKey{}, cache.generation, false
+}
+
+// 写入缓存，期间缓存已被清空时放弃写入
+func (cache *keyCache) put(generation uint64, key ModelKey) {
+	if cache == nil {
+		return
+	}
+	cache.Lock()
+	defer cache.Unlock()
+	if generation != cache.generation {
+		return
+	}
+	if element, ok := cache.entries[key.ID]; ok {
+		element.Value = key
+		cache.lru.MoveToFront(element)
+		return
+	}
+	cache.entries[key.ID] = cache.lru.PushFront(key)
+	for cache.lru.Len() > cache.size {
+		oldest := cache.lru.Back()
+		cache.lru.Remove(oldest)
+		delete(cache.entries, oldest.Value.(ModelKey).ID)
+	}
+}
+
+// 清空缓存
+func (cache *keyCache) purge() {
+	if cache == nil {
+		return
+	}
+	cache.Lock()
+	defer cache.Unlock()
+	cache.generation++
+	cache.entries = make(map[uint]*list.Element)
+	cache.lru.Init()
+}
+
+// 是否到了检查数据库变更版本的时间
+func (cache *keyCache) shouldCheck(now time.Time) bool {
+	if cache == nil || cache.refresh <= 0 {
+		return false
+	}
+	cache.Lock()
+	defer cache.Unlock()
+	if now.Sub(cache.checkedAt) < cache.refresh {
+		return false
+	}
+	cache.checkedAt = now
+	return true
+}
+
+// 检查失败：下次调用shouldCheck时重新检查
+func (cache *keyCache) recheck() {
+	if cache == nil {
+		return
+	}
+	cache.Lock()
+	cache.checkedAt = time.Time{}
+	cache.Unlock()
+}
+
+// 记录数据库变更版本，版本变化时清空缓存
+func (cache *keyCache) observe(version uint64) {
+	if cache == nil {
+		return
+	}
+	cache.Lock()
+	changed := version != cache.version
+	cache.version = version
+	cache.Unlock()
+	if changed {
+		cache.purge()
+	}
+}
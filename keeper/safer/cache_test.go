@@ -0,0 +1,178 @@
+package safer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+)
+
+func TestKeyCacheEviction(t *testing.T) {
+	cache := newKeyCache(2, 0)
+	for id := uint(1); id <= 2; id++ {
+		_, generation, _ := cache.get(id)
+		cache.put(generation, ModelKey{ID: id})
+	}
+	if _, _, ok := cache.get(1); !ok { // 1成为最近使用的
+		t.Fatal("key 1 is missing")
+	}
+	_, generation, _ := cache.get(3)
+	cache.put(generation, ModelKey{ID: 3})
+	for id, want := range map[uint]bool{1: true, 2: false, 3: true} {
+		if _, _, ok := cache.get(id); ok != want {
+			t.Errorf("key %v cached: %v, want %v", id, ok, want)
+		}
+	}
+	if cache.lru.Len() != 2 || len(cache.entries) != 2 {
+		t.Fatalf("cache holds %v/%v entries, want 2", cache.lru.Len(), len(cache.entries))
+	}
+	// 覆盖已有项不增加数量
+	cache.put(generation, ModelKey{ID: 3, Length: 32})
+	if key, _, _ := cache.get(3); key.Length != 32 || cache.lru.Len() != 2 {
+		t.Fatalf("unexpected entry %+v, %v entries", key, cache.lru.Len())
+	}
+}
+
+func TestKeyCachePurgeGeneration(t *testing.T) {
+	cache := newKeyCache(8, 0)
+	_, generation, ok := cache.get(1)
+	if ok {
+		t.Fatal("empty cache hit")
+	}
+	// 读取数据库期间缓存被清空：清空前的数据不应写回
+	cache.purge()
+	cache.put(generation, ModelKey{ID: 1})
+	if _, _, ok = cache.get(1); ok {
+		t.Fatal("stale key is written back after purge")
+	}
+	_, current, _ := cache.get(1)
+	if current != generation+1 {
+		t.Fatalf("generation %v, want %v", current, generation+1)
+	}
+	cache.put(current, ModelKey{ID: 1})
+	if _, _, ok = cache.get(1); !ok {
+		t.Fatal("key of current generation is not cached")
+	}
+}
+
+func TestKeyCacheObserve(t *testing.T) {
+	cache := newKeyCache(8, time.Minute)
+	cache.put(0, ModelKey{ID: 1})
+	cache.observe(0)
+	if _, _, ok := cache.get(1); !ok {
+		t.Fatal("cache is purged without a version change")
+	}
+	cache.observe(1)
+	if _, _, ok := cache.get(1); ok {
+		t.Fatal("cache is not purged on a version change")
+	}
+	// 检查间隔
+	now := time.Now()
+	if !cache.shouldCheck(now) || cache.shouldCheck(now.Add(time.Second)) || !cache.shouldCheck(now.Add(time.Minute)) {
+		t.Fatal("unexpected check schedule")
+	}
+	// 不缓存时各方法均可安全调用
+	var disabled *keyCache
+	if newKeyCache(0, 0) != nil {
+		t.Fatal("cache of size 0 is created")
+	}
+	disabled.put(0, ModelKey{ID: 1})
+	disabled.observe(1)
+	disabled.purge()
+	if _, _, ok := disabled.get(1); ok || disabled.shouldCheck(now) {
+		t.Fatal("disabled cache hit")
+	}
+}
+
+func TestSaferCacheObservesOtherReplica(t *testing.T) {
+	db := newTestDB(t)
+	local := newTestSafer(t, db, keeper.CacheOption{Size: 8, Refresh: time.Nanosecond})
+	remote := newTestSafer(t, db, keeper.CacheOption{Size: 8})
+	distributed, err := remote.DistributeKey(keeper.DistributeKeyRequest{ID: 1, Length: 32, Algorithm: keeper.AlgorithmAESGCM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := local.GetLatestVersionKey(1)
+	if err != nil || got.Key != distributed.Key {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+	if _, _, ok := local.cache.get(1); !ok {
+		t.Fatal("key is not cached")
+	}
+	// 另一副本销毁密钥后，本副本在下次检查时感知变更
+	if err = remote.DestroyKey(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = local.GetLatestVersionKey(1); err != errors.NoSuchKey {
+		t.Fatalf("destroyed key is still served: %v", err)
+	}
+}
+
+func TestSaferCacheObservesDestroyedInstance(t *testing.T) {
+	db := newTestDB(t)
+	local := newTestSafer(t, db, keeper.CacheOption{Size: 8, Refresh: time.Nanosecond})
+	remote := newTestSafer(t, db, keeper.CacheOption{})
+	if _, err := remote.DistributeKey(keeper.DistributeKeyRequest{ID: 1, Length: 32, Algorithm: keeper.AlgorithmAESGCM}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.GetLatestVersionKey(1); err != nil {
+		t.Fatal(err)
+	}
+	// 实例记录被删除即视为已销毁
+	if err := remote.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.GetLatestVersionKey(1); err != errors.NoSuchKey {
+		t.Fatalf("key of a destroyed instance is still served: %v", err)
+	}
+	if _, _, ok := local.cache.get(1); ok {
+		t.Fatal("cache is not purged")
+	}
+}
+
+func TestSaferCacheSkippedOnCheckError(t *testing.T) {
+	db := newTestDB(t)
+	sf := newTestSafer(t, db, keeper.CacheOption{Size: 8, Refresh: time.Nanosecond})
+	if _, err := sf.DistributeKey(keeper.DistributeKeyRequest{ID: 1, Length: 32, Algorithm: keeper.AlgorithmAESGCM}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sf.GetLatestVersionKey(1); err != nil {
+		t.Fatal(err)
+	}
+	// 无法读取实例记录时不信任缓存，直接读取数据库
+	if err := db.Migrator().DropTable(&ModelInstance{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Where("id = ?", 1).Delete(&ModelKey{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sf.GetLatestVersionKey(1); err != errors.NoSuchKey {
+		t.Fatalf("cached key is served while the check fails: %v", err)
+	}
+}
+
+func BenchmarkGetLatestVersionKey(b *testing.B) {
+	for _, size := range []int{0, 1024} {
+		b.Run(fmt.Sprintf("cache=%v", size), func(b *testing.B) {
+			sf := newTestSafer(b, newTestDB(b), keeper.CacheOption{Size: size, Refresh: time.Second})
+			for id := uint(1); id <= 16; id++ {
+				if _, err := sf.DistributeKey(keeper.DistributeKeyRequest{ID: id, Length: 32, Algorithm: keeper.AlgorithmAESGCM}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				id := uint(0)
+				for pb.Next() {
+					id = id%16 + 1
+					if _, err := sf.GetLatestVersionKey(id); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
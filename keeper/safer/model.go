@@ -3,18 +3,37 @@ package safer
 import (
 	"math"
 	"time"
+
+	"github.com/RicheyJang/key_keeper/keeper"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 type ModelInstance struct {
 	ID         uint   `gorm:"primaryKey"`
 	Identifier string `gorm:"column:identifier;uniqueIndex"`
 	Key        []byte `gorm:"column:key"`
+	Wrapped    bool   `gorm:"column:wrapped"`                    // 主密钥是否已由根密钥加密（密封模式）
+	Version    uint64 `gorm:"column:version;not null;default:0"` // 密钥变更版本，用于使其它副本的缓存失效
 }
 
 func (ins ModelInstance) TableName() string {
 	return "t_safer_instances"
 }
 
+// ModelEscrow 被粉碎主密钥的托管副本，仅供人工恢复使用
+type ModelEscrow struct {
+	ID         uint   `gorm:"primaryKey"`
+	Identifier string `gorm:"column:identifier;index"`
+	Key        []byte `gorm:"column:key"`
+	Wrapped    bool   `gorm:"column:wrapped"` // 托管副本均由根密钥加密，旧版本可能存在未加密的记录
+	CreatedAt  time.Time
+}
+
+func (escrow ModelEscrow) TableName() string {
+	return "t_safer_escrows"
+}
+
 type ModelKey struct {
 	ID         uint   `gorm:"primaryKey;autoIncrement:false"`
 	Identifier string `gorm:"primaryKey"`
@@ -22,6 +41,7 @@ type ModelKey struct {
 	Algorithm  string
 	Rotation   uint
 	SS         []byte
+	Sealed     []byte // 无法派生的私钥（如RSA）：生成后加密保存
 	CreatedAt  time.Time
 }
 
@@ -29,6 +49,30 @@ func (key ModelKey) TableName() string {
 	return "t_safer_keys"
 }
 
+// 将旧版本自由填写的算法名称统一为已注册的算法，无法识别的保持原样
+func migrateAlgorithms(db *gorm.DB) error {
+	var names []string
+	if err := db.Model(&ModelKey{}).Distinct("algorithm").Pluck("algorithm", &names).Error; err != nil {
+		return err
+	}
+	for _, name := range names {
+		algorithm, ok := keeper.MigrateAlgorithm(name)
+		if !ok {
+			log.Warnf("safer: keys with unknown algorithm %q are kept as is", name)
+			continue
+		}
+		if string(algorithm) == name {
+			continue
+		}
+		if err := db.Model(&ModelKey{}).Where("algorithm = ?", name).
+			Update("algorithm", string(algorithm)).Error; err != nil {
+			return err
+		}
+		log.Infof("safer: migrated key algorithm %q to %q", name, algorithm)
+	}
+	return nil
+}
+
 func (key ModelKey) currentVersion() uint {
 	return key.versionAt(time.Now())
 }
@@ -0,0 +1,145 @@
+package safer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+
+	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"golang.org/x/crypto/sha3"
+)
+
+// 是否需要生成后加密保存（无法由种子确定性派生）
+func needSealed(algorithm keeper.Algorithm) bool {
+	switch algorithm {
+	case keeper.AlgorithmRSA2048, keeper.AlgorithmRSA3072, keeper.AlgorithmRSA4096:
+		return true
+	}
+	return false
+}
+
+// 派生非对称密钥对所需的种子长度
+func seedLength(algorithm keeper.Algorithm) uint {
+	switch algorithm {
+	case keeper.AlgorithmEd25519:
+		return ed25519.SeedSize
+	case keeper.AlgorithmECDSAP256:
+		return 32 + 8 // 多取8字节以降低取模偏差
+	case keeper.AlgorithmECDSAP384:
+		return 48 + 8
+	}
+	return 0
+}
+
+// 由种子确定性派生密钥对，返回PKCS#8格式私钥
+func derivePair(algorithm keeper.Algorithm, seed []byte) ([]byte, error) {
+	switch algorithm {
+	case keeper.AlgorithmEd25519:
+		return x509.MarshalPKCS8PrivateKey(ed25519.NewKeyFromSeed(seed))
+	case keeper.AlgorithmECDSAP256:
+		return x509.MarshalPKCS8PrivateKey(deriveECDSA(elliptic.P256(), seed))
+	case keeper.AlgorithmECDSAP384:
+		return x509.MarshalPKCS8PrivateKey(deriveECDSA(elliptic.P384(), seed))
+	}
+	return nil, errors.UnsupportedAlgorithm
+}
+
+// 私钥 d = seed mod (N-1) + 1
+func deriveECDSA(curve elliptic.Curve, seed []byte) *ecdsa.PrivateKey {
+	n := new(big.Int).Sub(curve.Params().N, big.NewInt(1))
+	d := new(big.Int).SetBytes(seed)
+	d.Mod(d, n)
+	d.Add(d, big.NewInt(1))
+	private := &ecdsa.PrivateKey{D: d}
+	private.PublicKey.Curve = curve
+	private.PublicKey.X, private.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+	return private
+}
+
+// 随机生成密钥对，返回PKCS#8格式私钥
+func generatePair(algorithm keeper.Algorithm, length uint) ([]byte, error) {
+	if !needSealed(algorithm) {
+		return nil, errors.UnsupportedAlgorithm
+	}
+	private, err := rsa.GenerateKey(rand.Reader, int(length*8))
+	if err != nil {
+		return nil, err
+	}
+	return x509.MarshalPKCS8PrivateKey(private)
+}
+
+// 根据PKCS#8格式私钥获取PEM格式公钥
+func publicKeyPEM(der []byte) (string, error) {
+	private, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return "", err
+	}
+	var public interface{}
+	switch key := private.(type) {
+	case *rsa.PrivateKey:
+		public = key.Public()
+	case *ecdsa.PrivateKey:
+		public = key.Public()
+	case ed25519.PrivateKey:
+		public = key.Public()
+	default:
+		return "", errors.UnsupportedAlgorithm
+	}
+	publicDer, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer})), nil
+}
+
+// 加密保存私钥所用的密钥，与派生密钥内容相互独立
+func getSealKey(mainKey []byte, ss []byte) []byte {
+	hash := sha3.NewShake128()
+	_, _ = hash.Write([]byte("safer-seal"))
+	_, _ = hash.Write(mainKey)
+	_, _ = hash.Write(ss)
+	res := make([]byte, 32)
+	_, _ = hash.Read(res)
+	return res
+}
+
+// AES-GCM加密，输出格式为 nonce|密文
+func sealData(sealKey []byte, plain []byte) ([]byte, error) {
+	aead, err := newSealAEAD(sealKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// AES-GCM解密，输入格式为 nonce|密文
+func openData(sealKey []byte, sealed []byte) ([]byte, error) {
+	aead, err := newSealAEAD(sealKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.NoSuchKey
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+func newSealAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -1,12 +1,15 @@
 package safer
 
 import (
+	"context"
 	"encoding/hex"
 	"sync"
 	"time"
 
 	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/utils/crypt"
 	"github.com/RicheyJang/key_keeper/utils/errors"
+	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
@@ -20,7 +23,10 @@ func GetSafer(option keeper.Option) (keeper.KeyKeeper, error) {
 	// 初始化
 	var err error
 	migrateOnce.Do(func() {
-		err = option.DB.AutoMigrate(&ModelInstance{}, &ModelKey{})
+		if err = option.DB.AutoMigrate(&ModelInstance{}, &ModelKey{}, &ModelEscrow{}); err != nil {
+			return
+		}
+		err = migrateAlgorithms(option.DB)
 	})
 	if err != nil {
 		return nil, err
@@ -40,28 +46,62 @@ func GetSafer(option keeper.Option) (keeper.KeyKeeper, error) {
 			Identifier: option.Identifier,
 			Key:        ss,
 		}
+		if option.RootKey != nil { // 密封模式：主密钥由根密钥加密保存
+			rootKey, err := option.RootKey()
+			if err != nil {
+				return nil, err
+			}
+			if instance.Key, err = sealData(rootKey, ss); err != nil {
+				return nil, err
+			}
+			instance.Wrapped = true
+		}
 		if err = option.DB.Create(&instance).Error; err != nil {
 			return nil, err
 		}
 	}
+	cache := newKeyCache(option.Cache.Size, option.Cache.Refresh)
+	if cache != nil {
+		cache.version, cache.checkedAt = instance.Version, time.Now()
+	}
 	return &KeeperSF{
 		identifier: option.Identifier,
 		db:         option.DB,
-		mainKey:    instance.Key,
+		main: &mainKeyState{
+			key:     instance.Key,
+			wrapped: instance.Wrapped,
+			rootKey: option.RootKey,
+		},
+		cache: cache,
 	}, nil
 }
 
 type KeeperSF struct {
 	identifier string
 	db         *gorm.DB
-	mainKey    []byte
+
+	main  *mainKeyState // 主密钥，与WithContext生成的副本共享
+	cache *keyCache     // 密钥元数据缓存，为空代表不缓存
+}
+
+type mainKeyState struct {
+	sync.Mutex
+	key     []byte                 // 主密钥，wrapped为真时为根密钥加密后的主密钥
+	wrapped bool                   // 主密钥是否已由根密钥加密
+	rootKey func() ([]byte, error) // 获取根密钥，为空代表未启用密封模式
+}
+
+// WithContext 返回在ctx下执行数据库操作的副本，用于传递链路追踪信息
+func (sf *KeeperSF) WithContext(ctx context.Context) keeper.KeyKeeper {
+	clone := *sf
+	clone.db = sf.db.WithContext(ctx)
+	return &clone
 }
 
 func (sf *KeeperSF) GetKeyInfo(request keeper.KeyRequest) (keeper.KeyInfo, error) {
-	// 查找数据库
-	var key ModelKey
-	if err := sf.db.Where("identifier = ?", sf.identifier).Where("id = ?", request.ID).
-		Take(&key).Error; err != nil {
+	// 查找缓存或数据库
+	key, err := sf.loadKey(request.ID)
+	if err != nil {
 		return keeper.KeyInfo{}, err
 	}
 	// 生成密钥信息
@@ -70,22 +110,19 @@ func (sf *KeeperSF) GetKeyInfo(request keeper.KeyRequest) (keeper.KeyInfo, error
 		ID:        request.ID,
 		Version:   request.Version,
 		Length:    key.Length,
-		Algorithm: key.Algorithm,
+		Algorithm: keeper.Algorithm(key.Algorithm),
 		Timeout:   key.nextTimeoutAt(now),
 	}
-	content, err := getKeyContent(info.Length, info.ID, info.Version, sf.mainKey, key.SS)
-	if err != nil {
+	if err := sf.fillKey(&info, key); err != nil {
 		return keeper.KeyInfo{}, err
 	}
-	info.Key = hex.EncodeToString(content)
-	return info, err
+	return info, nil
 }
 
 func (sf *KeeperSF) GetLatestVersionKey(id uint) (keeper.KeyInfo, error) {
-	// 查找数据库
-	var key ModelKey
-	if err := sf.db.Where("identifier = ?", sf.identifier).Where("id = ?", id).
-		Take(&key).Error; err != nil {
+	// 查找缓存或数据库
+	key, err := sf.loadKey(id)
+	if err != nil {
 		return keeper.KeyInfo{}, err
 	}
 	// 生成密钥信息
@@ -94,15 +131,13 @@ func (sf *KeeperSF) GetLatestVersionKey(id uint) (keeper.KeyInfo, error) {
 		ID:        id,
 		Version:   key.versionAt(now),
 		Length:    key.Length,
-		Algorithm: key.Algorithm,
+		Algorithm: keeper.Algorithm(key.Algorithm),
 		Timeout:   key.nextTimeoutAt(now),
 	}
-	content, err := getKeyContent(info.Length, info.ID, info.Version, sf.mainKey, key.SS)
-	if err != nil {
+	if err := sf.fillKey(&info, key); err != nil {
 		return keeper.KeyInfo{}, err
 	}
-	info.Key = hex.EncodeToString(content)
-	return info, err
+	return info, nil
 }
 
 func (sf *KeeperSF) FilterKeys(filter keeper.KeysFilter) (keys []keeper.KeyInfo, total int64, err error) {
@@ -116,16 +151,13 @@ func (sf *KeeperSF) FilterKeys(filter keeper.KeysFilter) (keys []keeper.KeyInfo,
 			ID:        model.ID,
 			Version:   model.versionAt(now),
 			Length:    model.Length,
-			Algorithm: model.Algorithm,
+			Algorithm: keeper.Algorithm(model.Algorithm),
 			Timeout:   model.nextTimeoutAt(now),
 		}
 		if filter.Content {
-			var content []byte
-			content, err = getKeyContent(key.Length, key.ID, key.Version, sf.mainKey, model.SS)
-			if err != nil {
+			if err = sf.fillKey(&key, model); err != nil {
 				return
 			}
-			key.Key = hex.EncodeToString(content)
 		}
 		keys = append(keys, key)
 	}
@@ -138,6 +170,12 @@ func (sf *KeeperSF) FilterKeys(filter keeper.KeysFilter) (keys []keeper.KeyInfo,
 }
 
 func (sf *KeeperSF) DistributeKey(request keeper.DistributeKeyRequest) (keeper.KeyInfo, error) {
+	// 校验算法
+	algorithm, err := keeper.CheckAlgorithm(request.Algorithm, request.Length)
+	if err != nil {
+		return keeper.KeyInfo{}, err
+	}
+	request.Algorithm = algorithm.Name
 	// 构建新密钥
 	ss, err := getNewSS(ssLength)
 	if err != nil {
@@ -147,31 +185,55 @@ func (sf *KeeperSF) DistributeKey(request keeper.DistributeKeyRequest) (keeper.K
 		ID:         request.ID,
 		Identifier: sf.identifier,
 		Length:     request.Length,
-		Algorithm:  request.Algorithm,
+		Algorithm:  string(request.Algorithm),
 		Rotation:   request.Rotation,
 		SS:         ss,
 	}
+	if needSealed(request.Algorithm) { // 无法派生的私钥：生成后加密保存，不支持轮替
+		if request.Rotation != 0 {
+			return keeper.KeyInfo{}, errors.InvalidRequest
+		}
+		der, err := generatePair(request.Algorithm, request.Length)
+		if err != nil {
+			return keeper.KeyInfo{}, err
+		}
+		mainKey, err := sf.getMainKey()
+		if err != nil {
+			return keeper.KeyInfo{}, err
+		}
+		if key.Sealed, err = sealData(getSealKey(mainKey, key.SS), der); err != nil {
+			return keeper.KeyInfo{}, err
+		}
+	}
 	// 保存至数据库
 	if err = sf.db.Create(&key).Error; err != nil {
 		return keeper.KeyInfo{}, err
 	}
-	// 获取密钥信息
-	content, err := getKeyContent(key.Length, key.ID, 1, sf.mainKey, key.SS)
-	if err != nil {
-		return keeper.KeyInfo{}, err
+	if err = sf.keysChanged(); err != nil {
+		log.Errorf("key %v of safer instance %v is distributed but other replicas are not notified: %v", key.ID, sf.identifier, err)
 	}
-	return keeper.KeyInfo{
+	// 获取密钥信息
+	info := keeper.KeyInfo{
 		ID:        key.ID,
 		Version:   1,
-		Key:       hex.EncodeToString(content),
 		Length:    key.Length,
-		Algorithm: key.Algorithm,
+		Algorithm: keeper.Algorithm(key.Algorithm),
 		Timeout:   key.nextTimeoutOf(1),
-	}, nil
+	}
+	if err = sf.fillKey(&info, key); err != nil {
+		return keeper.KeyInfo{}, err
+	}
+	return info, nil
 }
 
 func (sf *KeeperSF) DestroyKey(id uint) error {
-	return sf.db.Where("identifier = ?", sf.identifier).Where("id = ?", id).Delete(&ModelKey{}).Error
+	if err := sf.db.Where("identifier = ?", sf.identifier).Where("id = ?", id).Delete(&ModelKey{}).Error; err != nil {
+		return err
+	}
+	if err := sf.keysChanged(); err != nil {
+		log.Errorf("key %v of safer instance %v is destroyed but other replicas are not notified: %v", id, sf.identifier, err)
+	}
+	return nil
 }
 
 func (sf *KeeperSF) Destroy() error {
@@ -187,9 +249,230 @@ func (sf *KeeperSF) Destroy() error {
 	if txErr != nil {
 		return txErr
 	}
+	sf.cache.purge()
+	return nil
+}
+
+func (sf *KeeperSF) Sign(request keeper.SignRequest) (keeper.Signature, error) {
+	info, err := sf.getKeyOfVersion(request.ID, request.Version)
+	if err != nil {
+		return keeper.Signature{}, err
+	}
+	content, err := hex.DecodeString(info.Key)
+	if err != nil {
+		return keeper.Signature{}, err
+	}
+	signature, err := crypt.Sign(string(info.Algorithm), content, request.Message)
+	if err != nil {
+		return keeper.Signature{}, err
+	}
+	return keeper.Signature{
+		ID:        info.ID,
+		Version:   info.Version,
+		Algorithm: info.Algorithm,
+		Signature: signature,
+	}, nil
+}
+
+func (sf *KeeperSF) Verify(request keeper.VerifyRequest) (bool, error) {
+	info, err := sf.getKeyOfVersion(request.ID, request.Version)
+	if err != nil {
+		return false, err
+	}
+	content, err := hex.DecodeString(info.Key)
+	if err != nil {
+		return false, err
+	}
+	return crypt.Verify(string(info.Algorithm), content, request.Message, request.Signature)
+}
+
+// 获取指定版本的密钥，版本为0则获取最新版本
+func (sf *KeeperSF) getKeyOfVersion(id uint, version uint) (keeper.KeyInfo, error) {
+	if version == 0 {
+		return sf.GetLatestVersionKey(id)
+	}
+	return sf.GetKeyInfo(keeper.KeyRequest{ID: id, Version: version})
+}
+
+// 获取密钥元数据：优先读取缓存，并检查其它副本是否修改过该实例的密钥（启用缓存时定期检查，否则每次检查）
+func (sf *KeeperSF) loadKey(id uint) (ModelKey, error) {
+	useCache := true
+	if sf.cache == nil || sf.cache.shouldCheck(time.Now()) {
+		if err := sf.checkInstance(); err == errors.NoSuchKey {
+			return ModelKey{}, err
+		} else if err != nil { // 无法确认其它副本是否修改过密钥：不使用缓存，并于下次调用时重新检查
+			log.Warnf("check safer instance %v error: %v", sf.identifier, err)
+			sf.cache.recheck()
+			useCache = false
+		}
+	}
+	var key ModelKey
+	var generation uint64
+	if useCache {
+		var ok bool
+		if key, generation, ok = sf.cache.get(id); ok {
+			return key, nil
+		}
+	}
+	result := sf.db.Where("identifier = ?", sf.identifier).Where("id = ?", id).Limit(1).Find(&key)
+	if result.Error != nil {
+		return ModelKey{}, result.Error
+	}
+	if result.RowsAffected == 0 { // 不存在或已销毁，不视为内部错误
+		return ModelKey{}, errors.NoSuchKey
+	}
+	if useCache {
+		sf.cache.put(generation, key)
+	}
+	return key, nil
+}
+
+// 重新读取实例记录：实例已被其它副本销毁或其主密钥已被粉碎时清除内存中的主密钥及缓存，否则按变更版本决定是否清空缓存
+func (sf *KeeperSF) checkInstance() error {
+	var instance ModelInstance
+	result := sf.db.Where("identifier = ?", sf.identifier).Limit(1).Find(&instance)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 || len(instance.Key) == 0 {
+		sf.main.Lock()
+		sf.main.wipe()
+		sf.main.Unlock()
+		sf.cache.purge()
+		return errors.NoSuchKey
+	}
+	sf.cache.observe(instance.Version)
+	return nil
+}
+
+// 密钥发生变更：清空本地缓存，并递增数据库中的变更版本以通知其它副本
+func (sf *KeeperSF) keysChanged() error {
+	sf.cache.purge()
+	return sf.db.Model(&ModelInstance{}).Where("identifier = ?", sf.identifier).
+		UpdateColumn("version", gorm.Expr("version + 1")).Error
+}
+
+// 填充密钥内容：对称算法为派生的字节串；非对称算法为PKCS#8格式私钥及PEM格式公钥
+func (sf *KeeperSF) fillKey(info *keeper.KeyInfo, key ModelKey) error {
+	mainKey, err := sf.getMainKey()
+	if err != nil {
+		return err
+	}
+	algorithm := keeper.Algorithm(key.Algorithm)
+	var content []byte
+	switch {
+	case needSealed(algorithm):
+		if info.Version != 1 { // 不支持轮替，仅有版本1
+			return errors.NoSuchKey
+		}
+		content, err = openData(getSealKey(mainKey, key.SS), key.Sealed)
+	case seedLength(algorithm) > 0:
+		var seed []byte
+		if seed, err = getKeyContent(seedLength(algorithm), info.ID, info.Version, mainKey, key.SS); err == nil {
+			content, err = derivePair(algorithm, seed)
+		}
+	default:
+		content, err = getKeyContent(info.Length, info.ID, info.Version, mainKey, key.SS)
+	}
+	if err != nil {
+		return err
+	}
+	if needSealed(algorithm) || seedLength(algorithm) > 0 {
+		if info.PublicKey, err = publicKeyPEM(content); err != nil {
+			return err
+		}
+	}
+	info.Key = hex.EncodeToString(content)
+	return nil
+}
+
+// 获取明文主密钥：密封模式下由根密钥解密，旧的明文主密钥将在首次使用时由根密钥加密保存
+func (sf *KeeperSF) getMainKey() ([]byte, error) {
+	state := sf.main
+	state.Lock()
+	defer state.Unlock()
+	if len(state.key) == 0 { // 主密钥已被粉碎
+		return nil, errors.NoSuchKey
+	}
+	if state.rootKey == nil {
+		if state.wrapped { // 未启用密封模式却存在已加密的主密钥
+			return nil, errors.Sealed
+		}
+		return append([]byte(nil), state.key...), nil // 返回副本，粉碎时不影响正在派生的调用者
+	}
+	rootKey, err := state.rootKey()
+	if err != nil {
+		return nil, err
+	}
+	if !state.wrapped {
+		wrappedKey, err := sealData(rootKey, state.key)
+		if err != nil {
+			return nil, err
+		}
+		if err = sf.db.Model(&ModelInstance{}).Where("identifier = ?", sf.identifier).
+			Updates(map[string]interface{}{"key": wrappedKey, "wrapped": true}).Error; err != nil {
+			return nil, err
+		}
+		plain := state.key
+		state.key, state.wrapped = wrappedKey, true
+		return plain, nil
+	}
+	mainKey, err := openData(rootKey, state.key)
+	if err != nil {
+		return nil, errors.Sealed
+	}
+	return mainKey, nil
+}
+
+// Shred 粉碎主密钥：此后该实例的所有密钥均无法再派生
+// 托管副本仅以根密钥加密后的形式保存，未启用密封模式时拒绝托管
+func (sf *KeeperSF) Shred(escrow bool) error {
+	state := sf.main
+	state.Lock()
+	defer state.Unlock()
+	escrowKey := state.key
+	if escrow && len(state.key) > 0 && !state.wrapped {
+		if state.rootKey == nil {
+			return errors.EscrowUnavailable
+		}
+		rootKey, err := state.rootKey()
+		if err != nil {
+			return err
+		}
+		if escrowKey, err = sealData(rootKey, state.key); err != nil {
+			return err
+		}
+	}
+	txErr := sf.db.Transaction(func(tx *gorm.DB) error {
+		if escrow && len(escrowKey) > 0 {
+			if err := tx.Create(&ModelEscrow{
+				Identifier: sf.identifier,
+				Key:        escrowKey,
+				Wrapped:    true,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		// 同时递增变更版本，使其它副本清除缓存并重新读取主密钥
+		return tx.Model(&ModelInstance{}).Where("identifier = ?", sf.identifier).
+			Updates(map[string]interface{}{"key": []byte{}, "version": gorm.Expr("version + 1")}).Error
+	})
+	if txErr != nil {
+		return txErr
+	}
+	state.wipe()
+	sf.cache.purge()
 	return nil
 }
 
+// 清零并丢弃内存中的主密钥，调用者须持有锁
+func (state *mainKeyState) wipe() {
+	for i := range state.key {
+		state.key[i] = 0
+	}
+	state.key = nil
+}
+
 func (sf *KeeperSF) setupKeysFilter(filter keeper.KeysFilter) *gorm.DB {
 	session := sf.db.Model(&ModelKey{}).Where("identifier = ?", sf.identifier).Order("id")
 	if filter.Offset > 0 {
@@ -0,0 +1,74 @@
+package safer
+
+import (
+	"bytes"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 创建使用临时数据库的keeper，同一db上的多个keeper模拟多个副本
+func newTestDB(tb testing.TB) *gorm.DB {
+	tb.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(tb.TempDir(), "kk.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = sqlDB.Close() })
+	migrateOnce = sync.Once{} // 每个测试使用新的数据库
+	return db
+}
+
+func newTestSafer(tb testing.TB, db *gorm.DB, cache keeper.CacheOption) *KeeperSF {
+	tb.Helper()
+	kp, err := GetSafer(keeper.Option{Identifier: "ins", DB: db, Cache: cache})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return kp.(*KeeperSF)
+}
+
+func TestShredObservedByOtherReplica(t *testing.T) {
+	db := newTestDB(t)
+	for _, cache := range []keeper.CacheOption{{}, {Size: 8, Refresh: time.Nanosecond}} {
+		local := newTestSafer(t, db, cache)
+		remote := newTestSafer(t, db, keeper.CacheOption{})
+		if _, err := remote.DistributeKey(keeper.DistributeKeyRequest{ID: 1, Length: 32, Algorithm: keeper.AlgorithmAESGCM}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := local.GetLatestVersionKey(1); err != nil {
+			t.Fatal(err)
+		}
+		held, err := local.getMainKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 另一副本粉碎主密钥后，本副本不再派生密钥，已取得的主密钥副本不受影响
+		if err = remote.Shred(false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = local.GetLatestVersionKey(1); err != errors.NoSuchKey {
+			t.Fatalf("cache %+v: shredded key is still served: %v", cache, err)
+		}
+		if _, err = local.getMainKey(); err != errors.NoSuchKey {
+			t.Fatalf("cache %+v: main key is still in memory: %v", cache, err)
+		}
+		if bytes.Equal(held, make([]byte, len(held))) {
+			t.Fatal("main key held by a caller is zeroed")
+		}
+		if err = remote.Destroy(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
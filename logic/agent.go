@@ -0,0 +1,252 @@
+package logic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/RicheyJang/key_keeper/client"
+	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/utils"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/kataras/iris/v12"
+	log "github.com/sirupsen/logrus"
+)
+
+// AgentUnixPrefix Agent监听地址以该前缀开头时监听Unix Socket
+const AgentUnixPrefix = "unix:"
+
+// AgentListener 监听本地地址：以unix:开头时监听仅属主可访问的Unix Socket，否则监听TCP
+func AgentListener(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, AgentUnixPrefix) {
+		return net.Listen("tcp", addr)
+	}
+	path := strings.TrimPrefix(addr, AgentUnixPrefix)
+	_ = os.Remove(path) // 清除残留的socket文件
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0600); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Agent 本地密钥缓存代理：运行于数据库主机上，向上游key keeper获取并缓存密钥，对本地提供相同的内部API
+type Agent struct {
+	client *client.Client
+	secret string // 本地请求须携带的共享密钥，为空代表不校验
+
+	sealFile string        // 缓存落盘文件路径，为空则不落盘
+	sealKey  []byte        // 落盘缓存的加密密钥
+	saved    uint64        // 已落盘的缓存修订号
+	dirty    chan struct{} // 通知后台协程落盘
+	fileLock sync.Mutex
+}
+
+// AgentOption 创建Agent时的参数
+type AgentOption struct {
+	Client   *client.Client
+	Secret   string // 本地请求须在secret请求头中携带的共享密钥，为空代表不校验
+	SealFile string // 缓存落盘文件路径，为空则不落盘
+	SealWith string // 用于派生落盘加密密钥的文件（一般为本机客户端私钥）
+}
+
+// NewAgent 创建Agent，若存在落盘缓存则从中恢复
+func NewAgent(option AgentOption) (*Agent, error) {
+	if option.Client == nil {
+		return nil, errors.New(-1, "Initial Error: agent client is nil")
+	}
+	agent := &Agent{
+		client:   option.Client,
+		secret:   option.Secret,
+		sealFile: option.SealFile,
+		dirty:    make(chan struct{}, 1),
+	}
+	if len(agent.sealFile) == 0 {
+		return agent, nil
+	}
+	// 派生落盘加密密钥
+	material, err := ioutil.ReadFile(option.SealWith)
+	if err != nil {
+		return nil, errors.Newf(-1, "Initial Error: read agent seal material failed: %v", err)
+	}
+	sum := sha256.Sum256(append([]byte("key_keeper agent cache:"), material...))
+	agent.sealKey = sum[:]
+	// 恢复落盘缓存
+	if utils.FileExists(agent.sealFile) {
+		if err = agent.loadSealedCache(); err != nil {
+			log.Warnf("load agent sealed cache failed: %v", err)
+		}
+	}
+	go agent.persistLoop()
+	return agent, nil
+}
+
+// GetKeyInfo 获取指定密钥
+func (agent *Agent) GetKeyInfo(ctx iris.Context) {
+	if !agent.checkRequest(ctx) {
+		return
+	}
+	// 解析参数
+	var req keeper.KeyRequest
+	if err := ctx.ReadJSON(&req); err != nil {
+		responseError(ctx, err)
+		return
+	}
+	// 获取密钥信息
+	key, err := agent.client.GetKeyInfo(req.ID, req.Version)
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	agent.markDirty()
+	responseSuccess(ctx, "key", key)
+}
+
+// GetLatestVersionKey 获取特定密钥ID下的最新版本密钥
+func (agent *Agent) GetLatestVersionKey(ctx iris.Context) {
+	if !agent.checkRequest(ctx) {
+		return
+	}
+	// 解析参数
+	var req keeper.KeyRequest
+	if err := ctx.ReadJSON(&req); err != nil {
+		responseError(ctx, err)
+		return
+	}
+	// 获取密钥最新版本
+	key, err := agent.client.GetLatestVersionKey(req.ID)
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	agent.markDirty()
+	responseSuccess(ctx, "key", key)
+}
+
+// Agent仅代理单个实例：请求须携带与上游一致的实例标识，并在配置共享密钥时携带之
+func (agent *Agent) checkRequest(ctx iris.Context) bool {
+	if len(agent.secret) > 0 &&
+		subtle.ConstantTimeCompare([]byte(ctx.GetHeader("secret")), []byte(agent.secret)) != 1 {
+		responseError(ctx, errors.PermissionDeny)
+		return false
+	}
+	if ctx.GetHeader("identifier") != agent.client.Identifier() {
+		responseError(ctx, errors.NoSuchInstance)
+		return false
+	}
+	return true
+}
+
+// 通知后台协程落盘，不阻塞请求
+func (agent *Agent) markDirty() {
+	if len(agent.sealFile) == 0 {
+		return
+	}
+	select {
+	case agent.dirty <- struct{}{}:
+	default: // 已有待处理的通知
+	}
+}
+
+// 后台落盘：合并期间的多次通知
+func (agent *Agent) persistLoop() {
+	for range agent.dirty {
+		agent.saveSealedCache()
+	}
+}
+
+// 将缓存加密后写入文件
+func (agent *Agent) saveSealedCache() {
+	agent.fileLock.Lock()
+	defer agent.fileLock.Unlock()
+	revision := agent.client.CacheRevision()
+	if revision == agent.saved { // 缓存未变化
+		return
+	}
+	plain, err := json.Marshal(agent.client.CacheSnapshot())
+	if err != nil {
+		log.Errorf("marshal agent cache error: %v", err)
+		return
+	}
+	sealed, err := sealWithKey(agent.sealKey, plain)
+	if err != nil {
+		log.Errorf("seal agent cache error: %v", err)
+		return
+	}
+	tmp := agent.sealFile + ".tmp"
+	if err = ioutil.WriteFile(tmp, sealed, 0600); err != nil {
+		log.Errorf("write agent cache error: %v", err)
+		return
+	}
+	if err = os.Rename(tmp, agent.sealFile); err != nil {
+		log.Errorf("write agent cache error: %v", err)
+		return
+	}
+	agent.saved = revision
+}
+
+// 从文件中解密并恢复缓存
+func (agent *Agent) loadSealedCache() error {
+	agent.fileLock.Lock()
+	defer agent.fileLock.Unlock()
+	sealed, err := ioutil.ReadFile(agent.sealFile)
+	if err != nil {
+		return err
+	}
+	plain, err := openWithKey(agent.sealKey, sealed)
+	if err != nil {
+		return err
+	}
+	var snapshot client.CacheSnapshot
+	if err = json.Unmarshal(plain, &snapshot); err != nil {
+		return err
+	}
+	agent.client.RestoreCache(snapshot)
+	agent.saved = agent.client.CacheRevision()
+	return nil
+}
+
+// AES-GCM加密，输出格式为 nonce|密文
+func sealWithKey(key []byte, plain []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// AES-GCM解密，输入格式为 nonce|密文
+func openWithKey(key []byte, sealed []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.InvalidRequest
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
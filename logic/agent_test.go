@@ -0,0 +1,213 @@
+package logic
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/client"
+	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/utils"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/kataras/iris/v12"
+)
+
+var testAgentKey = keeper.KeyInfo{ID: 1, Version: 1, Key: "00ff", Length: 2, Algorithm: keeper.AlgorithmAESGCM}
+
+// 模拟上游key keeper，返回testAgentKey
+func newTestUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	key := testAgentKey
+	key.Timeout = uint(time.Now().Add(time.Hour).Unix())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(iris.Map{"code": 0, "msg": "success", "key": key})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestAgent(t *testing.T, upstream string, option AgentOption) *Agent {
+	t.Helper()
+	c, err := client.New(client.Option{Endpoints: []string{upstream}, Identifier: "ins", TLSConfig: &tls.Config{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	option.Client = c
+	agent, err := NewAgent(option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agent
+}
+
+func writeSealMaterial(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "client.pem")
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestAgentCheckRequest(t *testing.T) {
+	agent := newTestAgent(t, newTestUpstream(t).URL, AgentOption{Secret: "s3cret"})
+	app := iris.New()
+	app.Post("/api/inner/version", agent.GetLatestVersionKey)
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		headers map[string]string
+		code    int
+	}{
+		{"ok", map[string]string{"secret": "s3cret", "identifier": "ins"}, 0},
+		{"missing secret", map[string]string{"identifier": "ins"}, errors.CodePermission},
+		{"wrong secret", map[string]string{"secret": "s3cret2", "identifier": "ins"}, errors.CodePermission},
+		{"missing identifier", map[string]string{"secret": "s3cret"}, errors.CodeRequest},
+		{"other identifier", map[string]string{"secret": "s3cret", "identifier": "other"}, errors.CodeRequest},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/inner/version", strings.NewReader(`{"id":1}`))
+			req.Header.Set("Content-Type", "application/json")
+			for k, v := range c.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			app.ServeHTTP(rec, req)
+			var resp struct {
+				Code int
+				Key  keeper.KeyInfo
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != c.code {
+				t.Fatalf("got code %v, want %v", resp.Code, c.code)
+			}
+			if c.code == 0 && resp.Key.Key != testAgentKey.Key {
+				t.Fatalf("unexpected key %+v", resp.Key)
+			}
+		})
+	}
+}
+
+func TestSealWithKey(t *testing.T) {
+	key, other := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	sealed, err := sealWithKey(key, []byte("cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := openWithKey(key, sealed); err != nil || string(plain) != "cache" {
+		t.Fatalf("got %q, %v", plain, err)
+	}
+	if _, err = openWithKey(other, sealed); err == nil {
+		t.Fatal("opened with another key")
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err = openWithKey(key, sealed); err == nil {
+		t.Fatal("tampered cache is opened")
+	}
+	if _, err = openWithKey(key, sealed[:4]); err == nil {
+		t.Fatal("truncated cache is opened")
+	}
+}
+
+func TestAgentSealedCache(t *testing.T) {
+	upstream := newTestUpstream(t)
+	sealFile := filepath.Join(t.TempDir(), "agent.cache")
+	material := writeSealMaterial(t, "client key A")
+	agent := newTestAgent(t, upstream.URL, AgentOption{SealFile: sealFile, SealWith: material})
+	if _, err := agent.client.GetLatestVersionKey(1); err != nil {
+		t.Fatal(err)
+	}
+	agent.saveSealedCache()
+	content, err := ioutil.ReadFile(sealFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, []byte(testAgentKey.Key)) {
+		t.Fatal("cache file is not sealed")
+	}
+	upstream.Close() // 此后仅能从落盘缓存中获取
+
+	// 相同的客户端私钥可恢复缓存
+	restored := newTestAgent(t, upstream.URL, AgentOption{SealFile: sealFile, SealWith: material})
+	if key, err := restored.client.GetLatestVersionKey(1); err != nil || key.Key != testAgentKey.Key {
+		t.Fatalf("got %+v, %v", key, err)
+	}
+	// 其它客户端私钥无法打开
+	other := newTestAgent(t, upstream.URL, AgentOption{SealFile: sealFile, SealWith: writeSealMaterial(t, "client key B")})
+	if err = other.loadSealedCache(); err == nil {
+		t.Fatal("cache is opened with another client key")
+	}
+	if _, err = other.client.GetLatestVersionKey(1); err == nil {
+		t.Fatal("key is restored with another client key")
+	}
+	// 被篡改的文件无法打开
+	content[len(content)-1] ^= 1
+	if err = ioutil.WriteFile(sealFile, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	tampered := newTestAgent(t, upstream.URL, AgentOption{SealFile: sealFile, SealWith: material})
+	if err = tampered.loadSealedCache(); err == nil {
+		t.Fatal("tampered cache is opened")
+	}
+}
+
+func TestAgentPersistLoop(t *testing.T) {
+	sealFile := filepath.Join(t.TempDir(), "agent.cache")
+	agent := newTestAgent(t, newTestUpstream(t).URL, AgentOption{SealFile: sealFile, SealWith: writeSealMaterial(t, "client key")})
+	if _, err := agent.client.GetLatestVersionKey(1); err != nil {
+		t.Fatal(err)
+	}
+	// 请求路径仅发出通知，由后台协程落盘
+	agent.markDirty()
+	agent.markDirty()
+	deadline := time.Now().Add(5 * time.Second)
+	for !utils.FileExists(sealFile) {
+		if time.Now().After(deadline) {
+			t.Fatal("cache is not persisted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := agent.loadSealedCache(); err != nil {
+		t.Fatalf("persisted cache cannot be loaded: %v", err)
+	}
+}
+
+func TestAgentListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kk-agent.sock")
+	if err := ioutil.WriteFile(path, nil, 0666); err != nil { // 残留的socket文件
+		t.Fatal(err)
+	}
+	l, err := AgentListener(AgentUnixPrefix + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+		t.Fatalf("socket mode %v, want 0600", info.Mode())
+	}
+	tcp, err := AgentListener("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	if tcp.Addr().Network() != "tcp" {
+		t.Fatalf("listening on %v", tcp.Addr().Network())
+	}
+}
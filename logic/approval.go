@@ -0,0 +1,325 @@
+package logic
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/model"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/kataras/iris/v12"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ApprovalOption 双人审批参数
+type ApprovalOption struct {
+	Enable bool
+	Window time.Duration // 待审批操作的有效时长
+}
+
+// 创建待审批操作并回包，返回true代表该操作需等待审批，调用者应直接返回
+func (manager *Manager) requestApproval(ctx iris.Context, opType string, identifier string, payload interface{}) bool {
+	if !manager.approval.Enable {
+		return false
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		responseError(ctx, err)
+		return true
+	}
+	self := manager.getUserClaims(ctx)
+	op := model.Operation{
+		Type:          opType,
+		Identifier:    identifier,
+		Payload:       string(data),
+		Status:        model.OperationPending,
+		RequesterID:   self.ID,
+		RequesterName: self.Name,
+		ExpireAt:      time.Now().Add(manager.approval.Window),
+	}
+	if err = manager.db.Create(&op).Error; err != nil {
+		responseError(ctx, err)
+		return true
+	}
+	log.Infof("operation %v(%v) on %q is requested by user %v(%v)", op.Type, op.ID, identifier, self.Name, self.ID)
+	responseSuccess(ctx, "operation", op)
+	return true
+}
+
+type GetOperationsRequest struct {
+	Status string `url:"status"`
+	Page   int    `url:"page"`
+	Size   int    `url:"size"`
+}
+
+// HandlerOfGetOperations 获取当前用户发起或可审批的操作列表
+func (manager *Manager) HandlerOfGetOperations(ctx iris.Context) {
+	// 校验参数
+	var request GetOperationsRequest
+	if err := ctx.ReadQuery(&request); err != nil || request.Page < 0 || request.Size < 0 {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	// 查询
+	if err := manager.expireOperations(); err != nil {
+		responseError(ctx, err)
+		return
+	}
+	offset := (request.Page - 1) * request.Size
+	if offset < 0 {
+		offset = 0
+	}
+	operations, total, err := manager.getVisibleOperations(manager.getUserClaims(ctx), request.Status, offset, request.Size)
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	responseSuccess(ctx, "data", iris.Map{
+		"operations": operations,
+		"total":      total,
+	})
+}
+
+type ReviewOperationRequest struct {
+	ID uint `json:"id"`
+}
+
+// HandlerOfApproveOperation 批准并执行待审批操作
+func (manager *Manager) HandlerOfApproveOperation(ctx iris.Context) {
+	op, ok := manager.getReviewableOperation(ctx)
+	if !ok {
+		return
+	}
+	self := manager.getUserClaims(ctx)
+	if op.RequesterID == self.ID {
+		responseError(ctx, errors.SelfApproveForbid)
+		return
+	}
+	if !manager.canApprove(self, op) {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	// 批准并执行操作
+	op, err := manager.approveOperation(op, self)
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	responseSuccess(ctx, "operation", op)
+}
+
+// 批准操作：先原子地认领待审批状态，仅认领成功者执行，避免并发审批重复执行
+func (manager *Manager) approveOperation(op model.Operation, self *UserClaims) (model.Operation, error) {
+	if err := manager.claimOperation(&op, model.OperationApproved, self); err != nil {
+		return op, err
+	}
+	execErr := manager.executeOperation(op)
+	if execErr != nil {
+		op.Status, op.Message = model.OperationFailed, execErr.Error()
+		if err := manager.db.Model(&model.Operation{}).Where("id = ?", op.ID).
+			Updates(map[string]interface{}{"status": op.Status, "message": op.Message}).Error; err != nil {
+			log.Errorf("record failure of operation %v error: %v", op.ID, err)
+		}
+	}
+	log.Infof("operation %v(%v) is approved by user %v(%v), status: %v", op.Type, op.ID, self.Name, self.ID, op.Status)
+	return op, execErr
+}
+
+// 将待审批操作置为指定状态，已不处于待审批状态时返回OperationNotPend
+func (manager *Manager) claimOperation(op *model.Operation, status string, self *UserClaims) error {
+	result := manager.db.Model(&model.Operation{}).
+		Where("id = ? AND status = ?", op.ID, model.OperationPending).
+		Updates(map[string]interface{}{
+			"status":        status,
+			"approver_id":   self.ID,
+			"approver_name": self.Name,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return errors.OperationNotPend
+	}
+	op.Status, op.ApproverID, op.ApproverName = status, self.ID, self.Name
+	return nil
+}
+
+// HandlerOfRejectOperation 拒绝待审批操作，发起者本人可撤回
+func (manager *Manager) HandlerOfRejectOperation(ctx iris.Context) {
+	op, ok := manager.getReviewableOperation(ctx)
+	if !ok {
+		return
+	}
+	self := manager.getUserClaims(ctx)
+	if op.RequesterID != self.ID && !manager.canApprove(self, op) {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	if err := manager.claimOperation(&op, model.OperationRejected, self); err != nil {
+		responseError(ctx, err)
+		return
+	}
+	responseSuccess(ctx, "operation", op)
+}
+
+// HandlerOfExpireOperations 将所有已超时的待审批操作置为过期
+func (manager *Manager) HandlerOfExpireOperations(ctx iris.Context) {
+	// 权限检查
+	self := manager.getUserClaims(ctx)
+	if self.Level < model.UserLevelAdmin {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	if err := manager.expireOperations(); err != nil {
+		responseError(ctx, err)
+		return
+	}
+	responseSuccess(ctx, "", nil)
+}
+
+// 读取请求中的待审批操作，失败时已回包
+func (manager *Manager) getReviewableOperation(ctx iris.Context) (model.Operation, bool) {
+	var request ReviewOperationRequest
+	if err := ctx.ReadJSON(&request); err != nil || request.ID == 0 {
+		responseError(ctx, errors.InvalidRequest)
+		return model.Operation{}, false
+	}
+	var op model.Operation
+	result := manager.db.Where("id = ?", request.ID).Limit(1).Find(&op)
+	if result.Error != nil {
+		responseError(ctx, result.Error)
+		return op, false
+	}
+	if result.RowsAffected == 0 {
+		responseError(ctx, errors.NoSuchOperation)
+		return op, false
+	}
+	if op.Status != model.OperationPending {
+		responseError(ctx, errors.OperationNotPend)
+		return op, false
+	}
+	if time.Now().After(op.ExpireAt) {
+		_ = manager.db.Model(&op).Update("status", model.OperationExpired).Error
+		responseError(ctx, errors.OperationExpired)
+		return op, false
+	}
+	return op, true
+}
+
+// 在数据库中筛选并分页用户发起或可审批的操作，limit为0代表不分页
+func (manager *Manager) getVisibleOperations(user *UserClaims, status string, offset, limit int) ([]model.Operation, int64, error) {
+	visible := manager.db.Where("requester_id = ?", user.ID)
+	if user.Level >= model.UserLevelRoot {
+		visible = visible.Or("type = ?", model.OperationSetRootLevel)
+	}
+	instanceTypes := []string{model.OperationDestroyKey, model.OperationDestroyInstance}
+	if user.Level >= model.UserLevelAdmin {
+		instanceTypes = append(instanceTypes, model.OperationIssueCert)
+	}
+	identifiers, all, err := manager.getAccessibleIdentifiers(user)
+	if err != nil {
+		return nil, 0, err
+	}
+	if all {
+		visible = visible.Or("type IN ?", instanceTypes)
+	} else if len(identifiers) > 0 {
+		visible = visible.Or("type IN ? AND identifier IN ?", instanceTypes, identifiers)
+	}
+	session := manager.db.Model(&model.Operation{}).Where(visible)
+	if len(status) > 0 {
+		session = session.Where("status = ?", status)
+	}
+	session = session.Session(&gorm.Session{})
+	var total int64
+	if err = session.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	operations := make([]model.Operation, 0)
+	if err = paginateSession(session.Order("id desc"), offset, limit).Find(&operations).Error; err != nil {
+		return nil, 0, err
+	}
+	return operations, total, nil
+}
+
+// 检查用户是否有资格审批该操作
+func (manager *Manager) canApprove(user *UserClaims, op model.Operation) bool {
+	if user.ID == op.RequesterID {
+		return false
+	}
+	switch op.Type {
+	case model.OperationSetRootLevel:
+		return user.Level >= model.UserLevelRoot
+	case model.OperationDestroyKey, model.OperationDestroyInstance:
+		return manager.canAccessIdentifier(user, op.Identifier)
+	case model.OperationIssueCert:
+		return user.Level >= model.UserLevelAdmin && manager.canAccessIdentifier(user, op.Identifier)
+	}
+	return false
+}
+
+// 执行已批准的操作
+func (manager *Manager) executeOperation(op model.Operation) error {
+	switch op.Type {
+	case model.OperationDestroyKey:
+		var payload destroyKeyPayload
+		if err := json.Unmarshal([]byte(op.Payload), &payload); err != nil {
+			return err
+		}
+		info, ok := manager.getInstance(op.Identifier)
+		if !ok {
+			return errors.NoSuchInstance
+		}
+		if err := info.kp.DestroyKey(payload.ID); err != nil {
+			return err
+		}
+		manager.publicKeys.remove(op.Identifier, payload.ID)
+		return nil
+	case model.OperationDestroyInstance:
+		return manager.destroyInstance(op.Identifier)
+	case model.OperationIssueCert: // 批准即授权，由发起者凭操作ID签发
+		return nil
+	case model.OperationSetRootLevel:
+		var payload SetUserLevelRequest
+		if err := json.Unmarshal([]byte(op.Payload), &payload); err != nil {
+			return err
+		}
+		return manager.userManager.SetLevel(payload.UserID, payload.Level)
+	}
+	return errors.InvalidRequest
+}
+
+// 使用已批准的操作：仅发起者可使用一次，且须在批准后的审批有效时长内使用
+func (manager *Manager) useApprovedOperation(id uint, opType string, self *UserClaims) (model.Operation, error) {
+	var op model.Operation
+	result := manager.db.Where("id = ?", id).Limit(1).Find(&op)
+	if result.Error != nil {
+		return op, result.Error
+	}
+	if result.RowsAffected == 0 || op.Type != opType || op.RequesterID != self.ID {
+		return op, errors.NoSuchOperation
+	}
+	if op.Status != model.OperationApproved {
+		return op, errors.OperationNotAppr
+	}
+	if time.Now().After(op.UpdatedAt.Add(manager.approval.Window)) {
+		return op, errors.OperationExpired
+	}
+	result = manager.db.Model(&model.Operation{}).
+		Where("id = ? AND status = ?", op.ID, model.OperationApproved).
+		Update("status", model.OperationUsed)
+	if result.Error != nil {
+		return op, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return op, errors.OperationNotAppr
+	}
+	op.Status = model.OperationUsed
+	return op, nil
+}
+
+// 将已超时的待审批操作置为过期
+func (manager *Manager) expireOperations() error {
+	return manager.db.Model(&model.Operation{}).
+		Where("status = ? AND expire_at < ?", model.OperationPending, time.Now()).
+		Update("status", model.OperationExpired).Error
+}
@@ -0,0 +1,224 @@
+package logic
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/model"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+)
+
+func createTestOperation(t *testing.T, manager *Manager, status string) model.Operation {
+	t.Helper()
+	op := model.Operation{
+		Type:        model.OperationDestroyKey,
+		Identifier:  "missing",
+		Payload:     `{"id":1}`,
+		Status:      status,
+		RequesterID: 1,
+		ExpireAt:    time.Now().Add(time.Hour),
+	}
+	if err := manager.db.Create(&op).Error; err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+func TestClaimOperation(t *testing.T) {
+	cases := []struct {
+		from string
+		to   string
+		err  error
+	}{
+		{model.OperationPending, model.OperationApproved, nil},
+		{model.OperationPending, model.OperationRejected, nil},
+		{model.OperationApproved, model.OperationRejected, errors.OperationNotPend},
+		{model.OperationRejected, model.OperationApproved, errors.OperationNotPend},
+		{model.OperationExpired, model.OperationApproved, errors.OperationNotPend},
+		{model.OperationFailed, model.OperationApproved, errors.OperationNotPend},
+	}
+	manager := newTestManager(t, &model.Operation{})
+	approver := &UserClaims{ID: 2, Name: "approver"}
+	for _, c := range cases {
+		op := createTestOperation(t, manager, c.from)
+		if err := manager.claimOperation(&op, c.to, approver); err != c.err {
+			t.Errorf("%s -> %s: got %v, want %v", c.from, c.to, err, c.err)
+			continue
+		}
+		var stored model.Operation
+		manager.db.First(&stored, op.ID)
+		want := c.from
+		if c.err == nil {
+			want = c.to
+		}
+		if stored.Status != want {
+			t.Errorf("%s -> %s: stored status %s, want %s", c.from, c.to, stored.Status, want)
+		}
+	}
+}
+
+func TestClaimOperationOnce(t *testing.T) {
+	manager := newTestManager(t, &model.Operation{})
+	op := createTestOperation(t, manager, model.OperationPending)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	claimed := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(id uint) {
+			defer wg.Done()
+			copied := op
+			if manager.claimOperation(&copied, model.OperationApproved, &UserClaims{ID: id}) == nil {
+				mu.Lock()
+				claimed++
+				mu.Unlock()
+			}
+		}(uint(i + 2))
+	}
+	wg.Wait()
+	if claimed != 1 {
+		t.Fatalf("operation claimed %d times", claimed)
+	}
+}
+
+func TestApproveOperationFailed(t *testing.T) {
+	manager := newTestManager(t, &model.Operation{})
+	op := createTestOperation(t, manager, model.OperationPending)
+	// 实例不存在：执行失败，状态记为failed且不可再次审批
+	op, err := manager.approveOperation(op, &UserClaims{ID: 2, Name: "approver"})
+	if err != errors.NoSuchInstance {
+		t.Fatalf("got %v, want %v", err, errors.NoSuchInstance)
+	}
+	var stored model.Operation
+	manager.db.First(&stored, op.ID)
+	if stored.Status != model.OperationFailed || stored.Message != errors.NoSuchInstance.Error() || stored.ApproverID != 2 {
+		t.Fatalf("unexpected stored operation: %+v", stored)
+	}
+	if _, err = manager.approveOperation(stored, &UserClaims{ID: 3}); err != errors.OperationNotPend {
+		t.Fatalf("second approval: got %v", err)
+	}
+}
+
+func TestExpireOperations(t *testing.T) {
+	manager := newTestManager(t, &model.Operation{})
+	expired := createTestOperation(t, manager, model.OperationPending)
+	manager.db.Model(&expired).Update("expire_at", time.Now().Add(-time.Minute))
+	pending := createTestOperation(t, manager, model.OperationPending)
+	if err := manager.expireOperations(); err != nil {
+		t.Fatal(err)
+	}
+	for op, want := range map[uint]string{expired.ID: model.OperationExpired, pending.ID: model.OperationPending} {
+		var stored model.Operation
+		manager.db.First(&stored, op)
+		if stored.Status != want {
+			t.Errorf("operation %d: got %s, want %s", op, stored.Status, want)
+		}
+	}
+}
+
+func TestUseApprovedOperation(t *testing.T) {
+	manager := newTestManager(t, &model.Operation{})
+	manager.approval = ApprovalOption{Enable: true, Window: time.Hour}
+	requester := &UserClaims{ID: 1, Name: "requester"}
+	op := createTestOperation(t, manager, model.OperationApproved)
+	manager.db.Model(&op).Update("type", model.OperationIssueCert)
+
+	if _, err := manager.useApprovedOperation(op.ID, model.OperationDestroyKey, requester); err != errors.NoSuchOperation {
+		t.Fatalf("wrong type: got %v", err)
+	}
+	if _, err := manager.useApprovedOperation(op.ID, model.OperationIssueCert, &UserClaims{ID: 2}); err != errors.NoSuchOperation {
+		t.Fatalf("other user: got %v", err)
+	}
+	used, err := manager.useApprovedOperation(op.ID, model.OperationIssueCert, requester)
+	if err != nil || used.Status != model.OperationUsed {
+		t.Fatalf("first use: got %v, status %v", err, used.Status)
+	}
+	if _, err = manager.useApprovedOperation(op.ID, model.OperationIssueCert, requester); err != errors.OperationNotAppr {
+		t.Fatalf("second use: got %v", err)
+	}
+	// 未批准及批准已久的操作不可使用
+	pending := createTestOperation(t, manager, model.OperationPending)
+	manager.db.Model(&pending).Update("type", model.OperationIssueCert)
+	if _, err = manager.useApprovedOperation(pending.ID, model.OperationIssueCert, requester); err != errors.OperationNotAppr {
+		t.Fatalf("pending: got %v", err)
+	}
+	stale := createTestOperation(t, manager, model.OperationApproved)
+	manager.db.Model(&model.Operation{}).Where("id = ?", stale.ID).
+		UpdateColumns(map[string]interface{}{"type": model.OperationIssueCert, "updated_at": time.Now().Add(-2 * time.Hour)})
+	if _, err = manager.useApprovedOperation(stale.ID, model.OperationIssueCert, requester); err != errors.OperationExpired {
+		t.Fatalf("stale: got %v", err)
+	}
+}
+
+func TestGetVisibleOperations(t *testing.T) {
+	manager := newTestManager(t, &model.Operation{}, &model.Instance{})
+	for _, instance := range []model.Instance{
+		{Identifier: "ins-a", Users: "2"},
+		{Identifier: "ins-b", Users: "3"},
+		{Identifier: "killed", Users: "2", IsKilled: true}, // 已熔断的实例仅存在于数据库中
+	} {
+		if err := manager.db.Create(&instance).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	ops := []model.Operation{
+		{Type: model.OperationDestroyKey, Identifier: "ins-a", RequesterID: 1},
+		{Type: model.OperationDestroyKey, Identifier: "ins-b", RequesterID: 1},
+		{Type: model.OperationDestroyInstance, Identifier: "killed", RequesterID: 1},
+		{Type: model.OperationIssueCert, Identifier: "ins-a", RequesterID: 1},
+		{Type: model.OperationSetRootLevel, RequesterID: 1},
+		{Type: model.OperationDestroyKey, Identifier: "ins-b", RequesterID: 2, Status: model.OperationRejected},
+	}
+	for i := range ops {
+		if ops[i].Status == "" {
+			ops[i].Status = model.OperationPending
+		}
+		ops[i].ExpireAt = time.Now().Add(time.Hour)
+		if err := manager.db.Create(&ops[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := func(operations []model.Operation) []uint {
+		res := make([]uint, 0, len(operations))
+		for _, op := range operations {
+			res = append(res, op.ID)
+		}
+		return res
+	}
+	cases := []struct {
+		name   string
+		user   *UserClaims
+		status string
+		want   []uint
+	}{
+		{"general", &UserClaims{ID: 2, Level: model.UserLevelGeneral}, "", []uint{6, 3, 1}},
+		{"admin", &UserClaims{ID: 2, Level: model.UserLevelAdmin}, "", []uint{6, 4, 3, 1}},
+		{"status", &UserClaims{ID: 2, Level: model.UserLevelAdmin}, model.OperationPending, []uint{4, 3, 1}},
+		{"root", &UserClaims{ID: 9, Level: model.UserLevelRoot}, "", []uint{6, 5, 4, 3, 2, 1}},
+		{"scoped root", &UserClaims{ID: 9, Level: model.UserLevelRoot, Instances: []string{"ins-b"}}, "", []uint{6, 5, 2}},
+		{"scoped token", &UserClaims{ID: 2, Level: model.UserLevelAdmin, Instances: []string{"ins-b"}}, "", []uint{6}},
+		{"no access", &UserClaims{ID: 4, Level: model.UserLevelAdmin}, "", []uint{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			operations, total, err := manager.getVisibleOperations(c.user, c.status, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(operations); fmt.Sprint(got) != fmt.Sprint(c.want) || total != int64(len(c.want)) {
+				t.Fatalf("got %v (total %v), want %v", got, total, c.want)
+			}
+		})
+	}
+	// 数据库分页，总数不受分页影响
+	operations, total, err := manager.getVisibleOperations(&UserClaims{ID: 9, Level: model.UserLevelRoot}, "", 2, 3)
+	if err != nil || fmt.Sprint(ids(operations)) != "[4 3 2]" || total != 6 {
+		t.Fatalf("got %v (total %v), %v", ids(operations), total, err)
+	}
+	// 已熔断实例的审批权限依据数据库中的记录判断
+	if !manager.canApprove(&UserClaims{ID: 2}, ops[2]) || manager.canApprove(&UserClaims{ID: 3}, ops[2]) {
+		t.Fatal("unexpected approval permission on killed instance")
+	}
+}
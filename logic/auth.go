@@ -1,17 +1,19 @@
 package logic
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"sync"
 	"time"
 
 	"github.com/RicheyJang/key_keeper/model"
 	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/RicheyJang/key_keeper/utils/metrics"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/middleware/jwt"
 	orgjwt "github.com/kataras/jwt"
 	log "github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 )
 
 // UserLoginRequest is the request struct for user login
@@ -24,6 +26,23 @@ type UserClaims struct {
 	ID    uint
 	Name  string
 	Level int
+
+	SessionID string   `json:",omitempty"` // 所属登录会话ID
+	TokenID   uint     `json:",omitempty"` // 通过API令牌认证时的令牌ID
+	Instances []string `json:",omitempty"` // 通过API令牌认证时可访问的实例，为空代表不限
+}
+
+// AllowInstance 是否允许访问该实例（API令牌可限定实例）
+func (uc UserClaims) AllowInstance(identifier string) bool {
+	if len(uc.Instances) == 0 {
+		return true
+	}
+	for _, allowed := range uc.Instances {
+		if allowed == identifier {
+			return true
+		}
+	}
+	return false
 }
 
 // Validate UserClaims验证函数，将在JWT Verify时调用
@@ -37,13 +56,7 @@ func (uc UserClaims) Validate() error {
 
 // GetLoginHandler 获取登录处理函数
 func (manager *Manager) GetLoginHandler() iris.Handler {
-	secret := get256SecretKey()
-	maxAge := viper.GetDuration("user.maxAge")
-	if maxAge < time.Minute {
-		maxAge = time.Minute
-	}
-
-	signer := jwt.NewSigner(jwt.HS256, secret, maxAge)
+	signer := newLoginSigner()
 	return func(ctx iris.Context) {
 		// 绑定请求
 		var req UserLoginRequest
@@ -52,69 +65,97 @@ func (manager *Manager) GetLoginHandler() iris.Handler {
 			return
 		}
 		// 校验
-		user, err := manager.userManager.CheckUser(req.Username, req.Password)
-		if err != nil {
-			responseError(ctx, errors.WrongPasswd)
+		user, ok := manager.checkPassword(ctx, req.Username, req.Password)
+		if !ok {
 			return
 		}
-		if user.IsFrozen {
+		if manager.isUserFrozen(user) {
 			responseError(ctx, errors.UserFrozen)
 			return
 		}
-		// 生成token
-		claims := UserClaims{
-			ID:    user.ID,
-			Name:  user.Name,
-			Level: user.Level,
+		// 需双因素认证时返回中间挑战
+		if user.TOTPEnabled || require2FA(user.Level) {
+			manager.responseChallenge(ctx, user)
+			return
 		}
-		token, err := signer.Sign(claims)
-		if err != nil {
-			log.Errorf("sign token error: %v", err)
-			responseError(ctx, errors.Unknown)
+		// 生成token
+		data, ok := manager.signIn(ctx, signer, user)
+		if !ok {
 			return
 		}
-		// 记录用户登录时间、登录IP
-		_ = manager.userManager.SaveUserLoginInfo(model.User{
-			ID:        user.ID,
-			LastIP:    ctx.RemoteAddr(),
-			LastLogin: time.Now(),
-		})
-		responseSuccess(ctx, "data", iris.Map{
-			"token":    string(token),
-			"username": user.Name,
-			"level":    user.Level,
-		})
+		responseSuccess(ctx, "data", data)
 	}
 }
 
-// GetVerifyHandler 获取验证处理函数
-func (manager *Manager) GetVerifyHandler() iris.Handler {
-	secret := get256SecretKey()
-	maxAge := viper.GetDuration("user.maxAge")
-	if maxAge < time.Minute {
-		maxAge = time.Minute
+// 用户是否被冻结：数据库记录或此次运行中被冻结
+func (manager *Manager) isUserFrozen(user model.User) bool {
+	_, ok := manager.frozenUsers.Load(user.ID)
+	return ok || user.IsFrozen
+}
+
+// 创建会话、生成登录token并记录登录信息，失败时已回包
+func (manager *Manager) signIn(ctx iris.Context, signer *jwt.Signer, user model.User) (iris.Map, bool) {
+	session, refresh, err := manager.createSession(ctx, user.ID)
+	if err != nil {
+		log.Errorf("create session error: %v", err)
+		responseError(ctx, errors.Unknown)
+		return nil, false
 	}
+	data, ok := manager.signAccessToken(ctx, signer, user, session, refresh)
+	if !ok {
+		return nil, false
+	}
+	// 记录用户登录时间、登录IP，清除失败记录
+	metrics.ObserveLogin(true)
+	manager.clearLoginFailure(ctx, user)
+	_ = manager.userManager.SaveUserLoginInfo(model.User{
+		ID:        user.ID,
+		LastIP:    ctx.RemoteAddr(),
+		LastLogin: time.Now(),
+	})
+	return data, true
+}
+
+// 有效时长在签发时指定，以支持配置热加载
+func newLoginSigner() *jwt.Signer {
+	return jwt.NewSigner(jwt.HS256, get256SecretKey(), 0)
+}
 
+// GetVerifyHandler 获取验证处理函数
+func (manager *Manager) GetVerifyHandler() iris.Handler {
 	// 创建验证器
-	verifier := jwt.NewVerifier(jwt.HS256, secret)
-	verifier.Blocklist = orgjwt.NewBlocklist(maxAge)
+	verifier := jwt.NewVerifier(jwt.HS256, get256SecretKey())
+	verifier.Blocklist = orgjwt.NewBlocklist(accessMaxAge())
 	verifier.Extractors = []jwt.TokenExtractor{jwt.FromHeader} // extract token only from Authorization: Bearer $token
 	verifier.ErrorHandler = func(ctx iris.Context, err error) {
 		responseError(ctx, errors.InvalidToken)
 	}
-	return verifier.Verify(func() interface{} {
+	verify := verifier.Verify(func() interface{} {
 		return new(UserClaims)
 	})
+	return func(ctx iris.Context) {
+		if token, ok := isAPITokenRequest(ctx); ok { // API令牌
+			manager.verifyAPIToken(ctx, token)
+			return
+		}
+		verify(ctx)
+	}
 }
 
 // HandlerOfLogout 注销处理函数
 func (manager *Manager) HandlerOfLogout(ctx iris.Context) {
+	if claims := manager.getUserClaims(ctx); len(claims.SessionID) > 0 {
+		_ = manager.db.Model(&model.Session{}).Where("id = ?", claims.SessionID).Update("is_revoked", true).Error
+	}
 	_ = ctx.Logout()
 	responseSuccess(ctx, "", nil)
 }
 
 // 获取用户token内信息
 func (manager *Manager) getUserClaims(ctx iris.Context) *UserClaims {
+	if claims, ok := ctx.Values().Get(ctxAPITokenClaimsKey).(*UserClaims); ok && claims != nil {
+		return claims
+	}
 	claims, ok := jwt.Get(ctx).(*UserClaims)
 	if !ok || claims == nil {
 		return new(UserClaims)
@@ -124,11 +165,7 @@ func (manager *Manager) getUserClaims(ctx iris.Context) *UserClaims {
 
 // 获取用户token内信息
 func (manager *Manager) getUserID(ctx iris.Context) uint {
-	claims, ok := jwt.Get(ctx).(*UserClaims)
-	if !ok || claims == nil {
-		return 0
-	}
-	return claims.ID
+	return manager.getUserClaims(ctx).ID
 }
 
 var jwtSecretKey []byte
@@ -145,3 +182,10 @@ func get256SecretKey() []byte {
 	})
 	return jwtSecretKey
 }
+
+// 由登录token密钥派生指定用途的密钥
+func deriveSecretKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, get256SecretKey())
+	_, _ = mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
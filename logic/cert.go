@@ -0,0 +1,306 @@
+package logic
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/model"
+	"github.com/RicheyJang/key_keeper/utils/ca"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/kataras/iris/v12"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	certFormatPEM    = "pem"
+	certFormatPKCS12 = "pkcs12"
+
+	defaultCertValidity = 365 * 24 * time.Hour
+)
+
+// 初始化内置CA
+func (manager *Manager) initCertificates(authority *ca.Authority) error {
+	manager.authority = authority
+	if err := manager.db.AutoMigrate(&model.Certificate{}); err != nil {
+		return err
+	}
+	// 未过期的证书将与本地吊销列表一同加载，用于内部API校验证书绑定及吊销状态
+	return nil
+}
+
+// 检查内部API请求的客户端证书：须提供证书且未吊销，内置CA签发的证书CN须与所请求的实例一致
+func (manager *Manager) checkClientCert(ctx iris.Context, identifier string) error {
+	state := ctx.Request().TLS
+	if state == nil || len(state.PeerCertificates) == 0 { // 握手时已强制要求，此处防御性检查
+		return errors.NoClientCert
+	}
+	// 握手时的吊销检查不覆盖此后被吊销的长连接，每次请求均需检查
+	leaf := state.PeerCertificates[0]
+	if manager.isCertRevoked(leaf) {
+		return errors.CertRevoked
+	}
+	cert, err := manager.getIssuedCert(leaf)
+	if err != nil || cert == nil { // 查询失败或外部签发的证书
+		return err
+	}
+	if !cert.IsValid(time.Now()) {
+		return errors.CertRevoked
+	}
+	if cert.Identifier != identifier {
+		log.Warnf("certificate %v of instance %v is used for instance %v", cert.Serial, cert.Identifier, identifier)
+		return errors.PermissionDeny
+	}
+	return nil
+}
+
+// 缓存的已签发证书及其读取时间
+type issuedCert struct {
+	model.Certificate
+	loadedAt time.Time
+}
+
+func (manager *Manager) storeIssuedCert(cert model.Certificate) {
+	manager.issuedCerts.Store(cert.Serial, issuedCert{Certificate: cert, loadedAt: time.Now()})
+}
+
+// 获取内置CA签发的证书记录，外部签发的证书返回nil：
+// 由内置CA签名但未缓存（如由其它副本签发）或缓存已超过有效时长时，重新查询数据库以获取最新的吊销状态
+func (manager *Manager) getIssuedCert(leaf *x509.Certificate) (*model.Certificate, error) {
+	serial := ca.SerialString(leaf.SerialNumber)
+	if value, ok := manager.issuedCerts.Load(serial); ok && time.Since(value.(issuedCert).loadedAt) < manager.issuedCertTTL {
+		cert := value.(issuedCert).Certificate
+		return &cert, nil
+	}
+	if manager.authority == nil || leaf.CheckSignatureFrom(manager.authority.Cert) != nil {
+		return nil, nil
+	}
+	var cert model.Certificate
+	result := manager.db.Where("serial = ?", serial).Limit(1).Find(&cert)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		manager.issuedCerts.Delete(serial)
+		return nil, nil
+	}
+	manager.storeIssuedCert(cert)
+	return &cert, nil
+}
+
+type GetCertsRequest struct {
+	Identifier string `url:"identifier"`
+}
+
+// HandlerOfGetCerts 获取实例的客户端证书列表
+func (manager *Manager) HandlerOfGetCerts(ctx iris.Context) {
+	// 校验参数
+	var request GetCertsRequest
+	if err := ctx.ReadQuery(&request); err != nil || len(request.Identifier) == 0 {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	if err := manager.checkCertInstance(ctx, request.Identifier); err != nil {
+		responseError(ctx, err)
+		return
+	}
+	// 查询
+	certs := make([]model.Certificate, 0)
+	if err := manager.db.Where("identifier = ?", request.Identifier).Order("id desc").Find(&certs).Error; err != nil {
+		responseError(ctx, err)
+		return
+	}
+	responseSuccess(ctx, "certs", certs)
+}
+
+type IssueCertRequest struct {
+	Identifier string `json:"identifier"`
+	Days       int    `json:"days"`      // 有效天数，为空使用默认值
+	Format     string `json:"format"`    // pem（默认）或pkcs12
+	Password   string `json:"password"`  // PKCS#12文件密码
+	Operation  uint   `json:"operation"` // 需双人审批时，已批准的签发操作ID
+}
+
+// 签发证书操作参数，不含PKCS#12文件密码
+type issueCertPayload struct {
+	Days   int    `json:"days"`
+	Format string `json:"format"`
+}
+
+// HandlerOfIssueCert 为实例签发客户端证书，私钥仅在此返回一次
+func (manager *Manager) HandlerOfIssueCert(ctx iris.Context) {
+	if manager.authority == nil {
+		responseError(ctx, errors.CANotEnabled)
+		return
+	}
+	// 权限检查
+	self := manager.getUserClaims(ctx)
+	if self.Level < model.UserLevelAdmin {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	// 校验参数
+	var request IssueCertRequest
+	if err := ctx.ReadJSON(&request); err != nil {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	if request.Days < 0 || (request.Format != "" && request.Format != certFormatPEM && request.Format != certFormatPKCS12) {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	if _, err := manager.getInstanceAndCheckUser(request.Identifier, ctx); err != nil {
+		responseError(ctx, err)
+		return
+	}
+	// 需双人审批时：首次请求创建待审批操作，批准后发起者携带操作ID按所批准的参数签发
+	if manager.approval.Enable && request.Operation != 0 {
+		op, err := manager.useApprovedOperation(request.Operation, model.OperationIssueCert, self)
+		if err == nil && op.Identifier != request.Identifier {
+			err = errors.NoSuchOperation
+		}
+		var payload issueCertPayload
+		if err == nil {
+			err = json.Unmarshal([]byte(op.Payload), &payload)
+		}
+		if err != nil {
+			responseError(ctx, err)
+			return
+		}
+		request.Days, request.Format = payload.Days, payload.Format
+	} else if manager.requestApproval(ctx, model.OperationIssueCert, request.Identifier,
+		issueCertPayload{Days: request.Days, Format: request.Format}) {
+		return
+	}
+	validity := defaultCertValidity
+	if request.Days > 0 {
+		validity = time.Duration(request.Days) * 24 * time.Hour
+	}
+	// 签发
+	certPEM, key, x509Cert, err := manager.authority.IssueClient(request.Identifier, validity)
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	cert := model.Certificate{
+		Serial:     ca.SerialString(x509Cert.SerialNumber),
+		Identifier: request.Identifier,
+		CertPEM:    string(certPEM),
+		NotBefore:  x509Cert.NotBefore,
+		NotAfter:   x509Cert.NotAfter,
+		UserID:     self.ID,
+	}
+	if err = manager.db.Create(&cert).Error; err != nil {
+		responseError(ctx, err)
+		return
+	}
+	manager.storeIssuedCert(cert)
+	log.Infof("certificate %v of instance %v is issued by user %v(%v)", cert.Serial, cert.Identifier, self.Name, self.ID)
+	// 回包
+	if request.Format == certFormatPKCS12 {
+		p12, err := manager.authority.EncodePKCS12(x509Cert, key, request.Password)
+		if err != nil {
+			responseError(ctx, err)
+			return
+		}
+		ctx.ContentType("application/x-pkcs12")
+		ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.p12"`, cert.Identifier))
+		_, _ = ctx.Write(p12)
+		return
+	}
+	keyPEM, err := ca.EncodePrivateKey(key)
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	responseSuccess(ctx, "data", iris.Map{
+		"info":        cert,
+		"certificate": string(certPEM),
+		"privateKey":  string(keyPEM),
+		"ca":          string(manager.authority.CertPEM),
+	})
+}
+
+// HandlerOfDownloadCert 下载客户端证书（PEM格式，不含私钥）
+func (manager *Manager) HandlerOfDownloadCert(ctx iris.Context) {
+	cert, err := manager.getCertAndCheckUser(ctx)
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	ctx.ContentType("application/x-pem-file")
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.crt"`, cert.Identifier, cert.Serial))
+	_, _ = ctx.WriteString(cert.CertPEM)
+}
+
+// HandlerOfRevokeCert 吊销客户端证书
+func (manager *Manager) HandlerOfRevokeCert(ctx iris.Context) {
+	cert, err := manager.getCertAndCheckUser(ctx)
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	self := manager.getUserClaims(ctx)
+	if err = manager.revokeCerts("id = ?", cert.ID, ctx.URLParam("reason")); err != nil {
+		responseError(ctx, err)
+		return
+	}
+	log.Infof("certificate %v of instance %v is revoked by user %v(%v)", cert.Serial, cert.Identifier, self.Name, self.ID)
+	responseSuccess(ctx, "", nil)
+}
+
+// 吊销实例的所有客户端证书
+func (manager *Manager) revokeInstanceCerts(identifier string, reason string) error {
+	return manager.revokeCerts("identifier = ?", identifier, reason)
+}
+
+// 吊销满足条件的证书并同步内存
+func (manager *Manager) revokeCerts(query string, arg interface{}, reason string) error {
+	var certs []model.Certificate
+	if err := manager.db.Where(query, arg).Where("is_revoked = ?", false).Find(&certs).Error; err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return nil
+	}
+	now := time.Now()
+	if err := manager.db.Model(&model.Certificate{}).Where(query, arg).Where("is_revoked = ?", false).
+		Updates(map[string]interface{}{"is_revoked": true, "revoked_at": now, "reason": reason}).Error; err != nil {
+		return err
+	}
+	for _, cert := range certs {
+		cert.IsRevoked, cert.RevokedAt, cert.Reason = true, now, reason
+		manager.storeIssuedCert(cert)
+	}
+	return nil
+}
+
+// 根据请求参数id获取证书并检查用户对其实例的权限
+func (manager *Manager) getCertAndCheckUser(ctx iris.Context) (*model.Certificate, error) {
+	id := uint(ctx.URLParamUint64("id"))
+	if id == 0 {
+		return nil, errors.InvalidRequest
+	}
+	var cert model.Certificate
+	result := manager.db.Where("id = ?", id).Limit(1).Find(&cert)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.NoSuchCert
+	}
+	if err := manager.checkCertInstance(ctx, cert.Identifier); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// 检查用户对证书所属实例的权限，实例已不存在（被熔断或销毁）时仅Root用户可操作
+func (manager *Manager) checkCertInstance(ctx iris.Context, identifier string) error {
+	_, err := manager.getInstanceAndCheckUser(identifier, ctx)
+	if err == errors.NoSuchInstance && manager.getUserClaims(ctx).Level >= model.UserLevelRoot {
+		return nil
+	}
+	return err
+}
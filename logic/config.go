@@ -0,0 +1,135 @@
+package logic
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/RicheyJang/key_keeper/model"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/kataras/iris/v12"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 需重启才能生效的配置项（前缀）
+// 其余配置项中，log、cert由订阅者重新加载，rateLimit、lockout、user等在每次使用时直接读取，无需订阅；
+// 实例的IP白名单及限流覆盖值保存于数据库，不受配置热加载影响
+var restartRequiredConfigs = []string{
+	"host", "web", "mode", "db", "seal", "approval", "ca", "auth", "agent", "cert.crl", "cert.refresh", "tls", "cache", "metrics", "health.listen", "trace",
+}
+
+// 配置热加载状态
+type configState struct {
+	sync.Mutex
+	snapshot    map[string]interface{} // 上次加载时的配置
+	subscribers []configSubscriber
+}
+
+type configSubscriber struct {
+	prefix string
+	fn     func()
+}
+
+// ConfigReport 配置重新加载结果
+type ConfigReport struct {
+	Changed         []string `json:"changed"`         // 发生变更的配置项
+	RestartRequired []string `json:"restartRequired"` // 其中需重启才能生效的配置项
+}
+
+// Subscribe 订阅配置变更：prefix下的配置项发生变更时调用fn，prefix为空代表任意配置项
+func (manager *Manager) Subscribe(prefix string, fn func()) {
+	manager.config.Lock()
+	defer manager.config.Unlock()
+	manager.config.subscribers = append(manager.config.subscribers, configSubscriber{
+		prefix: strings.ToLower(prefix),
+		fn:     fn,
+	})
+}
+
+// ReloadConfig 重新读取配置文件，对比上次加载时的配置，通知相应订阅者，并报告需重启才能生效的配置项
+// 文件监听、SIGHUP及API均经由此处加载，读取与对比在同一把锁内串行进行
+func (manager *Manager) ReloadConfig() (ConfigReport, error) {
+	manager.config.Lock()
+	defer manager.config.Unlock()
+	if err := viper.ReadInConfig(); err != nil {
+		return ConfigReport{}, err
+	}
+	// 对比配置
+	current := configSnapshot()
+	report := ConfigReport{Changed: make([]string, 0), RestartRequired: make([]string, 0)}
+	for key := range unionKeys(manager.config.snapshot, current) {
+		if !reflect.DeepEqual(manager.config.snapshot[key], current[key]) {
+			report.Changed = append(report.Changed, key)
+		}
+	}
+	sort.Strings(report.Changed)
+	manager.config.snapshot = current
+	// 通知订阅者
+	for _, subscriber := range manager.config.subscribers {
+		for _, key := range report.Changed {
+			if matchConfigPrefix(key, subscriber.prefix) {
+				subscriber.fn()
+				break
+			}
+		}
+	}
+	// 报告
+	for _, key := range report.Changed {
+		for _, prefix := range restartRequiredConfigs {
+			if matchConfigPrefix(key, prefix) {
+				report.RestartRequired = append(report.RestartRequired, key)
+				break
+			}
+		}
+	}
+	if len(report.Changed) > 0 {
+		log.Infof("config reloaded, changed: %v", report.Changed)
+	}
+	if len(report.RestartRequired) > 0 {
+		log.Warnf("config changes require restart to take effect: %v", report.RestartRequired)
+	}
+	return report, nil
+}
+
+// HandlerOfReloadConfig 重新读取配置文件并热加载
+func (manager *Manager) HandlerOfReloadConfig(ctx iris.Context) {
+	// 权限检查
+	if manager.getUserClaims(ctx).Level < model.UserLevelRoot {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	// 重新加载
+	report, err := manager.ReloadConfig()
+	if err != nil {
+		responseError(ctx, errors.Newf(errors.CodeInner, "read config error: %v", err))
+		return
+	}
+	responseSuccess(ctx, "data", report)
+}
+
+// 当前所有配置项的扁平化快照
+func configSnapshot() map[string]interface{} {
+	snapshot := make(map[string]interface{})
+	for _, key := range viper.AllKeys() {
+		snapshot[key] = viper.Get(key)
+	}
+	return snapshot
+}
+
+func unionKeys(a, b map[string]interface{}) map[string]struct{} {
+	keys := make(map[string]struct{}, len(a)+len(b))
+	for key := range a {
+		keys[key] = struct{}{}
+	}
+	for key := range b {
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+// 配置项key是否位于prefix之下
+func matchConfigPrefix(key, prefix string) bool {
+	return prefix == "" || key == prefix || strings.HasPrefix(key, prefix+".")
+}
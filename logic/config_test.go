@@ -0,0 +1,72 @@
+package logic
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestReloadConfig(t *testing.T) {
+	defer viper.Reset()
+	file := filepath.Join(t.TempDir(), "config.toml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("[log]\nlevel = \"info\"\n[ratelimit]\ninstance = 0\n")
+	viper.SetConfigFile(file)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	manager := &Manager{}
+	manager.config.snapshot = configSnapshot()
+	var notified int32
+	manager.Subscribe("log", func() { atomic.AddInt32(&notified, 1) })
+
+	// 并发重新加载时仅有一次能观察到变更
+	write("[log]\nlevel = \"debug\"\n[ratelimit]\ninstance = 0\n")
+	var wg sync.WaitGroup
+	var changed int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report, err := manager.ReloadConfig()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(report.Changed) > 0 {
+				atomic.AddInt32(&changed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if changed != 1 || notified != 1 {
+		t.Fatalf("changes observed %v times, subscriber notified %v times", changed, notified)
+	}
+
+	// 需重启才能生效的配置项
+	write("host = \":9999\"\n[log]\nlevel = \"debug\"\n[ratelimit]\ninstance = 5\n")
+	report, err := manager.ReloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changed) != 2 || len(report.RestartRequired) != 1 || report.RestartRequired[0] != "host" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if viper.GetFloat64("rateLimit.instance") != 5 {
+		t.Fatal("rate limit is not read live")
+	}
+
+	// 读取失败时保留原配置
+	write("not toml [")
+	if _, err = manager.ReloadConfig(); err == nil {
+		t.Fatal("expected a parse error")
+	}
+}
@@ -0,0 +1,176 @@
+package logic
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+
+	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/utils/crypt"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/kataras/iris/v12"
+)
+
+// 密文格式：格式版本(1字节) | 密钥ID(4字节) | 密钥版本(4字节) | 算法输出
+// 头部作为附加认证数据的前缀参与认证
+const (
+	ciphertextFormat     = 1
+	ciphertextHeaderSize = 9
+)
+
+type EncryptRequest struct {
+	ID        uint   `json:"id"`
+	Plaintext []byte `json:"plaintext"` // base64
+	AAD       []byte `json:"aad"`       // 附加认证数据，base64
+}
+
+type DecryptRequest struct {
+	Ciphertext []byte `json:"ciphertext"` // base64
+	AAD        []byte `json:"aad"`
+}
+
+type GenerateDataKeyRequest struct {
+	ID     uint   `json:"id"`
+	Length uint   `json:"length"` // 数据密钥长度，默认32
+	AAD    []byte `json:"aad"`
+}
+
+// Encrypt 使用实例密钥的最新版本加密数据
+func (manager *Manager) Encrypt(ctx iris.Context) {
+	// 解析参数
+	var req EncryptRequest
+	if err := ctx.ReadJSON(&req); err != nil {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	// 加密
+	k, span := traceKeeper(ctx, manager.getKeeper(ctx), ctx.GetHeader("identifier"), "Encrypt")
+	ciphertext, key, err := encryptWithKeeper(k, req.ID, req.Plaintext, req.AAD)
+	span.End()
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	responseSuccess(ctx, "data", iris.Map{
+		"id":         key.ID,
+		"version":    key.Version,
+		"ciphertext": ciphertext,
+	})
+}
+
+// Decrypt 根据密文中记录的密钥ID及版本解密数据
+func (manager *Manager) Decrypt(ctx iris.Context) {
+	// 解析参数
+	var req DecryptRequest
+	if err := ctx.ReadJSON(&req); err != nil {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	// 解密
+	k, span := traceKeeper(ctx, manager.getKeeper(ctx), ctx.GetHeader("identifier"), "Decrypt")
+	plaintext, key, err := decryptWithKeeper(k, req.Ciphertext, req.AAD)
+	span.End()
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	responseSuccess(ctx, "data", iris.Map{
+		"id":        key.ID,
+		"version":   key.Version,
+		"plaintext": plaintext,
+	})
+}
+
+// GenerateDataKey 生成数据密钥：返回明文数据密钥及由实例密钥加密后的数据密钥（信封加密）
+func (manager *Manager) GenerateDataKey(ctx iris.Context) {
+	// 解析参数
+	var req GenerateDataKeyRequest
+	if err := ctx.ReadJSON(&req); err != nil {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	if req.Length == 0 {
+		req.Length = 32
+	}
+	if !(req.Length == 16 || req.Length == 24 || req.Length == 32) {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	// 生成并加密数据密钥
+	dataKey := make([]byte, req.Length)
+	if _, err := rand.Read(dataKey); err != nil {
+		responseError(ctx, err)
+		return
+	}
+	k, span := traceKeeper(ctx, manager.getKeeper(ctx), ctx.GetHeader("identifier"), "GenerateDataKey")
+	ciphertext, key, err := encryptWithKeeper(k, req.ID, dataKey, req.AAD)
+	span.End()
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	responseSuccess(ctx, "data", iris.Map{
+		"id":         key.ID,
+		"version":    key.Version,
+		"plaintext":  dataKey,
+		"ciphertext": ciphertext,
+	})
+}
+
+// 使用指定密钥ID的最新版本加密，返回带有密钥ID及版本的密文
+func encryptWithKeeper(k keeper.KeyKeeper, id uint, plain []byte, aad []byte) ([]byte, keeper.KeyInfo, error) {
+	if id < 1 || id > math.MaxUint32 {
+		return nil, keeper.KeyInfo{}, errors.InvalidRequest
+	}
+	key, err := k.GetLatestVersionKey(id)
+	if err != nil {
+		return nil, keeper.KeyInfo{}, err
+	}
+	content, err := hex.DecodeString(key.Key)
+	if err != nil {
+		return nil, keeper.KeyInfo{}, err
+	}
+	header := make([]byte, ciphertextHeaderSize)
+	header[0] = ciphertextFormat
+	binary.BigEndian.PutUint32(header[1:5], uint32(key.ID))
+	binary.BigEndian.PutUint32(header[5:9], uint32(key.Version))
+	sealed, err := crypt.Encrypt(string(key.Algorithm), content, plain, bindHeader(header, aad))
+	if err != nil {
+		return nil, keeper.KeyInfo{}, err
+	}
+	return append(header, sealed...), key, nil
+}
+
+// 根据密文头部的密钥ID及版本获取密钥并解密
+func decryptWithKeeper(k keeper.KeyKeeper, ciphertext []byte, aad []byte) ([]byte, keeper.KeyInfo, error) {
+	if len(ciphertext) < ciphertextHeaderSize {
+		return nil, keeper.KeyInfo{}, errors.InvalidCiphertext
+	}
+	header := ciphertext[:ciphertextHeaderSize]
+	if header[0] != ciphertextFormat {
+		return nil, keeper.KeyInfo{}, errors.InvalidCiphertext
+	}
+	key, err := k.GetKeyInfo(keeper.KeyRequest{
+		ID:      uint(binary.BigEndian.Uint32(ciphertext[1:5])),
+		Version: uint(binary.BigEndian.Uint32(ciphertext[5:9])),
+	})
+	if err != nil {
+		return nil, keeper.KeyInfo{}, err
+	}
+	content, err := hex.DecodeString(key.Key)
+	if err != nil {
+		return nil, keeper.KeyInfo{}, err
+	}
+	plain, err := crypt.Decrypt(string(key.Algorithm), content, ciphertext[ciphertextHeaderSize:], bindHeader(header, aad))
+	if err != nil {
+		return nil, keeper.KeyInfo{}, err
+	}
+	return plain, key, nil
+}
+
+// 将密文头部置于附加认证数据之前，防止篡改密文中的密钥ID及版本
+func bindHeader(header []byte, aad []byte) []byte {
+	bound := make([]byte, 0, len(header)+len(aad))
+	return append(append(bound, header...), aad...)
+}
@@ -0,0 +1,71 @@
+package logic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+)
+
+// 所有ID及版本均返回相同密钥内容的keeper：篡改头部后仍能取得可用的密钥，只能依靠头部认证发现篡改
+type sameKeyKeeper struct {
+	keeper.KeyKeeper
+}
+
+func (sameKeyKeeper) key(id, version uint) keeper.KeyInfo {
+	return keeper.KeyInfo{
+		ID:        id,
+		Version:   version,
+		Key:       "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		Length:    32,
+		Algorithm: keeper.AlgorithmAESGCM,
+	}
+}
+
+func (k sameKeyKeeper) GetKeyInfo(request keeper.KeyRequest) (keeper.KeyInfo, error) {
+	return k.key(request.ID, request.Version), nil
+}
+
+func (k sameKeyKeeper) GetLatestVersionKey(id uint) (keeper.KeyInfo, error) {
+	return k.key(id, 3), nil
+}
+
+func TestCiphertextHeaderAuthenticated(t *testing.T) {
+	k := sameKeyKeeper{}
+	plain, aad := []byte("secret"), []byte("context")
+	ciphertext, key, err := encryptWithKeeper(k, 7, plain, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ciphertext[0] != ciphertextFormat || key.ID != 7 || key.Version != 3 {
+		t.Fatalf("unexpected header %x, key %+v", ciphertext[:ciphertextHeaderSize], key)
+	}
+	got, key, err := decryptWithKeeper(k, ciphertext, aad)
+	if err != nil || !bytes.Equal(got, plain) || key.ID != 7 || key.Version != 3 {
+		t.Fatalf("got %q, %+v, %v", got, key, err)
+	}
+
+	cases := []struct {
+		name   string
+		modify func(c []byte) []byte
+		aad    []byte
+	}{
+		{"key id", func(c []byte) []byte { binary.BigEndian.PutUint32(c[1:5], 8); return c }, aad},
+		{"key version", func(c []byte) []byte { binary.BigEndian.PutUint32(c[5:9], 2); return c }, aad},
+		{"unknown format", func(c []byte) []byte { c[0] = ciphertextFormat + 1; return c }, aad},
+		{"zero format", func(c []byte) []byte { c[0] = 0; return c }, aad},
+		{"body", func(c []byte) []byte { c[len(c)-1] ^= 1; return c }, aad},
+		{"aad", func(c []byte) []byte { return c }, []byte("other")},
+		{"truncated", func(c []byte) []byte { return c[:ciphertextHeaderSize-1] }, aad},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tampered := c.modify(append([]byte(nil), ciphertext...))
+			if got, _, err := decryptWithKeeper(k, tampered, c.aad); err != errors.InvalidCiphertext {
+				t.Fatalf("got %q, %v, want InvalidCiphertext", got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package logic
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/kataras/iris/v12"
+)
+
+// 就绪检查项
+type healthState struct {
+	sync.Mutex
+	checks []healthCheck
+}
+
+type healthCheck struct {
+	name string
+	fn   func() error
+}
+
+// ComponentStatus 组件状态
+type ComponentStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message,omitempty"` // 不健康的原因
+}
+
+// ReadinessReport 就绪检查结果
+type ReadinessReport struct {
+	Ready      bool              `json:"ready"`
+	Components []ComponentStatus `json:"components"`
+}
+
+// AddReadinessCheck 添加就绪检查项，fn返回错误代表该组件未就绪
+func (manager *Manager) AddReadinessCheck(name string, fn func() error) {
+	manager.health.Lock()
+	defer manager.health.Unlock()
+	manager.health.checks = append(manager.health.checks, healthCheck{name: name, fn: fn})
+}
+
+// Readiness 依次检查数据库、实例、密封状态及其它已添加的检查项
+func (manager *Manager) Readiness() ReadinessReport {
+	checks := []healthCheck{
+		{name: "database", fn: manager.checkDatabase},
+		{name: "instances", fn: manager.checkInstances},
+		{name: "seal", fn: manager.checkSeal},
+	}
+	manager.health.Lock()
+	checks = append(checks, manager.health.checks...)
+	manager.health.Unlock()
+
+	report := ReadinessReport{Ready: true, Components: make([]ComponentStatus, 0, len(checks))}
+	for _, check := range checks {
+		status := ComponentStatus{Name: check.name, Healthy: true}
+		if err := check.fn(); err != nil {
+			status.Healthy, status.Message = false, err.Error()
+			report.Ready = false
+		}
+		report.Components = append(report.Components, status)
+	}
+	return report
+}
+
+func (manager *Manager) checkDatabase() error {
+	sqlDB, err := manager.db.DB()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return sqlDB.PingContext(ctx)
+}
+
+func (manager *Manager) checkInstances() error {
+	if manager.defaultIns.kp == nil || manager.InstanceCount() == 0 {
+		return errors.New(errors.CodeNotReady, "instances are not loaded")
+	}
+	return nil
+}
+
+func (manager *Manager) checkSeal() error {
+	if manager.isSealed() {
+		return errors.Sealed
+	}
+	return nil
+}
+
+// HandlerOfHealthz 存活检查：进程可处理请求即为存活
+func (manager *Manager) HandlerOfHealthz(ctx iris.Context) {
+	responseSuccess(ctx, "", nil)
+}
+
+// HandlerOfReadyz 就绪检查：未就绪时回应503及各组件状态，含不健康的原因，仅用于健康检查端口
+func (manager *Manager) HandlerOfReadyz(ctx iris.Context) {
+	responseReadiness(ctx, manager.Readiness())
+}
+
+// HandlerOfPublicReadyz 公开的就绪检查：仅回应各组件名称及是否健康，不暴露错误详情
+func (manager *Manager) HandlerOfPublicReadyz(ctx iris.Context) {
+	report := manager.Readiness()
+	for i := range report.Components {
+		report.Components[i].Message = ""
+	}
+	responseReadiness(ctx, report)
+}
+
+func responseReadiness(ctx iris.Context, report ReadinessReport) {
+	if !report.Ready {
+		ctx.StopWithJSON(http.StatusServiceUnavailable, iris.Map{
+			"code": errors.NotReady.Code,
+			"msg":  errors.NotReady.Msg,
+			"data": report,
+		})
+		return
+	}
+	responseSuccess(ctx, "data", report)
+}
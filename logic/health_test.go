@@ -0,0 +1,49 @@
+package logic
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/kataras/iris/v12"
+)
+
+// 公开的就绪检查不暴露不健康的原因，健康检查端口回应详情
+func TestReadyzMessages(t *testing.T) {
+	manager := newTestManager(t)
+	manager.AddReadinessCheck("certificate", func() error {
+		return errors.New(errors.CodeNotReady, "server certificate /etc/kk/server.crt expires soon")
+	})
+	app := iris.New()
+	app.Get("/public", manager.HandlerOfPublicReadyz)
+	app.Get("/detail", manager.HandlerOfReadyz)
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	for path, detailed := range map[string]bool{"/public": false, "/detail": true} {
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("%v: status %v", path, rec.Code)
+		}
+		var resp struct {
+			Data ReadinessReport
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		healthy := make(map[string]bool)
+		for _, c := range resp.Data.Components {
+			healthy[c.Name] = c.Healthy
+		}
+		if resp.Data.Ready || !healthy["database"] || healthy["instances"] || healthy["certificate"] {
+			t.Fatalf("%v: unexpected report %+v", path, resp.Data)
+		}
+		if strings.Contains(rec.Body.String(), "server.crt") != detailed {
+			t.Fatalf("%v: unexpected body %v", path, rec.Body.String())
+		}
+	}
+}
@@ -2,12 +2,12 @@ package logic
 
 import (
 	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/utils/metrics"
 	"github.com/kataras/iris/v12"
 )
 
 // GetKeyInfo 获取指定密钥
 func (manager *Manager) GetKeyInfo(ctx iris.Context) {
-	k := manager.getKeeper(ctx)
 	// 解析参数
 	var req keeper.KeyRequest
 	if err := ctx.ReadJSON(&req); err != nil {
@@ -15,17 +15,19 @@ func (manager *Manager) GetKeyInfo(ctx iris.Context) {
 		return
 	}
 	// 获取密钥信息
+	k, span := traceKeeper(ctx, manager.getKeeper(ctx), ctx.GetHeader("identifier"), "GetKeyInfo")
 	key, err := k.GetKeyInfo(req)
+	span.End()
 	if err != nil {
 		responseError(ctx, err)
 	} else {
+		metrics.ObserveKeyFetch(ctx.GetHeader("identifier"), req.ID)
 		responseSuccess(ctx, "key", key)
 	}
 }
 
 // GetLatestVersionKey 获取特定密钥ID下的最新版本密钥
 func (manager *Manager) GetLatestVersionKey(ctx iris.Context) {
-	k := manager.getKeeper(ctx)
 	// 解析参数
 	var req keeper.KeyRequest
 	if err := ctx.ReadJSON(&req); err != nil {
@@ -33,10 +35,13 @@ func (manager *Manager) GetLatestVersionKey(ctx iris.Context) {
 		return
 	}
 	// 获取密钥最新版本
+	k, span := traceKeeper(ctx, manager.getKeeper(ctx), ctx.GetHeader("identifier"), "GetLatestVersionKey")
 	key, err := k.GetLatestVersionKey(req.ID)
+	span.End()
 	if err != nil {
 		responseError(ctx, err)
 	} else {
+		metrics.ObserveKeyFetch(ctx.GetHeader("identifier"), req.ID)
 		responseSuccess(ctx, "key", key)
 	}
 }
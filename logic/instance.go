@@ -10,6 +10,7 @@ import (
 	"github.com/RicheyJang/key_keeper/model"
 	"github.com/RicheyJang/key_keeper/utils/errors"
 	"github.com/kataras/iris/v12"
+	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
@@ -44,19 +45,25 @@ func (manager *Manager) HandlerOfGetInstances(ctx iris.Context) {
 	var count int64
 	// 分情况处理
 	self := manager.getUserClaims(ctx)
-	if self.Level >= model.UserLevelRoot { // Root用户：返回所有实例
-		if err = manager.db.Model(&model.Instance{}).Count(&count).Error; err != nil {
+	if len(self.Instances) > 0 { // 限定了实例的API令牌：返回其中有权限的实例
+		var candidates []model.Instance
+		if err = manager.db.Where("identifier IN ?", self.Instances).Order("id").Find(&candidates).Error; err != nil {
 			responseError(ctx, err)
 			return
 		}
-		session := manager.db.Order("id")
-		if offset > 0 {
-			session = session.Offset(offset)
+		for _, instance := range candidates {
+			if manager.canAccessInstance(self, &InstanceInfo{Instance: instance}) {
+				instances = append(instances, instance)
+			}
 		}
-		if limit > 0 {
-			session = session.Limit(limit)
+		count = int64(len(instances))
+		instances = paginateInstances(instances, offset, limit)
+	} else if self.Level >= model.UserLevelRoot { // Root用户：返回所有实例
+		if err = manager.db.Model(&model.Instance{}).Count(&count).Error; err != nil {
+			responseError(ctx, err)
+			return
 		}
-		if err = session.Find(&instances).Error; err != nil {
+		if err = paginateSession(manager.db.Order("id"), offset, limit).Find(&instances).Error; err != nil {
 			responseError(ctx, err)
 			return
 		}
@@ -67,13 +74,7 @@ func (manager *Manager) HandlerOfGetInstances(ctx iris.Context) {
 			return
 		}
 		count = int64(len(instances))
-		if limit > 0 && count > 0 { // 分页
-			if offset+limit > int(count) {
-				instances = instances[offset:]
-			} else {
-				instances = instances[offset : offset+limit]
-			}
-		}
+		instances = paginateInstances(instances, offset, limit)
 	}
 	// 回包
 	if instances == nil {
@@ -85,11 +86,38 @@ func (manager *Manager) HandlerOfGetInstances(ctx iris.Context) {
 	})
 }
 
+// 内存分页，limit为0代表不分页
+func paginateInstances(instances []model.Instance, offset, limit int) []model.Instance {
+	if limit <= 0 {
+		return instances
+	}
+	if offset >= len(instances) {
+		return instances[:0]
+	}
+	if offset+limit > len(instances) {
+		return instances[offset:]
+	}
+	return instances[offset : offset+limit]
+}
+
+// 数据库分页，limit为0代表不分页
+func paginateSession(session *gorm.DB, offset, limit int) *gorm.DB {
+	if offset > 0 {
+		session = session.Offset(offset)
+	}
+	if limit > 0 {
+		session = session.Limit(limit)
+	}
+	return session
+}
+
 type AddInstanceRequest struct {
-	Identifier string `json:"identifier"`
-	DSafeLevel int    `json:"level"`
-	IPs        string `json:"ips"`
-	Keeper     string `json:"keeper"`
+	Identifier string  `json:"identifier"`
+	DSafeLevel int     `json:"level"`
+	IPs        string  `json:"ips"`
+	Keeper     string  `json:"keeper"`
+	RateLimit  float64 `json:"rateLimit"`
+	RateBurst  int     `json:"rateBurst"`
 }
 
 var instanceIdentifierRegexp = regexp.MustCompile(`^[\w.+-]+$`)
@@ -102,7 +130,7 @@ func (manager *Manager) HandlerOfAddInstance(ctx iris.Context) {
 		responseError(ctx, errors.InvalidRequest)
 		return
 	}
-	if len(request.Identifier) == 0 || len(request.Keeper) == 0 {
+	if len(request.Identifier) == 0 || len(request.Keeper) == 0 || request.RateBurst < 0 {
 		responseError(ctx, errors.InvalidRequest)
 		return
 	}
@@ -114,14 +142,24 @@ func (manager *Manager) HandlerOfAddInstance(ctx iris.Context) {
 		responseError(ctx, errors.InstanceExist)
 		return
 	}
+	if manager.isSealed() {
+		responseError(ctx, errors.Sealed)
+		return
+	}
 	// 创建实例
 	self := manager.getUserClaims(ctx)
+	if !self.AllowInstance(request.Identifier) {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
 	instance := model.Instance{
 		Identifier: request.Identifier,
 		IsFrozen:   false,
 		Keeper:     request.Keeper,
 		DSafeLevel: request.DSafeLevel,
 		IPs:        request.IPs,
+		RateLimit:  request.RateLimit,
+		RateBurst:  request.RateBurst,
 	}
 	if err := instance.AddUser(strconv.FormatUint(uint64(self.ID), 10)); err != nil {
 		responseError(ctx, err)
@@ -175,13 +213,35 @@ func (manager *Manager) HandlerOfDestroyInstance(ctx iris.Context) {
 		responseError(ctx, errors.InvalidRequest)
 		return
 	}
-	// 获取实例
-	instance, err := manager.getInstanceAndCheckUser(identifier, ctx)
+	// 获取实例：已熔断的实例同样可被销毁以清理其剩余数据
+	info, err := manager.getInstanceOrKilled(identifier)
 	if err != nil {
 		responseError(ctx, err)
 		return
 	}
+	if !manager.canAccessInstance(manager.getUserClaims(ctx), info) {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	// 需双人审批时创建待审批操作
+	if manager.requestApproval(ctx, model.OperationDestroyInstance, identifier, nil) {
+		return
+	}
 	// 销毁实例
+	if err := manager.destroyInstance(identifier); err != nil {
+		responseError(ctx, err)
+		return
+	}
+	// 回包
+	responseSuccess(ctx, "", nil)
+}
+
+// 销毁实例
+func (manager *Manager) destroyInstance(identifier string) error {
+	instance, err := manager.getInstanceOrKilled(identifier)
+	if err != nil {
+		return err
+	}
 	err = manager.db.Transaction(func(tx *gorm.DB) (txErr error) {
 		defer func() {
 			if r := recover(); r != nil {
@@ -197,18 +257,110 @@ func (manager *Manager) HandlerOfDestroyInstance(ctx iris.Context) {
 		return
 	})
 	if err != nil {
+		return err
+	}
+	manager.removeInstanceInfo(identifier)
+	manager.rateLimiter.instances.Delete(identifier)
+	if err = manager.revokeInstanceCerts(identifier, "instance destroyed"); err != nil {
+		log.Errorf("revoke certificates of instance %v error: %v", identifier, err)
+	}
+	return nil
+}
+
+type KillInstanceRequest struct {
+	Identifier string `json:"identifier"`
+	Reason     string `json:"reason"`
+	Escrow     bool   `json:"escrow"` // 是否保留密钥托管副本以便恢复
+}
+
+// HandlerOfKillInstance 紧急熔断实例处理函数：立即冻结、移出内存并粉碎其密钥材料
+func (manager *Manager) HandlerOfKillInstance(ctx iris.Context) {
+	// 权限检查
+	self := manager.getUserClaims(ctx)
+	if self.Level < model.UserLevelAdmin {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	// 校验参数
+	var request KillInstanceRequest
+	if err := ctx.ReadJSON(&request); err != nil {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	if len(request.Identifier) == 0 || request.Identifier == DefaultInstanceIdentifier {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	if _, err := manager.getInstanceAndCheckUser(request.Identifier, ctx); err != nil {
+		responseError(ctx, err)
+		return
+	}
+	// 熔断
+	if err := manager.killInstance(request.Identifier, self, request.Reason, request.Escrow); err != nil {
 		responseError(ctx, err)
 		return
 	}
-	manager.instanceMap.Delete(identifier)
-	// 回包
 	responseSuccess(ctx, "", nil)
 }
 
+// 紧急熔断实例
+func (manager *Manager) killInstance(identifier string, operator *UserClaims, reason string, escrow bool) error {
+	info, ok := manager.getInstance(identifier)
+	if !ok {
+		return errors.NoSuchInstance
+	}
+	// 托管副本须由根密钥加密，否则将以明文与其它数据保存于同一数据库
+	if escrow && !manager.seal.enable {
+		return errors.EscrowUnavailable
+	}
+	if escrow && manager.isSealed() {
+		return errors.Sealed
+	}
+	// 先持久化熔断状态，再移出内存，使内部API无法再获取该实例的密钥
+	if err := manager.db.Model(&model.Instance{}).Where("identifier = ?", identifier).
+		Updates(map[string]interface{}{"is_frozen": true, "is_killed": true}).Error; err != nil {
+		return err
+	}
+	manager.removeInstanceInfo(identifier)
+	log.Warnf("instance %v is killed by user %v(%v): %v", identifier, operator.Name, operator.ID, reason)
+	// 吊销其客户端证书
+	if err := manager.revokeInstanceCerts(identifier, "instance killed"); err != nil {
+		log.Errorf("revoke certificates of instance %v error: %v", identifier, err)
+	}
+	// 粉碎密钥材料
+	var shredErr error
+	if shredder, ok := info.kp.(keeper.Shredder); ok {
+		if shredErr = shredder.Shred(escrow); shredErr != nil {
+			log.Errorf("shred instance %v error: %v", identifier, shredErr)
+		}
+	} else {
+		shredErr = errors.Newf(-1, "keeper %v does not support shredding", info.Keeper)
+		log.Warnf("keeper %v of instance %v does not support shredding", info.Keeper, identifier)
+	}
+	// 无论粉碎成功与否均记录熔断操作：此时实例已被冻结并移出内存
+	record := model.KillRecord{
+		Identifier: identifier,
+		UserID:     operator.ID,
+		UserName:   operator.Name,
+		Reason:     reason,
+		Escrow:     escrow && shredErr == nil,
+	}
+	if shredErr != nil {
+		record.ShredError = shredErr.Error()
+	}
+	if err := manager.db.Create(&record).Error; err != nil {
+		log.Errorf("save kill record of instance %v error: %v", identifier, err)
+		if shredErr == nil {
+			return err
+		}
+	}
+	return shredErr
+}
+
 // 初始化所有实例
 func (manager *Manager) initAllInstances() error {
 	// 初始化数据库
-	if err := manager.db.AutoMigrate(&model.Instance{}); err != nil {
+	if err := manager.db.AutoMigrate(&model.Instance{}, &model.KillRecord{}); err != nil {
 		return err
 	}
 	// 读取出所有实例
@@ -236,6 +388,9 @@ func (manager *Manager) initAllInstances() error {
 	}
 	// 初始化所有实例
 	for _, instance := range instances {
+		if instance.IsKilled { // 已被熔断的实例不再加载
+			continue
+		}
 		err := manager.initInstance(instance)
 		if err != nil {
 			return err
@@ -256,6 +411,8 @@ func (manager *Manager) initInstance(instance model.Instance) error {
 	kp, err := generator(keeper.Option{
 		Identifier: instance.Identifier,
 		DB:         manager.db,
+		RootKey:    manager.rootKeyGetter(),
+		Cache:      manager.keyCache,
 	})
 	if err != nil {
 		return err
@@ -292,6 +449,8 @@ func (manager *Manager) createInstance(instance model.Instance) (InstanceInfo, e
 		kp, txErr = generator(keeper.Option{
 			Identifier: instance.Identifier,
 			DB:         manager.db,
+			RootKey:    manager.rootKeyGetter(),
+			Cache:      manager.keyCache,
 		})
 		return
 	})
@@ -309,15 +468,16 @@ func (manager *Manager) createInstance(instance model.Instance) (InstanceInfo, e
 
 // 冻结实例
 func (manager *Manager) freezeInstance(identifier string, isFrozen bool) error {
-	info, ok := manager.getInstance(identifier)
-	if !ok {
+	if _, ok := manager.getInstance(identifier); !ok {
 		return errors.NoSuchInstance
 	}
 	if err := manager.db.Model(&model.Instance{}).
 		Where("identifier = ?", identifier).Update("is_frozen", isFrozen).Error; err != nil {
 		return err
 	}
-	info.IsFrozen = isFrozen
+	manager.updateInstanceInfo(identifier, func(info *InstanceInfo) {
+		info.IsFrozen = isFrozen
+	})
 	return nil
 }
 
@@ -330,7 +490,7 @@ func (manager *Manager) freezeUserInstances(id uint) error {
 	}
 	// 冻结所有实例
 	for _, instance := range instances {
-		if instance.Identifier == DefaultInstanceIdentifier { // 除了默认实例
+		if instance.Identifier == DefaultInstanceIdentifier || instance.IsKilled { // 除了默认实例及已熔断的实例
 			continue
 		}
 		if err = manager.freezeInstance(instance.Identifier, true); err != nil {
@@ -369,6 +529,85 @@ func (manager *Manager) getInstance(identifier string) (*InstanceInfo, bool) {
 	return info, ok
 }
 
+// 以写时复制方式更新内存中的实例信息：读取者持有的*InstanceInfo不会被修改
+func (manager *Manager) updateInstanceInfo(identifier string, update func(info *InstanceInfo)) bool {
+	manager.instanceLock.Lock()
+	defer manager.instanceLock.Unlock()
+	old, ok := manager.getInstance(identifier)
+	if !ok {
+		return false
+	}
+	info := *old
+	update(&info)
+	manager.instanceMap.Store(identifier, &info)
+	return true
+}
+
+// 将实例移出内存，与写时复制更新互斥，避免被移出的实例被重新写入
+func (manager *Manager) removeInstanceInfo(identifier string) {
+	manager.instanceLock.Lock()
+	defer manager.instanceLock.Unlock()
+	manager.instanceMap.Delete(identifier)
+}
+
+// 获取特定实例，内存中不存在时查找已熔断的实例并为其生成keeper，仅用于销毁
+func (manager *Manager) getInstanceOrKilled(identifier string) (*InstanceInfo, error) {
+	if info, ok := manager.getInstance(identifier); ok {
+		return info, nil
+	}
+	var instance model.Instance
+	result := manager.db.Where("identifier = ?", identifier).Where("is_killed = ?", true).Find(&instance)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.NoSuchInstance
+	}
+	generatorValue, ok := manager.generatorMap.Load(instance.Keeper)
+	if !ok || generatorValue == nil {
+		return nil, errors.InvalidKeeper
+	}
+	kp, err := generatorValue.(keeper.Generator)(keeper.Option{
+		Identifier: instance.Identifier,
+		DB:         manager.db,
+		RootKey:    manager.rootKeyGetter(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &InstanceInfo{Instance: instance, kp: kp}, nil
+}
+
+// 依据实例标识检查用户对该实例是否有权限：内存中不存在时读取已熔断的实例记录，不生成keeper
+func (manager *Manager) canAccessIdentifier(user *UserClaims, identifier string) bool {
+	if info, ok := manager.getInstance(identifier); ok {
+		return manager.canAccessInstance(user, info)
+	}
+	var instance model.Instance
+	result := manager.db.Where("identifier = ?", identifier).Limit(1).Find(&instance)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+	return manager.canAccessInstance(user, &InstanceInfo{Instance: instance})
+}
+
+// 获取用户有权限的实例标识，all为true代表可访问所有实例
+func (manager *Manager) getAccessibleIdentifiers(user *UserClaims) (identifiers []string, all bool, err error) {
+	if user.Level >= model.UserLevelRoot {
+		return user.Instances, len(user.Instances) == 0, nil
+	}
+	instances, err := manager.getInstancesByUser(user.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, instance := range instances {
+		if user.AllowInstance(instance.Identifier) {
+			identifiers = append(identifiers, instance.Identifier)
+		}
+	}
+	return identifiers, false, nil
+}
+
 // 获取特定实例并检查用户对该实例是否有权限
 func (manager *Manager) getInstanceAndCheckUser(identifier string, ctx iris.Context) (*InstanceInfo, error) {
 	info, ok := manager.getInstance(identifier)
@@ -376,10 +615,17 @@ func (manager *Manager) getInstanceAndCheckUser(identifier string, ctx iris.Cont
 		return nil, errors.NoSuchInstance
 	}
 	// 检查用户权限
-	user := manager.getUserClaims(ctx)
-	if user.Level >= model.UserLevelRoot || info.HasUser(strconv.FormatUint(uint64(user.ID), 10)) {
+	if manager.canAccessInstance(manager.getUserClaims(ctx), info) {
 		return info, nil
 	} else {
 		return nil, errors.PermissionDeny
 	}
 }
+
+// 检查用户对该实例是否有权限
+func (manager *Manager) canAccessInstance(user *UserClaims, info *InstanceInfo) bool {
+	if !user.AllowInstance(info.Identifier) {
+		return false
+	}
+	return user.Level >= model.UserLevelRoot || info.HasUser(strconv.FormatUint(uint64(user.ID), 10))
+}
@@ -0,0 +1,105 @@
+package logic
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/model"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 创建仅含内存数据库的Manager
+func newTestManager(t *testing.T, models ...interface{}) *Manager {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1) // 内存数据库仅存在于单个连接中
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	if err = db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return &Manager{db: db}
+}
+
+func TestUpdateInstanceInfo(t *testing.T) {
+	manager := &Manager{}
+	manager.instanceMap.Store("ins", &InstanceInfo{Instance: model.Instance{Identifier: "ins", RateLimit: 1}})
+	held, _ := manager.getInstance("ins")
+
+	// 读取者与更新者并发：-race下不应报告数据竞争
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(limit float64) {
+			defer wg.Done()
+			manager.updateInstanceInfo("ins", func(info *InstanceInfo) {
+				info.RateLimit, info.RateBurst = limit, int(limit)
+			})
+		}(float64(i + 2))
+		go func() {
+			defer wg.Done()
+			if info, ok := manager.getInstance("ins"); ok {
+				_ = info.RateLimit + float64(info.RateBurst)
+			}
+		}()
+	}
+	wg.Wait()
+	if held.RateLimit != 1 || held.RateBurst != 0 {
+		t.Fatalf("instance info held by a reader is modified: %+v", held.Instance)
+	}
+	if info, _ := manager.getInstance("ins"); info.RateLimit < 2 || info.RateBurst != int(info.RateLimit) {
+		t.Fatalf("unexpected instance info %+v", info.Instance)
+	}
+
+	// 已移出的实例不会被更新重新写入
+	manager.removeInstanceInfo("ins")
+	if manager.updateInstanceInfo("ins", func(info *InstanceInfo) { info.IsFrozen = true }) {
+		t.Fatal("removed instance is updated")
+	}
+	if _, ok := manager.getInstance("ins"); ok {
+		t.Fatal("removed instance is stored again")
+	}
+}
+
+// 粉碎失败的keeper
+type failingShredder struct {
+	keeper.KeyKeeper
+}
+
+func (failingShredder) Shred(bool) error {
+	return errors.Unknown
+}
+
+func TestKillInstanceRecordsShredFailure(t *testing.T) {
+	manager := newTestManager(t, &model.Instance{}, &model.KillRecord{}, &model.Certificate{})
+	instance := model.Instance{Identifier: "ins", Keeper: "Safer"}
+	if err := manager.db.Create(&instance).Error; err != nil {
+		t.Fatal(err)
+	}
+	manager.instanceMap.Store("ins", &InstanceInfo{Instance: instance, kp: failingShredder{}})
+	operator := &UserClaims{ID: 1, Name: "root", Level: model.UserLevelRoot}
+	if err := manager.killInstance("ins", operator, "leaked", false); err != errors.Unknown {
+		t.Fatalf("got %v, want the shred error", err)
+	}
+	// 实例已冻结并移出内存，熔断记录仍须写入
+	if _, ok := manager.getInstance("ins"); ok {
+		t.Fatal("killed instance is still loaded")
+	}
+	var record model.KillRecord
+	if err := manager.db.Where("identifier = ?", "ins").First(&record).Error; err != nil {
+		t.Fatalf("kill record is missing: %v", err)
+	}
+	if record.ShredError != errors.Unknown.Error() || record.UserID != operator.ID {
+		t.Fatalf("unexpected kill record %+v", record)
+	}
+}
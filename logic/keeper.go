@@ -3,15 +3,27 @@ package logic
 import (
 	"github.com/RicheyJang/key_keeper/keeper"
 	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/RicheyJang/key_keeper/utils/tracing"
 	"github.com/kataras/iris/v12"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const ctxKeeperKey = "key-keeper"
 
 // PreRouterOfSetKeeper Router中间件：根据请求实例查询处理该请求的密钥保管器
 func (manager *Manager) PreRouterOfSetKeeper(ctx iris.Context) {
-	// 分实例给予不同的keeper
+	// 先检查客户端证书，未通过时不泄露密封状态及实例是否存在
 	identifier := ctx.GetHeader("identifier")
+	if err := manager.checkClientCert(ctx, identifier); err != nil { // 证书与实例不符或已吊销
+		responseError(ctx, err)
+		return
+	}
+	if manager.isSealed() { // 密封状态下拒绝所有密钥操作
+		responseError(ctx, errors.Sealed)
+		return
+	}
+	// 分实例给予不同的keeper
 	info, ok := manager.getInstance(identifier)
 	if !ok { // 不存在该实例
 		responseError(ctx, errors.NoSuchInstance)
@@ -21,6 +33,10 @@ func (manager *Manager) PreRouterOfSetKeeper(ctx iris.Context) {
 		responseError(ctx, errors.InstanceFrozen)
 		return
 	}
+	if err := manager.checkRateLimit(ctx, info); err != nil { // 超出限流
+		responseError(ctx, err)
+		return
+	}
 	ctx.Values().Set(ctxKeeperKey, info.kp)
 	ctx.Next()
 }
@@ -33,3 +49,13 @@ func (manager *Manager) getKeeper(ctx iris.Context) keeper.KeyKeeper {
 	}
 	return k
 }
+
+// 开启对keeper调用的子span，keeper支持时其数据库操作归入该span
+func traceKeeper(ctx iris.Context, k keeper.KeyKeeper, identifier, operation string) (keeper.KeyKeeper, trace.Span) {
+	spanCtx, span := tracing.Start(ctx.Request().Context(), "keeper."+operation,
+		attribute.String("kk.instance", identifier))
+	if binder, ok := k.(keeper.ContextBinder); ok {
+		k = binder.WithContext(spanCtx)
+	}
+	return k, span
+}
@@ -2,9 +2,9 @@ package logic
 
 import (
 	"math"
-	"strings"
 
 	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/model"
 	"github.com/RicheyJang/key_keeper/utils/errors"
 	"github.com/kataras/iris/v12"
 )
@@ -13,6 +13,10 @@ const ctxUserInstanceKey = "user-instance"
 
 // PreCheckOfUserInstance 检查当前用户对所用实例的权限
 func (manager *Manager) PreCheckOfUserInstance(ctx iris.Context) {
+	if manager.isSealed() { // 密封状态下拒绝所有密钥操作
+		responseError(ctx, errors.Sealed)
+		return
+	}
 	identifier := ctx.GetHeader("identifier") // 从Header中获取实例标识
 	i, err := manager.getInstanceAndCheckUser(identifier, ctx)
 	if err != nil {
@@ -51,11 +55,13 @@ func (manager *Manager) HandlerOfGetKeys(ctx iris.Context) {
 	}
 	instance := manager.getUserInstance(ctx)
 	// 获取密钥列表
-	keys, total, err := instance.kp.FilterKeys(keeper.KeysFilter{
+	kp, span := traceKeeper(ctx, instance.kp, instance.Identifier, "FilterKeys")
+	keys, total, err := kp.FilterKeys(keeper.KeysFilter{
 		Offset:  request.Size * (request.Page - 1),
 		Limit:   request.Size,
 		Content: false,
 	})
+	span.End()
 	if err != nil {
 		responseError(ctx, err)
 		return
@@ -83,15 +89,28 @@ func (manager *Manager) HandlerOfAddKey(ctx iris.Context) {
 		return
 	}
 	// 请求校验
-	if request.ID < 1 || request.ID > math.MaxUint32 ||
-		!(request.Length == 16 || request.Length == 24 || request.Length == 32) ||
-		!strings.HasPrefix(request.Algorithm, "aes") {
+	if request.ID < 1 || request.ID > math.MaxUint32 {
 		responseError(ctx, errors.InvalidRequest)
 		return
 	}
 	instance := manager.getUserInstance(ctx)
+	algorithm, ok := keeper.LookupAlgorithm(request.Algorithm)
+	if !ok || !manager.keeperSupports(instance.Keeper, algorithm.Name) {
+		responseError(ctx, errors.UnsupportedAlgorithm)
+		return
+	}
+	if request.Length == 0 && len(algorithm.Lengths) == 1 { // 长度唯一的算法（如非对称算法）可省略长度
+		request.Length = algorithm.Lengths[0]
+	}
+	if !algorithm.ValidLength(request.Length) {
+		responseError(ctx, errors.InvalidKeyLength)
+		return
+	}
+	request.Algorithm = algorithm.Name
 	// 派发密钥
-	key, err := instance.kp.DistributeKey(request)
+	kp, span := traceKeeper(ctx, instance.kp, instance.Identifier, "DistributeKey")
+	key, err := kp.DistributeKey(request)
+	span.End()
 	if err != nil {
 		responseError(ctx, err)
 		return
@@ -112,16 +131,93 @@ func (manager *Manager) HandlerOfDestroyKey(ctx iris.Context) {
 		return
 	}
 	instance := manager.getUserInstance(ctx)
+	// 需双人审批时创建待审批操作
+	if manager.requestApproval(ctx, model.OperationDestroyKey, instance.Identifier, destroyKeyPayload{ID: uint(id)}) {
+		return
+	}
 	// 销毁密钥
-	err := instance.kp.DestroyKey(uint(id))
+	kp, span := traceKeeper(ctx, instance.kp, instance.Identifier, "DestroyKey")
+	err := kp.DestroyKey(uint(id))
+	span.End()
 	if err != nil {
 		responseError(ctx, err)
 		return
 	}
+	manager.publicKeys.remove(instance.Identifier, uint(id))
 	// 返回结果
 	responseSuccess(ctx, "", nil)
 }
 
+// 销毁密钥操作参数
+type destroyKeyPayload struct {
+	ID uint `json:"id"`
+}
+
+type GetPublicKeyRequest struct {
+	Identifier string `url:"identifier"`
+	ID         uint   `url:"id"`
+	Version    uint   `url:"version"` // 为0则获取最新版本
+}
+
+// HandlerOfGetPublicKey 获取非对称密钥的公钥，无需登录
+// 实例不存在、被冻结，密钥不存在、非非对称密钥或版本尚未生效时均回应NoSuchKey，避免泄露实例及密钥信息
+func (manager *Manager) HandlerOfGetPublicKey(ctx iris.Context) {
+	// 解析请求
+	var request GetPublicKeyRequest
+	if err := ctx.ReadQuery(&request); err != nil || request.ID == 0 {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	if manager.isSealed() {
+		responseError(ctx, errors.Sealed)
+		return
+	}
+	instance, ok := manager.getInstance(request.Identifier)
+	if !ok || instance.IsFrozen {
+		responseError(ctx, errors.NoSuchKey)
+		return
+	}
+	// 获取密钥
+	key, ok := manager.publicKeys.get(instance.Identifier, request.ID, request.Version)
+	if !ok {
+		var err error
+		kp, span := traceKeeper(ctx, instance.kp, instance.Identifier, "GetPublicKey")
+		key, err = getPublicKey(kp, request.ID, request.Version)
+		span.End()
+		if err != nil {
+			responseError(ctx, err)
+			return
+		}
+		manager.publicKeys.put(instance.Identifier, request.Version, key)
+	}
+	// 返回结果：保证私钥不暴露
+	key.Key = ""
+	responseSuccess(ctx, "data", iris.Map{
+		"key": key,
+	})
+}
+
+// 获取指定版本的公钥，版本为0时获取最新版本，且不可超过最新版本
+func getPublicKey(kp keeper.KeyKeeper, id uint, version uint) (keeper.KeyInfo, error) {
+	key, err := kp.GetLatestVersionKey(id)
+	if err == nil && version != 0 && version != key.Version {
+		if version > key.Version {
+			return keeper.KeyInfo{}, errors.NoSuchKey
+		}
+		key, err = kp.GetKeyInfo(keeper.KeyRequest{ID: id, Version: version})
+	}
+	if err != nil {
+		if _, ok := err.(errors.Error); ok { // 业务错误（如密钥已冻结）统一视为不存在
+			return keeper.KeyInfo{}, errors.NoSuchKey
+		}
+		return keeper.KeyInfo{}, err
+	}
+	if len(key.PublicKey) == 0 {
+		return keeper.KeyInfo{}, errors.NoSuchKey
+	}
+	return key, nil
+}
+
 // 仅可用于web的/keys系列API，即有PreCheckOfUserInstance中间件
 func (manager *Manager) getUserInstance(ctx iris.Context) *InstanceInfo {
 	return ctx.Values().Get(ctxUserInstanceKey).(*InstanceInfo)
@@ -0,0 +1,201 @@
+package logic
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/model"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/RicheyJang/key_keeper/utils/metrics"
+	"github.com/kataras/iris/v12"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 某一来源IP的登录失败记录
+type loginAttempt struct {
+	lock        sync.Mutex
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// 登录防爆破：按用户名（持久化于用户表）与来源IP（仅内存）分别统计连续失败次数，
+// 达到阈值后锁定，此后每次失败锁定时长翻倍，直至上限
+type loginGuard struct {
+	attempts  sync.Map // 来源IP -> 失败记录(*loginAttempt)
+	lastPrune int64    // 上次清理过期记录的时间戳
+}
+
+// 计算连续失败failures次后的锁定截止时间，未达阈值时返回零值
+func lockoutUntil(failures, threshold int, now time.Time) time.Time {
+	if threshold <= 0 || failures < threshold {
+		return time.Time{}
+	}
+	base := viper.GetDuration("lockout.duration")
+	limit := viper.GetDuration("lockout.maxDuration")
+	duration := base
+	for i := threshold; i < failures && duration < limit; i++ {
+		duration *= 2
+	}
+	if duration > limit {
+		duration = limit
+	}
+	return now.Add(duration)
+}
+
+// 检查来源IP或用户是否处于锁定中，锁定时已回包
+func (manager *Manager) checkLoginLocked(ctx iris.Context, user *model.User) bool {
+	if manager.loginLocked(ctx.RemoteAddr(), user, time.Now()) {
+		responseError(ctx, errors.UserLocked)
+		return true
+	}
+	return false
+}
+
+// 验证用户名及密码并计入登录防爆破：来源IP或用户处于锁定中时不再验证密码（也不再请求外部认证源），失败时已回包
+func (manager *Manager) checkPassword(ctx iris.Context, name, passwd string) (model.User, bool) {
+	user, err := manager.userManager.GetByName(name)
+	if err != nil {
+		log.Errorf("get user %q error: %v", name, err)
+		responseError(ctx, errors.Unknown)
+		return user, false
+	}
+	if manager.checkLoginLocked(ctx, &user) {
+		return user, false
+	}
+	if user, err = manager.userManager.CheckUser(name, passwd); err != nil {
+		manager.recordLoginFailure(ctx, &user)
+		if !manager.checkLoginLocked(ctx, &user) {
+			responseError(ctx, errors.WrongPasswd)
+		}
+		return user, false
+	}
+	return user, true
+}
+
+func (manager *Manager) loginLocked(ip string, user *model.User, now time.Time) bool {
+	if value, ok := manager.loginGuard.attempts.Load(ip); ok {
+		attempt := value.(*loginAttempt)
+		attempt.lock.Lock()
+		locked := now.Before(attempt.lockedUntil)
+		attempt.lock.Unlock()
+		if locked {
+			return true
+		}
+	}
+	return user != nil && user.ID != 0 && now.Before(user.LockedUntil)
+}
+
+// 记录一次登录失败，user为空代表用户名不存在；锁定期间的失败同样记录并延长锁定
+func (manager *Manager) recordLoginFailure(ctx iris.Context, user *model.User) {
+	metrics.ObserveLogin(false)
+	manager.recordFailure(ctx.RemoteAddr(), user, time.Now())
+}
+
+func (manager *Manager) recordFailure(ip string, user *model.User, now time.Time) {
+	// 按来源IP
+	value, _ := manager.loginGuard.attempts.LoadOrStore(ip, new(loginAttempt))
+	attempt := value.(*loginAttempt)
+	attempt.lock.Lock()
+	attempt.failures++
+	attempt.lastFailure = now
+	if until := lockoutUntil(attempt.failures, viper.GetInt("lockout.ipThreshold"), now); until.After(attempt.lockedUntil) {
+		attempt.lockedUntil = until
+	}
+	if now.Before(attempt.lockedUntil) {
+		log.Warnf("login from %v is locked until %v after %d failures", ip, attempt.lockedUntil, attempt.failures)
+	}
+	attempt.lock.Unlock()
+	manager.pruneLoginAttempts(now)
+	// 按用户名
+	if user == nil || user.ID == 0 {
+		return
+	}
+	threshold := viper.GetInt("lockout.threshold")
+	failures, until, err := manager.userManager.SaveLoginFailure(user.ID, ip, func(failures int) time.Time {
+		return lockoutUntil(failures, threshold, now)
+	})
+	if err != nil {
+		log.Errorf("save login failure of user %v error: %v", user.ID, err)
+		return
+	}
+	user.FailedLogins, user.LockedUntil = failures, until
+	if now.Before(until) {
+		log.Warnf("user %v(%v) is locked until %v after %d failures", user.Name, user.ID, until, failures)
+	}
+}
+
+// 登录成功后清除失败记录
+func (manager *Manager) clearLoginFailure(ctx iris.Context, user model.User) {
+	manager.loginGuard.attempts.Delete(ctx.RemoteAddr())
+	if user.FailedLogins == 0 && user.LockedUntil.IsZero() {
+		return
+	}
+	if err := manager.userManager.ClearLoginFailure(user.ID); err != nil {
+		log.Errorf("clear login failure of user %v error: %v", user.ID, err)
+	}
+}
+
+// 清理长时间无失败的IP记录，至多每分钟一次
+func (manager *Manager) pruneLoginAttempts(now time.Time) {
+	last := atomic.LoadInt64(&manager.loginGuard.lastPrune)
+	if now.Unix()-last < 60 || !atomic.CompareAndSwapInt64(&manager.loginGuard.lastPrune, last, now.Unix()) {
+		return
+	}
+	expire := viper.GetDuration("lockout.maxDuration")
+	manager.loginGuard.attempts.Range(func(key, value interface{}) bool {
+		attempt := value.(*loginAttempt)
+		attempt.lock.Lock()
+		stale := now.After(attempt.lockedUntil) && now.Sub(attempt.lastFailure) > expire
+		attempt.lock.Unlock()
+		if stale {
+			manager.loginGuard.attempts.Delete(key)
+		}
+		return true
+	})
+}
+
+type UnlockUserRequest struct {
+	UserID uint   `json:"id"` // 解除指定用户的锁定
+	IP     string `json:"ip"` // 解除指定来源IP的锁定
+}
+
+// HandlerOfUnlockUser 解除登录锁定处理函数
+func (manager *Manager) HandlerOfUnlockUser(ctx iris.Context) {
+	// 权限检查
+	self := manager.getUserClaims(ctx)
+	if self.Level < model.UserLevelAdmin {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	// 校验参数
+	var request UnlockUserRequest
+	if err := ctx.ReadJSON(&request); err != nil || (request.UserID == 0 && len(request.IP) == 0) {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	// 解除用户锁定，保证被解锁用户的权限级别不高于调用者
+	if request.UserID != 0 {
+		user, err := manager.userManager.Get(request.UserID)
+		if err != nil {
+			responseError(ctx, err)
+			return
+		}
+		if user.Level > self.Level {
+			responseError(ctx, errors.PermissionDeny)
+			return
+		}
+		if err = manager.userManager.ClearLoginFailure(user.ID); err != nil {
+			responseError(ctx, err)
+			return
+		}
+	}
+	// 解除来源IP锁定
+	if len(request.IP) > 0 {
+		manager.loginGuard.attempts.Delete(request.IP)
+	}
+	log.Infof("login lockout of user %v / ip %q is cleared by user %v(%v)", request.UserID, request.IP, self.Name, self.ID)
+	responseSuccess(ctx, "", nil)
+}
@@ -0,0 +1,184 @@
+package logic
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/model"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/kataras/iris/v12"
+	"github.com/spf13/viper"
+)
+
+func setLockoutConfig(t *testing.T) {
+	t.Helper()
+	viper.Set("lockout.threshold", 3)
+	viper.Set("lockout.ipThreshold", 5)
+	viper.Set("lockout.duration", time.Minute)
+	viper.Set("lockout.maxDuration", 10*time.Minute)
+	t.Cleanup(viper.Reset)
+}
+
+func TestLockoutUntil(t *testing.T) {
+	setLockoutConfig(t)
+	now := time.Now()
+	cases := []struct {
+		failures  int
+		threshold int
+		duration  time.Duration // 0代表不锁定
+	}{
+		{1, 3, 0},
+		{2, 3, 0},
+		{3, 3, time.Minute},
+		{4, 3, 2 * time.Minute},
+		{5, 3, 4 * time.Minute},
+		{6, 3, 8 * time.Minute},
+		{7, 3, 10 * time.Minute}, // 不超过上限
+		{100, 3, 10 * time.Minute},
+		{100, 0, 0}, // 阈值为0代表不启用
+	}
+	for _, c := range cases {
+		until := lockoutUntil(c.failures, c.threshold, now)
+		var got time.Duration
+		if !until.IsZero() {
+			got = until.Sub(now)
+		}
+		if got != c.duration {
+			t.Errorf("failures %d, threshold %d: got %v, want %v", c.failures, c.threshold, got, c.duration)
+		}
+	}
+}
+
+func TestUserLockout(t *testing.T) {
+	setLockoutConfig(t)
+	manager := newTestManager(t)
+	manager.userManager = model.NewUserManger(manager.db)
+	user, err := manager.userManager.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	ip := "192.0.2.1"
+	// 每次失败后的状态：失败次数、是否锁定
+	steps := []struct {
+		failures int
+		locked   bool
+	}{
+		{1, false},
+		{2, false},
+		{3, true},
+		{4, true}, // 锁定期间的失败同样计数
+	}
+	for _, step := range steps {
+		manager.recordFailure(ip, user, now)
+		stored, err := manager.userManager.Get(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.FailedLogins != step.failures || user.FailedLogins != step.failures {
+			t.Fatalf("got %d failures (stored %d), want %d", user.FailedLogins, stored.FailedLogins, step.failures)
+		}
+		if locked := manager.loginLocked("198.51.100.1", stored, now); locked != step.locked {
+			t.Fatalf("after %d failures: locked %v, want %v", step.failures, locked, step.locked)
+		}
+	}
+	// 锁定期间的失败使锁定时长翻倍
+	stored, _ := manager.userManager.Get(user.ID)
+	if got := stored.LockedUntil.Sub(now); got < 2*time.Minute-time.Second || got > 2*time.Minute+time.Second {
+		t.Fatalf("lockout duration %v, want 2m", got)
+	}
+	if manager.loginLocked("198.51.100.1", stored, now.Add(3*time.Minute)) {
+		t.Fatal("lockout should expire")
+	}
+	// 清除后解锁
+	if err = manager.userManager.ClearLoginFailure(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = manager.userManager.Get(user.ID)
+	if stored.FailedLogins != 0 || manager.loginLocked("198.51.100.1", stored, now) {
+		t.Fatalf("lockout is not cleared: %+v", stored)
+	}
+}
+
+func TestIPLockout(t *testing.T) {
+	setLockoutConfig(t)
+	manager := newTestManager(t)
+	now := time.Now()
+	ip := "192.0.2.2"
+	for i := 1; i <= 5; i++ {
+		if manager.loginLocked(ip, nil, now) {
+			t.Fatalf("locked after %d failures", i-1)
+		}
+		manager.recordFailure(ip, nil, now) // 用户名不存在时仅按来源IP计数
+	}
+	if !manager.loginLocked(ip, nil, now) {
+		t.Fatal("ip should be locked after reaching the threshold")
+	}
+	if manager.loginLocked("192.0.2.3", nil, now) {
+		t.Fatal("other ips should not be locked")
+	}
+	if manager.loginLocked(ip, nil, now.Add(2*time.Minute)) {
+		t.Fatal("ip lockout should expire")
+	}
+}
+
+// 记录调用次数的外部认证器
+type countingAuthenticator struct {
+	calls int
+}
+
+func (a *countingAuthenticator) Source() string {
+	return model.UserSourceLDAP
+}
+
+func (a *countingAuthenticator) Authenticate(string, string) (*model.Identity, error) {
+	a.calls++
+	return nil, errors.WrongPasswd
+}
+
+func TestLoginLockedBeforePasswordCheck(t *testing.T) {
+	setLockoutConfig(t)
+	manager := newTestManager(t)
+	manager.userManager = model.NewUserManger(manager.db)
+	authenticator := &countingAuthenticator{}
+	manager.userManager.AddAuthenticator(authenticator)
+	alice := model.User{Name: "alice", Source: model.UserSourceLDAP}
+	if err := manager.db.Create(&alice).Error; err != nil {
+		t.Fatal(err)
+	}
+	app := iris.New()
+	app.Post("/login", manager.GetLoginHandler())
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	login := func(name string) int {
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"`+name+`","password":"x"}`)))
+		var resp struct{ Code int }
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Code
+	}
+	// 未锁定时交由外部认证源验证
+	if code := login("alice"); code != errors.CodeWrongPasswd || authenticator.calls != 1 {
+		t.Fatalf("got code %v after %v calls", code, authenticator.calls)
+	}
+	// 用户锁定期间不再验证密码
+	if err := manager.db.Model(&alice).Update("locked_until", time.Now().Add(time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if code := login("alice"); code != errors.CodeUserLocked || authenticator.calls != 1 {
+		t.Fatalf("got code %v after %v calls", code, authenticator.calls)
+	}
+	// 来源IP锁定期间不再验证任何用户的密码
+	for i := 0; i < 5; i++ {
+		login("nobody")
+	}
+	if code := login("root"); code != errors.CodeUserLocked {
+		t.Fatalf("got code %v, want the ip to be locked", code)
+	}
+}
@@ -4,10 +4,16 @@ import (
 	"net/http"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/RicheyJang/key_keeper/keeper"
 	"github.com/RicheyJang/key_keeper/model"
+	"github.com/RicheyJang/key_keeper/utils/ca"
 	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/RicheyJang/key_keeper/utils/metrics"
+	"github.com/RicheyJang/key_keeper/utils/oidc"
+	"github.com/RicheyJang/key_keeper/utils/tlspolicy"
+	"github.com/RicheyJang/key_keeper/utils/tracing"
 	"github.com/kataras/iris/v12"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
@@ -17,12 +23,37 @@ import (
 type Manager struct {
 	defaultKName string   // 默认Keeper名称
 	generatorMap sync.Map // keeper名称 -> 生成器(keeper.Generator)
+	algorithmMap sync.Map // keeper名称 -> 支持的算法列表([]keeper.Algorithm)，无记录则支持所有已注册算法
 
-	defaultIns  InstanceInfo // 默认实例
-	instanceMap sync.Map     // 实例标识符 -> 实例信息(*InstanceInfo)
+	defaultIns   InstanceInfo // 默认实例
+	instanceMap  sync.Map     // 实例标识符 -> 实例信息(*InstanceInfo)，更新时替换为新的副本
+	instanceLock sync.Mutex   // 串行化实例信息的更新及移除
 
 	frozenUsers sync.Map           // 此次运行中被冻结的用户ID集合，用于使JWT失效
 	userManager *model.UserManager // 用户管理器
+	loginGuard  loginGuard         // 登录防爆破
+
+	seal     sealState      // 密封状态
+	approval ApprovalOption // 双人审批参数
+
+	oidcOption   OIDCOption     // 单点登录参数
+	oidcProvider *oidc.Provider // 单点登录身份提供方，为空代表未启用
+
+	authority     *ca.Authority // 内置CA，为空代表不可签发证书
+	issuedCerts   sync.Map      // 证书序列号 -> 内置CA签发的证书(issuedCert)
+	issuedCertTTL time.Duration // 已签发证书缓存的有效时长，超过后重新读取吊销状态
+
+	crl            crlState // 证书吊销列表
+	revokedSerials sync.Map // 证书序列号 -> 本地吊销记录(model.RevokedSerial)
+
+	config      configState // 配置热加载
+	rateLimiter rateLimiter // 内部API限流
+	health      healthState // 就绪检查
+
+	tlsPolicies map[string]tlspolicy.Policy // 各监听服务的TLS策略，仅用于展示
+	keyCache    keeper.CacheOption          // 密钥元数据缓存参数
+
+	publicKeys publicKeyCache // 公开的公钥接口所用缓存
 
 	db *gorm.DB
 }
@@ -32,12 +63,20 @@ type Option struct {
 	KGs         []KeeperGeneratorPair // 首项认为是默认生成器
 	DB          *gorm.DB
 	UserManager *model.UserManager
+	Seal        SealOption                  // 密封模式参数
+	Approval    ApprovalOption              // 双人审批参数
+	OIDC        OIDCOption                  // 单点登录参数
+	CA          *ca.Authority               // 内置CA，为空代表不启用
+	Revocation  RevocationOption            // 证书吊销检查参数
+	TLS         map[string]tlspolicy.Policy // 各监听服务的TLS策略：web、inner
+	KeyCache    keeper.CacheOption          // 密钥元数据缓存参数
 }
 
 // KeeperGeneratorPair 密钥保管器名称及其对应的生成器
 type KeeperGeneratorPair struct {
-	KeeperName string           // 密钥保管器名称
-	Generator  keeper.Generator // keeper生成器
+	KeeperName string             // 密钥保管器名称
+	Generator  keeper.Generator   // keeper生成器
+	Algorithms []keeper.Algorithm // 该keeper支持的算法列表，为空则支持所有已注册算法
 }
 
 var onlyOneManager *Manager
@@ -60,10 +99,48 @@ func NewManager(option Option) (*Manager, error) {
 	// 初始化Manager
 	m := new(Manager)
 	m.db = option.DB
+	m.config.snapshot = configSnapshot()
+	m.tlsPolicies = option.TLS
+	m.keyCache = option.KeyCache
 	m.userManager = option.UserManager
 	m.defaultKName = option.KGs[0].KeeperName
 	for _, kg := range option.KGs {
 		m.generatorMap.Store(kg.KeeperName, kg.Generator)
+		if len(kg.Algorithms) > 0 {
+			m.algorithmMap.Store(kg.KeeperName, kg.Algorithms)
+		}
+	}
+	// 初始化密封模式
+	if err := m.initSeal(option.Seal); err != nil {
+		return nil, errors.Newf(-1, "InitSeal failed: %v", err)
+	}
+	// 初始化双人审批
+	m.approval = option.Approval
+	if m.approval.Window <= 0 {
+		m.approval.Window = 24 * time.Hour
+	}
+	if err := m.db.AutoMigrate(&model.Operation{}); err != nil {
+		return nil, errors.Newf(-1, "InitApproval failed: %v", err)
+	}
+	// 初始化API令牌
+	if err := m.db.AutoMigrate(&model.APIToken{}); err != nil {
+		return nil, errors.Newf(-1, "InitAPIToken failed: %v", err)
+	}
+	// 初始化登录会话
+	if err := m.db.AutoMigrate(&model.Session{}); err != nil {
+		return nil, errors.Newf(-1, "InitSession failed: %v", err)
+	}
+	// 初始化内置CA
+	if err := m.initCertificates(option.CA); err != nil {
+		return nil, errors.Newf(-1, "InitCertificates failed: %v", err)
+	}
+	// 初始化证书吊销检查
+	if err := m.initRevocation(option.Revocation); err != nil {
+		return nil, errors.Newf(-1, "InitRevocation failed: %v", err)
+	}
+	// 初始化单点登录
+	if err := m.initOIDC(option.OIDC); err != nil {
+		return nil, errors.Newf(-1, "InitOIDC failed: %v", err)
 	}
 	// 获取所有实例
 	if err := m.initAllInstances(); err != nil {
@@ -100,10 +177,52 @@ func (manager *Manager) HandlerOfGetMetaInfo(ctx iris.Context) {
 		}
 		return keeperNames[i] < keeperNames[j]
 	})
+	// 获取各keeper支持的算法
+	keeperAlgorithms := make(map[string][]keeper.Algorithm)
+	for _, name := range keeperNames {
+		keeperAlgorithms[name] = manager.keeperAlgorithms(name)
+	}
 	// 回包
 	responseSuccess(ctx, "data", iris.Map{
-		"keepers": keeperNames,
+		"keepers":          keeperNames,
+		"algorithms":       keeper.Algorithms(),
+		"keeperAlgorithms": keeperAlgorithms,
+		"seal":             manager.sealStatus(),
+		"oidc":             manager.oidcProvider != nil,
+		"tls":              manager.tlsPolicies,
+	})
+}
+
+// InstanceCount 获取已加载的实例数量
+func (manager *Manager) InstanceCount() int {
+	count := 0
+	manager.instanceMap.Range(func(key, value interface{}) bool {
+		count++
+		return true
 	})
+	return count
+}
+
+// 获取指定keeper支持的算法列表
+func (manager *Manager) keeperAlgorithms(keeperName string) []keeper.Algorithm {
+	if value, ok := manager.algorithmMap.Load(keeperName); ok {
+		return value.([]keeper.Algorithm)
+	}
+	var res []keeper.Algorithm
+	for _, info := range keeper.Algorithms() {
+		res = append(res, info.Name)
+	}
+	return res
+}
+
+// 检查指定keeper是否支持该算法
+func (manager *Manager) keeperSupports(keeperName string, algorithm keeper.Algorithm) bool {
+	for _, name := range manager.keeperAlgorithms(keeperName) {
+		if name == algorithm {
+			return true
+		}
+	}
+	return false
 }
 
 // 回包：出错
@@ -122,6 +241,8 @@ func responseError(c iris.Context, err error) {
 	if errT != errors.Unknown {
 		log.Errorf("API error: %v", err)
 	}
+	metrics.ObserveError(errT.Code)
+	tracing.RecordError(c.Request().Context(), errT)
 	if errT.Code == errors.CodeInner {
 		errT = errors.Unknown
 	}
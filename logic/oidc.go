@@ -0,0 +1,225 @@
+package logic
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/model"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/RicheyJang/key_keeper/utils/oidc"
+	"github.com/kataras/iris/v12"
+	orgjwt "github.com/kataras/jwt"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	oidcCookieName = "kk_oidc"
+	oidcCookiePath = "/api/login/oidc"
+	oidcMaxAge     = 10 * time.Minute
+)
+
+// OIDCOption OIDC单点登录参数
+type OIDCOption struct {
+	Enable        bool
+	Provider      oidc.Config
+	UsernameClaim string         // 作为本地用户名的claim
+	GroupsClaim   string         // 用于映射权限等级的claim，可为字符串或字符串数组
+	GroupLevels   map[string]int // claim值 -> 权限等级，属于多个组时取最高者，不区分大小写
+	DefaultLevel  int            // 未匹配任何组时的权限等级，小于0代表拒绝登录
+}
+
+// 单点登录过程中保存于浏览器Cookie的信息
+type oidcStateClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"` // PKCE校验码
+}
+
+// 初始化OIDC单点登录
+func (manager *Manager) initOIDC(option OIDCOption) error {
+	if !option.Enable {
+		return nil
+	}
+	provider, err := oidc.New(option.Provider)
+	if err != nil {
+		return err
+	}
+	if len(option.UsernameClaim) == 0 {
+		option.UsernameClaim = "preferred_username"
+	}
+	manager.oidcOption, manager.oidcProvider = option, provider
+	return nil
+}
+
+// HandlerOfOIDCLogin 发起单点登录，返回身份提供方的授权地址
+func (manager *Manager) HandlerOfOIDCLogin(ctx iris.Context) {
+	if manager.oidcProvider == nil {
+		responseError(ctx, errors.OIDCNotEnabled)
+		return
+	}
+	claims := oidcStateClaims{
+		State:    randomURLString(),
+		Nonce:    randomURLString(),
+		Verifier: randomURLString(),
+	}
+	authURL, err := manager.oidcProvider.AuthCodeURL(claims.State, claims.Nonce, claims.Verifier)
+	if err != nil {
+		log.Errorf("oidc auth code url error: %v", err)
+		responseError(ctx, err)
+		return
+	}
+	token, err := orgjwt.Sign(orgjwt.HS256, deriveSecretKey("oidc-state"), claims, orgjwt.MaxAge(oidcMaxAge))
+	if err != nil {
+		log.Errorf("sign oidc state error: %v", err)
+		responseError(ctx, errors.Unknown)
+		return
+	}
+	// 将state、nonce及校验码绑定于发起登录的浏览器
+	ctx.SetCookie(&http.Cookie{
+		Name:     oidcCookieName,
+		Value:    string(token),
+		Path:     oidcCookiePath,
+		MaxAge:   int(oidcMaxAge / time.Second),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	responseSuccess(ctx, "data", iris.Map{"url": authURL})
+}
+
+type OIDCCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// GetOIDCCallbackHandler 获取单点登录回调处理函数：以授权码换取ID Token，验证后签发登录token
+func (manager *Manager) GetOIDCCallbackHandler() iris.Handler {
+	signer := newLoginSigner()
+	return func(ctx iris.Context) {
+		if manager.oidcProvider == nil {
+			responseError(ctx, errors.OIDCNotEnabled)
+			return
+		}
+		// 绑定请求
+		var req OIDCCallbackRequest
+		if err := ctx.ReadJSON(&req); err != nil || len(req.Code) == 0 || len(req.State) == 0 {
+			responseError(ctx, errors.InvalidRequest)
+			return
+		}
+		// 校验state
+		cookie := ctx.GetCookie(oidcCookieName)
+		ctx.RemoveCookie(oidcCookieName, iris.CookiePath(oidcCookiePath))
+		verified, err := orgjwt.Verify(orgjwt.HS256, deriveSecretKey("oidc-state"), []byte(cookie))
+		if err != nil {
+			responseError(ctx, errors.InvalidChallenge)
+			return
+		}
+		var state oidcStateClaims
+		if err = verified.Claims(&state); err != nil || state.State != req.State {
+			responseError(ctx, errors.InvalidChallenge)
+			return
+		}
+		// 换取并验证ID Token
+		token, err := manager.oidcProvider.Exchange(req.Code, state.Verifier)
+		if err != nil {
+			log.Warnf("oidc exchange error: %v", err)
+			responseError(ctx, errors.InvalidChallenge)
+			return
+		}
+		claims, err := manager.oidcProvider.VerifyIDToken(token.IDToken, state.Nonce)
+		if err != nil {
+			log.Warnf("oidc verify id token error: %v", err)
+			responseError(ctx, errors.InvalidChallenge)
+			return
+		}
+		// 映射为本地用户
+		identity, ok := manager.oidcIdentity(claims)
+		if !ok {
+			responseError(ctx, errors.PermissionDeny)
+			return
+		}
+		user, err := manager.userManager.LoadExternalUser(model.UserSourceOIDC, identity)
+		if err != nil {
+			responseError(ctx, err)
+			return
+		}
+		// 与密码登录相同的锁定及冻结检查，通过后才创建用户或同步权限等级
+		if manager.checkLoginLocked(ctx, &user) {
+			return
+		}
+		if manager.isUserFrozen(user) {
+			responseError(ctx, errors.UserFrozen)
+			return
+		}
+		if user, err = manager.userManager.SyncExternalUser(model.UserSourceOIDC, identity); err != nil {
+			responseError(ctx, err)
+			return
+		}
+		if user.TOTPEnabled || require2FA(user.Level) {
+			manager.responseChallenge(ctx, user)
+			return
+		}
+		// 生成token
+		data, ok := manager.signIn(ctx, signer, user)
+		if !ok {
+			return
+		}
+		responseSuccess(ctx, "data", data)
+	}
+}
+
+// 根据ID Token中的claims确定用户名及权限等级
+func (manager *Manager) oidcIdentity(claims map[string]interface{}) (model.Identity, bool) {
+	option := manager.oidcOption
+	name, _ := claims[option.UsernameClaim].(string)
+	if len(name) == 0 {
+		log.Warnf("oidc id token has no %q claim", option.UsernameClaim)
+		return model.Identity{}, false
+	}
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	if len(subject) == 0 { // 以iss及sub而非可修改的用户名匹配本地用户
+		log.Warnf("oidc id token of %q has no sub claim", name)
+		return model.Identity{}, false
+	}
+	// 映射权限等级
+	var groups []string
+	switch value := claims[option.GroupsClaim].(type) {
+	case string:
+		groups = []string{value}
+	case []interface{}:
+		for _, item := range value {
+			if group, ok := item.(string); ok {
+				groups = append(groups, group)
+			}
+		}
+	}
+	level, matched := -1, false
+	for _, group := range groups {
+		for key, l := range option.GroupLevels {
+			if strings.EqualFold(key, group) && l > level {
+				level, matched = l, true
+			}
+		}
+	}
+	if !matched {
+		level = option.DefaultLevel
+	}
+	if level < 0 {
+		log.Warnf("oidc user %q is not in any permitted group", name)
+		return model.Identity{}, false
+	}
+	if level > model.UserLevelRoot {
+		level = model.UserLevelRoot
+	}
+	return model.Identity{Name: name, Level: level, Issuer: issuer, Subject: subject}, true
+}
+
+func randomURLString() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,77 @@
+package logic
+
+import (
+	"sync"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/keeper"
+)
+
+// 公钥缓存：公开的公钥接口无需登录，缓存以避免每次请求均解密、派生私钥
+const (
+	publicKeyCacheTTL  = time.Minute // 缓存项有效期，销毁实例等未主动失效的变更最多延迟该时长生效
+	publicKeyCacheSize = 4096
+)
+
+type publicKeyIndex struct {
+	identifier string
+	id         uint
+	version    uint // 为0代表最新版本
+}
+
+type publicKeyEntry struct {
+	key      keeper.KeyInfo // 不含私钥
+	expireAt time.Time
+}
+
+type publicKeyCache struct {
+	sync.Mutex
+	entries map[publicKeyIndex]publicKeyEntry
+}
+
+func (cache *publicKeyCache) get(identifier string, id, version uint) (keeper.KeyInfo, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+	entry, ok := cache.entries[publicKeyIndex{identifier: identifier, id: id, version: version}]
+	if !ok || !time.Now().Before(entry.expireAt) {
+		return keeper.KeyInfo{}, false
+	}
+	return entry.key, true
+}
+
+// put 写入缓存，最新版本的缓存项不超过该版本的轮替时间
+func (cache *publicKeyCache) put(identifier string, version uint, key keeper.KeyInfo) {
+	key.Key = ""
+	expireAt := time.Now().Add(publicKeyCacheTTL)
+	if version == 0 && key.Timeout > 0 {
+		if timeout := time.Unix(int64(key.Timeout), 0); timeout.Before(expireAt) {
+			expireAt = timeout
+		}
+	}
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.entries == nil {
+		cache.entries = make(map[publicKeyIndex]publicKeyEntry)
+	}
+	if len(cache.entries) >= publicKeyCacheSize { // 超出上限时先清理过期项，仍超出则随机淘汰
+		now := time.Now()
+		for index, entry := range cache.entries {
+			if !now.Before(entry.expireAt) || len(cache.entries) >= publicKeyCacheSize {
+				delete(cache.entries, index)
+			}
+		}
+	}
+	cache.entries[publicKeyIndex{identifier: identifier, id: key.ID, version: version}] =
+		publicKeyEntry{key: key, expireAt: expireAt}
+}
+
+// remove 删除指定密钥的所有缓存项，用于销毁密钥后
+func (cache *publicKeyCache) remove(identifier string, id uint) {
+	cache.Lock()
+	defer cache.Unlock()
+	for index := range cache.entries {
+		if index.identifier == identifier && index.id == id {
+			delete(cache.entries, index)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package logic
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/RicheyJang/key_keeper/model"
+	"github.com/RicheyJang/key_keeper/utils/ca"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/kataras/iris/v12"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+// 内部API限流器
+type rateLimiter struct {
+	instances sync.Map // 实例标识符 -> *limiterEntry
+	certs     sync.Map // 客户端证书序列号 -> *limiterEntry
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	allowed  uint64
+	rejected uint64
+}
+
+// RateLimitStat 限流计数
+type RateLimitStat struct {
+	Key      string  `json:"key"`   // 实例标识符或证书序列号
+	Rate     float64 `json:"rate"`  // 每秒请求数上限，小于0代表不限
+	Burst    int     `json:"burst"` // 突发请求数上限
+	Allowed  uint64  `json:"allowed"`
+	Rejected uint64  `json:"rejected"`
+}
+
+// 检查内部API请求是否超出实例及客户端证书的限流
+func (manager *Manager) checkRateLimit(ctx iris.Context, info *InstanceInfo) error {
+	limit, burst := info.RateLimit, info.RateBurst
+	if limit == 0 {
+		limit = viper.GetFloat64("rateLimit.instance")
+	}
+	if burst == 0 {
+		burst = viper.GetInt("rateLimit.instanceBurst")
+	}
+	if !allowRequest(&manager.rateLimiter.instances, info.Identifier, limit, burst) {
+		return errors.RateLimited
+	}
+	state := ctx.Request().TLS
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	serial := ca.SerialString(state.PeerCertificates[0].SerialNumber)
+	if !allowRequest(&manager.rateLimiter.certs, serial, viper.GetFloat64("rateLimit.cert"), viper.GetInt("rateLimit.certBurst")) {
+		return errors.RateLimited
+	}
+	return nil
+}
+
+// 按key取得限流器并消耗一次请求额度；限流参数变更后即时生效
+func allowRequest(entries *sync.Map, key string, limit float64, burst int) bool {
+	l, b := rateOf(limit, burst)
+	value, ok := entries.Load(key)
+	if !ok {
+		value, _ = entries.LoadOrStore(key, &limiterEntry{limiter: rate.NewLimiter(l, b)})
+	}
+	entry := value.(*limiterEntry)
+	if entry.limiter.Limit() != l {
+		entry.limiter.SetLimit(l)
+	}
+	if entry.limiter.Burst() != b {
+		entry.limiter.SetBurst(b)
+	}
+	if entry.limiter.Allow() {
+		atomic.AddUint64(&entry.allowed, 1)
+		return true
+	}
+	atomic.AddUint64(&entry.rejected, 1)
+	return false
+}
+
+// 将配置转换为限流参数：limit不大于0代表不限，burst不大于0时取limit向上取整
+func rateOf(limit float64, burst int) (rate.Limit, int) {
+	if limit <= 0 {
+		return rate.Inf, 0
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(limit))
+	}
+	return rate.Limit(limit), burst
+}
+
+// RateLimitStats 获取各实例及客户端证书的限流计数
+func (manager *Manager) RateLimitStats() (instances []RateLimitStat, certs []RateLimitStat) {
+	return collectStats(&manager.rateLimiter.instances), collectStats(&manager.rateLimiter.certs)
+}
+
+func collectStats(entries *sync.Map) []RateLimitStat {
+	stats := make([]RateLimitStat, 0)
+	entries.Range(func(key, value interface{}) bool {
+		entry := value.(*limiterEntry)
+		stat := RateLimitStat{
+			Key:      key.(string),
+			Rate:     float64(entry.limiter.Limit()),
+			Burst:    entry.limiter.Burst(),
+			Allowed:  atomic.LoadUint64(&entry.allowed),
+			Rejected: atomic.LoadUint64(&entry.rejected),
+		}
+		if entry.limiter.Limit() == rate.Inf {
+			stat.Rate = -1
+		}
+		stats = append(stats, stat)
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Key < stats[j].Key
+	})
+	return stats
+}
+
+// HandlerOfGetRateLimitStats 获取内部API限流计数
+func (manager *Manager) HandlerOfGetRateLimitStats(ctx iris.Context) {
+	// 权限检查
+	if manager.getUserClaims(ctx).Level < model.UserLevelAdmin {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	instances, certs := manager.RateLimitStats()
+	responseSuccess(ctx, "data", iris.Map{
+		"instances": instances,
+		"certs":     certs,
+	})
+}
+
+type SetRateLimitRequest struct {
+	Identifier string  `json:"identifier"`
+	RateLimit  float64 `json:"rateLimit"` // 每秒请求数上限，0代表使用默认值，小于0代表不限
+	RateBurst  int     `json:"rateBurst"` // 突发请求数上限，0代表使用默认值
+}
+
+// HandlerOfSetRateLimit 设置实例的内部API限流参数
+func (manager *Manager) HandlerOfSetRateLimit(ctx iris.Context) {
+	// 权限检查
+	self := manager.getUserClaims(ctx)
+	if self.Level < model.UserLevelAdmin {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	// 校验参数
+	var request SetRateLimitRequest
+	if err := ctx.ReadJSON(&request); err != nil || request.RateBurst < 0 ||
+		math.IsNaN(request.RateLimit) || math.IsInf(request.RateLimit, 0) {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	if _, err := manager.getInstanceAndCheckUser(request.Identifier, ctx); err != nil {
+		responseError(ctx, err)
+		return
+	}
+	// 更新
+	if err := manager.db.Model(&model.Instance{}).Where("identifier = ?", request.Identifier).
+		Updates(map[string]interface{}{"rate_limit": request.RateLimit, "rate_burst": request.RateBurst}).Error; err != nil {
+		responseError(ctx, err)
+		return
+	}
+	manager.updateInstanceInfo(request.Identifier, func(info *InstanceInfo) {
+		info.RateLimit, info.RateBurst = request.RateLimit, request.RateBurst
+	})
+	log.Infof("rate limit of instance %v is set to %v/s (burst %v) by user %v(%v)",
+		request.Identifier, request.RateLimit, request.RateBurst, self.Name, self.ID)
+	responseSuccess(ctx, "", nil)
+}
@@ -0,0 +1,300 @@
+package logic
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/model"
+	"github.com/RicheyJang/key_keeper/utils/ca"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/fsnotify/fsnotify"
+	"github.com/kataras/iris/v12"
+	log "github.com/sirupsen/logrus"
+)
+
+// RevocationOption 证书吊销检查参数
+type RevocationOption struct {
+	CRL string // CRL文件路径，为空代表不使用CRL；文件变更后自动重新加载
+	CA  string // 用于验证CRL签名的CA证书路径
+
+	Refresh time.Duration // 从数据库重新读取本地吊销列表及已签发证书的间隔，用于同步其它副本的变更，不大于0时使用默认值
+}
+
+// 默认的吊销状态同步间隔
+const defaultRevocationRefresh = 10 * time.Second
+
+// CRL加载状态
+type crlState struct {
+	sync.RWMutex
+	file     string
+	issuers  []*x509.Certificate
+	crl      *ca.CRL
+	loadedAt time.Time
+	err      error // 最近一次加载错误，加载失败时沿用旧的CRL
+}
+
+// 初始化证书吊销检查
+func (manager *Manager) initRevocation(option RevocationOption) error {
+	if err := manager.db.AutoMigrate(&model.RevokedSerial{}); err != nil {
+		return err
+	}
+	// 加载本地吊销列表，并定期同步其它副本的变更：吊销须在所有副本生效，不允许关闭
+	if option.Refresh <= 0 {
+		option.Refresh = defaultRevocationRefresh
+	}
+	manager.issuedCertTTL = option.Refresh
+	if err := manager.refreshRevocations(); err != nil {
+		return err
+	}
+	go func() {
+		for range time.Tick(option.Refresh) {
+			if err := manager.refreshRevocations(); err != nil {
+				log.Errorf("refresh revocations error: %v", err)
+			}
+		}
+	}()
+	// 加载CRL
+	if len(option.CRL) == 0 {
+		return nil
+	}
+	caPEM, err := ioutil.ReadFile(option.CA)
+	if err != nil {
+		return err
+	}
+	manager.crl.file = option.CRL
+	manager.crl.issuers = ca.ParseCertificates(caPEM)
+	if err = manager.reloadCRL(); err != nil {
+		return err
+	}
+	return manager.watchCRL()
+}
+
+// 从数据库重新读取本地吊销列表及未过期的已签发证书，并移除已不存在的记录
+func (manager *Manager) refreshRevocations() error {
+	var serials []model.RevokedSerial
+	if err := manager.db.Find(&serials).Error; err != nil {
+		return err
+	}
+	var certs []model.Certificate
+	if err := manager.db.Where("not_after > ?", time.Now()).Find(&certs).Error; err != nil {
+		return err
+	}
+	revoked := make(map[string]struct{}, len(serials))
+	for _, serial := range serials {
+		manager.revokedSerials.Store(serial.Serial, serial)
+		revoked[serial.Serial] = struct{}{}
+	}
+	manager.revokedSerials.Range(func(key, value interface{}) bool {
+		if _, ok := revoked[key.(string)]; !ok {
+			manager.revokedSerials.Delete(key)
+		}
+		return true
+	})
+	issued := make(map[string]struct{}, len(certs))
+	for _, cert := range certs {
+		manager.storeIssuedCert(cert)
+		issued[cert.Serial] = struct{}{}
+	}
+	manager.issuedCerts.Range(func(key, value interface{}) bool {
+		if _, ok := issued[key.(string)]; !ok {
+			manager.issuedCerts.Delete(key)
+		}
+		return true
+	})
+	return nil
+}
+
+// 重新加载CRL文件
+func (manager *Manager) reloadCRL() error {
+	crl, err := ca.LoadCRL(manager.crl.file, manager.crl.issuers)
+	manager.crl.Lock()
+	defer manager.crl.Unlock()
+	manager.crl.err = err
+	if err != nil {
+		return err
+	}
+	manager.crl.crl = crl
+	manager.crl.loadedAt = time.Now()
+	if !crl.NextUpdate.IsZero() && crl.NextUpdate.Before(time.Now()) {
+		log.Warnf("crl %v is out of date since %v", manager.crl.file, crl.NextUpdate)
+	}
+	log.Infof("crl %v is loaded with %v revoked serials", manager.crl.file, len(crl.Serials))
+	return nil
+}
+
+// 监听CRL文件变更；监听其所在目录以兼容替换文件式的更新
+func (manager *Manager) watchCRL() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	file := filepath.Clean(manager.crl.file)
+	if err = watcher.Add(filepath.Dir(file)); err != nil {
+		_ = watcher.Close()
+		return err
+	}
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != file || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				if err := manager.reloadCRL(); err != nil {
+					log.Errorf("reload crl %v error: %v", file, err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("watch crl %v error: %v", file, err)
+			}
+		}
+	}()
+	return nil
+}
+
+// 证书是否已被吊销：CRL、本地吊销列表或内置CA吊销记录
+func (manager *Manager) isCertRevoked(cert *x509.Certificate) bool {
+	serial := ca.SerialString(cert.SerialNumber)
+	if _, ok := manager.revokedSerials.Load(serial); ok {
+		return true
+	}
+	if issued, err := manager.getIssuedCert(cert); err != nil {
+		log.Warnf("read revocation of certificate %v error: %v", serial, err)
+		if value, ok := manager.issuedCerts.Load(serial); ok && value.(issuedCert).IsRevoked { // 沿用缓存
+			return true
+		}
+	} else if issued != nil && issued.IsRevoked {
+		return true
+	}
+	manager.crl.RLock()
+	defer manager.crl.RUnlock()
+	if manager.crl.crl != nil {
+		if _, ok := manager.crl.crl.Serials[serial]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyPeerCertificate TLS握手时检查客户端证书链是否已被吊销，用于内部API
+func (manager *Manager) VerifyPeerCertificate(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			if cert.IsCA && cert.CheckSignatureFrom(cert) == nil { // 跳过根证书
+				continue
+			}
+			if manager.isCertRevoked(cert) {
+				log.Warnf("reject revoked certificate %v (%v)", ca.SerialString(cert.SerialNumber), cert.Subject.CommonName)
+				return errors.CertRevoked
+			}
+		}
+	}
+	return nil
+}
+
+// HandlerOfGetRevokedSerials 获取本地吊销列表及CRL状态
+func (manager *Manager) HandlerOfGetRevokedSerials(ctx iris.Context) {
+	// 权限检查
+	if manager.getUserClaims(ctx).Level < model.UserLevelAdmin {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	// 查询
+	serials := make([]model.RevokedSerial, 0)
+	if err := manager.db.Order("id desc").Find(&serials).Error; err != nil {
+		responseError(ctx, err)
+		return
+	}
+	crl := iris.Map{"file": manager.crl.file}
+	manager.crl.RLock()
+	if manager.crl.crl != nil {
+		crl["count"] = len(manager.crl.crl.Serials)
+		crl["thisUpdate"] = manager.crl.crl.ThisUpdate
+		crl["nextUpdate"] = manager.crl.crl.NextUpdate
+		crl["loadedAt"] = manager.crl.loadedAt
+	}
+	if manager.crl.err != nil {
+		crl["error"] = manager.crl.err.Error()
+	}
+	manager.crl.RUnlock()
+	responseSuccess(ctx, "data", iris.Map{
+		"serials": serials,
+		"crl":     crl,
+	})
+}
+
+type RevokeSerialRequest struct {
+	Serial string `json:"serial"` // 十六进制序列号，可含冒号分隔
+	Reason string `json:"reason"`
+}
+
+// HandlerOfRevokeSerial 将证书序列号加入本地吊销列表
+func (manager *Manager) HandlerOfRevokeSerial(ctx iris.Context) {
+	// 权限检查
+	self := manager.getUserClaims(ctx)
+	if self.Level < model.UserLevelAdmin {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	// 校验参数
+	var request RevokeSerialRequest
+	if err := ctx.ReadJSON(&request); err != nil {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	serial, err := ca.ParseSerial(request.Serial)
+	if err != nil {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	if _, ok := manager.revokedSerials.Load(serial); ok {
+		responseSuccess(ctx, "", nil)
+		return
+	}
+	// 吊销
+	record := model.RevokedSerial{
+		Serial:   serial,
+		Reason:   request.Reason,
+		UserID:   self.ID,
+		UserName: self.Name,
+	}
+	if err = manager.db.Create(&record).Error; err != nil {
+		responseError(ctx, err)
+		return
+	}
+	manager.revokedSerials.Store(serial, record)
+	log.Warnf("certificate serial %v is revoked by user %v(%v): %v", serial, self.Name, self.ID, request.Reason)
+	responseSuccess(ctx, "", nil)
+}
+
+// HandlerOfDeleteRevokedSerial 将证书序列号移出本地吊销列表
+func (manager *Manager) HandlerOfDeleteRevokedSerial(ctx iris.Context) {
+	// 权限检查
+	self := manager.getUserClaims(ctx)
+	if self.Level < model.UserLevelRoot {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	// 校验参数
+	serial, err := ca.ParseSerial(ctx.URLParam("serial"))
+	if err != nil {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	// 删除
+	if err = manager.db.Where("serial = ?", serial).Delete(&model.RevokedSerial{}).Error; err != nil {
+		responseError(ctx, err)
+		return
+	}
+	manager.revokedSerials.Delete(serial)
+	log.Warnf("certificate serial %v is removed from revocation list by user %v(%v)", serial, self.Name, self.ID)
+	responseSuccess(ctx, "", nil)
+}
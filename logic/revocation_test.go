@@ -0,0 +1,182 @@
+package logic
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/model"
+	"github.com/RicheyJang/key_keeper/utils/ca"
+)
+
+func newTestRevocationManager(t *testing.T) (*Manager, *ca.Authority) {
+	t.Helper()
+	authority, err := ca.NewAuthority("Test CA", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := newTestManager(t, &model.Certificate{}, &model.RevokedSerial{})
+	manager.authority = authority
+	return manager, authority
+}
+
+func issueTestCert(t *testing.T, authority *ca.Authority, identifier string) *x509.Certificate {
+	t.Helper()
+	_, _, cert, err := authority.IssueClient(identifier, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// 模拟其它副本直接写入数据库的变更
+func TestRefreshRevocations(t *testing.T) {
+	manager, authority := newTestRevocationManager(t)
+	leaf := issueTestCert(t, authority, "ins")
+	serial := ca.SerialString(leaf.SerialNumber)
+	if err := manager.refreshRevocations(); err != nil {
+		t.Fatal(err)
+	}
+	if manager.isCertRevoked(leaf) {
+		t.Fatal("revoked before any revocation")
+	}
+	// 本地吊销列表
+	if err := manager.db.Create(&model.RevokedSerial{Serial: serial}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.refreshRevocations(); err != nil {
+		t.Fatal(err)
+	}
+	if !manager.isCertRevoked(leaf) {
+		t.Fatal("serial revoked by another replica is not picked up")
+	}
+	if err := manager.db.Where("serial = ?", serial).Delete(&model.RevokedSerial{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.refreshRevocations(); err != nil {
+		t.Fatal(err)
+	}
+	if manager.isCertRevoked(leaf) {
+		t.Fatal("serial removed by another replica is still revoked")
+	}
+	// 内置CA吊销记录
+	cert := model.Certificate{Serial: serial, Identifier: "ins", NotAfter: leaf.NotAfter}
+	if err := manager.db.Create(&cert).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.refreshRevocations(); err != nil {
+		t.Fatal(err)
+	}
+	if manager.isCertRevoked(leaf) {
+		t.Fatal("issued certificate is revoked")
+	}
+	if err := manager.db.Model(&cert).Update("is_revoked", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.refreshRevocations(); err != nil {
+		t.Fatal(err)
+	}
+	if !manager.isCertRevoked(leaf) {
+		t.Fatal("certificate revoked by another replica is not picked up")
+	}
+}
+
+func TestGetIssuedCert(t *testing.T) {
+	manager, authority := newTestRevocationManager(t)
+	// 由其它副本签发、尚未同步的证书：缓存未命中时查询数据库
+	leaf := issueTestCert(t, authority, "ins")
+	if err := manager.db.Create(&model.Certificate{
+		Serial: ca.SerialString(leaf.SerialNumber), Identifier: "ins", NotAfter: leaf.NotAfter,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+	cert, err := manager.getIssuedCert(leaf)
+	if err != nil || cert == nil || cert.Identifier != "ins" {
+		t.Fatalf("got %v, %v", cert, err)
+	}
+	if _, ok := manager.issuedCerts.Load(cert.Serial); !ok {
+		t.Fatal("certificate is not cached")
+	}
+	// 内置CA签发但无记录的证书、外部签发的证书
+	other, err := ca.NewAuthority("Other CA", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaf := range []*x509.Certificate{issueTestCert(t, authority, "unknown"), issueTestCert(t, other, "ins")} {
+		if cert, err = manager.getIssuedCert(leaf); err != nil || cert != nil {
+			t.Fatalf("got %v, %v", cert, err)
+		}
+	}
+}
+
+// 其它副本吊销证书后，缓存超过有效时长即重新读取吊销状态，无需等待定期同步
+func TestIssuedCertRevocationTTL(t *testing.T) {
+	manager, authority := newTestRevocationManager(t)
+	manager.issuedCertTTL = time.Hour
+	leaf := issueTestCert(t, authority, "ins")
+	cert := model.Certificate{Serial: ca.SerialString(leaf.SerialNumber), Identifier: "ins", NotAfter: leaf.NotAfter}
+	if err := manager.db.Create(&cert).Error; err != nil {
+		t.Fatal(err)
+	}
+	if manager.isCertRevoked(leaf) { // 缓存未命中时读取数据库并缓存
+		t.Fatal("issued certificate is revoked")
+	}
+	if err := manager.db.Model(&cert).Update("is_revoked", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	if manager.isCertRevoked(leaf) {
+		t.Fatal("fresh cache is not used")
+	}
+	manager.issuedCertTTL = 0
+	if !manager.isCertRevoked(leaf) {
+		t.Fatal("certificate revoked by another replica is not picked up after the cache is stale")
+	}
+}
+
+func TestCRLRevocation(t *testing.T) {
+	manager, authority := newTestRevocationManager(t)
+	revoked, valid := issueTestCert(t, authority, "a"), issueTestCert(t, authority, "b")
+	now := time.Now()
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              big.NewInt(1),
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(time.Hour),
+		RevokedCertificates: []pkix.RevokedCertificate{{SerialNumber: revoked.SerialNumber, RevocationTime: now}},
+	}, authority.Cert, authority.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.crl.file = filepath.Join(t.TempDir(), "ca.crl")
+	manager.crl.issuers = []*x509.Certificate{authority.Cert}
+	if err = ioutil.WriteFile(manager.crl.file, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = manager.reloadCRL(); err != nil {
+		t.Fatal(err)
+	}
+	if !manager.isCertRevoked(revoked) || manager.isCertRevoked(valid) {
+		t.Fatal("unexpected revocation state")
+	}
+	if err = manager.VerifyPeerCertificate(nil, [][]*x509.Certificate{{revoked, authority.Cert}}); err == nil {
+		t.Fatal("revoked chain is accepted")
+	}
+	if err = manager.VerifyPeerCertificate(nil, [][]*x509.Certificate{{valid, authority.Cert}}); err != nil {
+		t.Fatalf("valid chain is rejected: %v", err)
+	}
+	// 加载失败时沿用旧的CRL
+	if err = ioutil.WriteFile(manager.crl.file, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = manager.reloadCRL(); err == nil {
+		t.Fatal("broken crl is loaded")
+	}
+	if !manager.isCertRevoked(revoked) {
+		t.Fatal("previous crl is dropped")
+	}
+}
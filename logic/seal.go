@@ -0,0 +1,241 @@
+package logic
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/RicheyJang/key_keeper/model"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/RicheyJang/key_keeper/utils/shamir"
+	"github.com/kataras/iris/v12"
+	log "github.com/sirupsen/logrus"
+)
+
+// SealOption 密封模式参数
+type SealOption struct {
+	Enable    bool
+	Shares    int // 根密钥分片总数（仅在首次初始化时使用）
+	Threshold int // 解封所需分片数（仅在首次初始化时使用）
+}
+
+// 密封状态：密封时根密钥不在内存中，所有密钥操作均被拒绝
+type sealState struct {
+	enable  bool
+	info    model.SealInfo
+	lock    sync.RWMutex
+	rootKey []byte   // 为空代表处于密封状态
+	pending [][]byte // 已提交的分片
+}
+
+const rootKeyLength = 32
+
+var rootKeyVerifierPlain = []byte("key_keeper root key")
+
+// 初始化密封模式：首次启动时生成根密钥及其分片，并保持解封状态；此后每次启动均处于密封状态
+func (manager *Manager) initSeal(option SealOption) error {
+	if !option.Enable {
+		return nil
+	}
+	manager.seal.enable = true
+	if err := manager.db.AutoMigrate(&model.SealInfo{}); err != nil {
+		return err
+	}
+	result := manager.db.Limit(1).Find(&manager.seal.info)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Warn("key keeper is sealed, submit unseal shares via /api/unseal")
+		return nil
+	}
+	// 首次启动：生成根密钥及其分片
+	rootKey := make([]byte, rootKeyLength)
+	if _, err := rand.Read(rootKey); err != nil {
+		return err
+	}
+	shares, err := shamir.Split(rootKey, option.Shares, option.Threshold)
+	if err != nil {
+		return err
+	}
+	verifier, err := sealWithKey(rootKey, rootKeyVerifierPlain)
+	if err != nil {
+		return err
+	}
+	digests := make([]string, len(shares))
+	for i, share := range shares {
+		digests[i] = shareDigest(share)
+	}
+	manager.seal.info = model.SealInfo{
+		Shares:    option.Shares,
+		Threshold: option.Threshold,
+		Verifier:  verifier,
+		Digests:   strings.Join(digests, ","),
+	}
+	if err = manager.db.Create(&manager.seal.info).Error; err != nil {
+		return err
+	}
+	manager.seal.rootKey = rootKey
+	// 分片仅输出至标准输出，不写入日志文件
+	fmt.Fprintf(os.Stdout, "Root key generated. Distribute these %d unseal shares to different operators, any %d of them can unseal key keeper. They will NOT be shown again:\n",
+		option.Shares, option.Threshold)
+	for i, share := range shares {
+		fmt.Fprintf(os.Stdout, "  share %d: %s\n", i+1, hex.EncodeToString(share))
+	}
+	return nil
+}
+
+// 获取根密钥：未启用密封模式时返回空函数
+func (manager *Manager) rootKeyGetter() func() ([]byte, error) {
+	if !manager.seal.enable {
+		return nil
+	}
+	return manager.getRootKey
+}
+
+// 获取根密钥的副本，密封状态下返回errors.Sealed
+func (manager *Manager) getRootKey() ([]byte, error) {
+	manager.seal.lock.RLock()
+	defer manager.seal.lock.RUnlock()
+	if manager.seal.rootKey == nil {
+		return nil, errors.Sealed
+	}
+	return append([]byte{}, manager.seal.rootKey...), nil
+}
+
+// 是否处于密封状态
+func (manager *Manager) isSealed() bool {
+	if !manager.seal.enable {
+		return false
+	}
+	manager.seal.lock.RLock()
+	defer manager.seal.lock.RUnlock()
+	return manager.seal.rootKey == nil
+}
+
+// 获取密封状态信息
+func (manager *Manager) sealStatus() iris.Map {
+	manager.seal.lock.RLock()
+	defer manager.seal.lock.RUnlock()
+	return iris.Map{
+		"enable":    manager.seal.enable,
+		"sealed":    manager.seal.enable && manager.seal.rootKey == nil,
+		"progress":  len(manager.seal.pending),
+		"threshold": manager.seal.info.Threshold,
+	}
+}
+
+type UnsealRequest struct {
+	Share string `json:"share"` // 16进制格式的根密钥分片
+}
+
+// HandlerOfUnseal 提交根密钥分片，集齐所需数量后解封
+func (manager *Manager) HandlerOfUnseal(ctx iris.Context) {
+	if !manager.seal.enable {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	// 校验参数
+	var request UnsealRequest
+	if err := ctx.ReadJSON(&request); err != nil {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	share, err := hex.DecodeString(request.Share)
+	if err != nil || len(share) != rootKeyLength+1 {
+		responseError(ctx, errors.InvalidShare)
+		return
+	}
+	// 提交分片
+	if err = manager.submitShare(share); err != nil {
+		responseError(ctx, err)
+		return
+	}
+	responseSuccess(ctx, "data", manager.sealStatus())
+}
+
+// HandlerOfSeal 紧急情况下重新密封
+func (manager *Manager) HandlerOfSeal(ctx iris.Context) {
+	// 权限检查
+	self := manager.getUserClaims(ctx)
+	if self.Level < model.UserLevelAdmin {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	if !manager.seal.enable {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	// 清除内存中的根密钥
+	manager.seal.lock.Lock()
+	for i := range manager.seal.rootKey {
+		manager.seal.rootKey[i] = 0
+	}
+	manager.seal.rootKey = nil
+	manager.seal.pending = nil
+	manager.seal.lock.Unlock()
+	log.Warnf("key keeper is sealed by user %v(%v)", self.Name, self.ID)
+	responseSuccess(ctx, "data", manager.sealStatus())
+}
+
+// 提交分片，集齐所需数量后恢复并校验根密钥
+func (manager *Manager) submitShare(share []byte) error {
+	manager.seal.lock.Lock()
+	defer manager.seal.lock.Unlock()
+	if manager.seal.rootKey != nil { // 已解封
+		return nil
+	}
+	if !manager.seal.validShare(share) { // 无效分片不计入，避免他人提交错误分片清空已提交的分片
+		log.Warn("unseal share rejected: digest does not match")
+		return errors.InvalidShare
+	}
+	for _, old := range manager.seal.pending {
+		if bytes.Equal(old, share) {
+			return nil
+		}
+	}
+	manager.seal.pending = append(manager.seal.pending, share)
+	if len(manager.seal.pending) < manager.seal.info.Threshold {
+		return nil
+	}
+	// 恢复根密钥
+	rootKey, err := shamir.Combine(manager.seal.pending)
+	if err == nil {
+		_, err = openWithKey(rootKey, manager.seal.info.Verifier)
+	}
+	if err != nil { // 仅旧版本未记录分片摘要时可能发生：仅丢弃本次提交的分片
+		manager.seal.pending = manager.seal.pending[:len(manager.seal.pending)-1]
+		log.Warn("unseal failed: recovered root key does not match")
+		return errors.InvalidShare
+	}
+	manager.seal.pending = nil
+	manager.seal.rootKey = rootKey
+	log.Info("key keeper is unsealed")
+	return nil
+}
+
+// 根据初始化时记录的摘要校验单份分片，旧版本未记录摘要时不校验
+func (seal *sealState) validShare(share []byte) bool {
+	if len(seal.info.Digests) == 0 {
+		return true
+	}
+	digest := shareDigest(share)
+	for _, expected := range strings.Split(seal.info.Digests, ",") {
+		if subtle.ConstantTimeCompare([]byte(digest), []byte(expected)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// 分片摘要：不足门限数量的分片不泄露根密钥信息，摘要亦无法用于推算分片
+func shareDigest(share []byte) string {
+	sum := sha256.Sum256(append([]byte("key_keeper unseal share:"), share...))
+	return hex.EncodeToString(sum[:])
+}
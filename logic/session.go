@@ -0,0 +1,267 @@
+package logic
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/model"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const refreshTokenPrefix = "kkr_"
+
+// 访问token有效时长
+func accessMaxAge() time.Duration {
+	maxAge := viper.GetDuration("user.accessMaxAge")
+	if maxAge < time.Minute {
+		maxAge = time.Minute
+	}
+	return maxAge
+}
+
+// 会话绝对有效时长
+func sessionMaxAge() time.Duration {
+	maxAge := viper.GetDuration("user.maxAge")
+	if maxAge < time.Minute {
+		maxAge = time.Minute
+	}
+	return maxAge
+}
+
+// 创建会话，返回会话及刷新token明文
+func (manager *Manager) createSession(ctx iris.Context, userID uint) (model.Session, string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return model.Session{}, "", err
+	}
+	refresh, err := generateRefreshToken()
+	if err != nil {
+		return model.Session{}, "", err
+	}
+	now := time.Now()
+	session := model.Session{
+		ID:          hex.EncodeToString(id),
+		UserID:      userID,
+		RefreshHash: hashToken(refresh),
+		IP:          ctx.RemoteAddr(),
+		UserAgent:   ctx.GetHeader("User-Agent"),
+		LastSeen:    now,
+		ExpireAt:    now.Add(sessionMaxAge()),
+	}
+	if err = manager.db.Create(&session).Error; err != nil {
+		return model.Session{}, "", err
+	}
+	return session, refresh, nil
+}
+
+// 检查会话是否有效且与当前客户端相符
+func (manager *Manager) checkSession(ctx iris.Context, session model.Session) bool {
+	if !session.IsActive(time.Now(), viper.GetDuration("user.idleTimeout")) {
+		return false
+	}
+	if viper.GetBool("user.bindIP") && session.IP != ctx.RemoteAddr() {
+		log.Warnf("session %v of user %v is used from another ip %v", session.ID, session.UserID, ctx.RemoteAddr())
+		return false
+	}
+	if viper.GetBool("user.bindUserAgent") && session.UserAgent != ctx.GetHeader("User-Agent") {
+		log.Warnf("session %v of user %v is used from another user agent", session.ID, session.UserID)
+		return false
+	}
+	return true
+}
+
+// PreCheckOfSession 检查访问token所属会话是否有效，并记录活跃时间
+func (manager *Manager) PreCheckOfSession(ctx iris.Context) {
+	claims := manager.getUserClaims(ctx)
+	if claims.TokenID != 0 { // API令牌无会话
+		ctx.Next()
+		return
+	}
+	var session model.Session
+	result := manager.db.Where("id = ? AND user_id = ?", claims.SessionID, claims.ID).Limit(1).Find(&session)
+	if result.Error != nil || result.RowsAffected == 0 || !manager.checkSession(ctx, session) {
+		responseError(ctx, errors.InvalidToken)
+		return
+	}
+	// 记录活跃时间，至多每分钟一次
+	if now := time.Now(); now.Sub(session.LastSeen) > time.Minute {
+		_ = manager.db.Model(&session).UpdateColumn("last_seen", now).Error
+	}
+	ctx.Next()
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// GetRefreshHandler 获取刷新token处理函数：使用刷新token换取新的访问token，刷新token随之轮换
+func (manager *Manager) GetRefreshHandler() iris.Handler {
+	signer := newLoginSigner()
+	return func(ctx iris.Context) {
+		// 绑定请求
+		var req RefreshRequest
+		if err := ctx.ReadJSON(&req); err != nil || len(req.RefreshToken) == 0 {
+			responseError(ctx, errors.InvalidRequest)
+			return
+		}
+		// 查找会话
+		hash := hashToken(req.RefreshToken)
+		var session model.Session
+		result := manager.db.Where("refresh_hash = ?", hash).Limit(1).Find(&session)
+		if result.Error != nil {
+			responseError(ctx, result.Error)
+			return
+		}
+		if result.RowsAffected == 0 {
+			// 已轮换的刷新token被再次使用，说明其可能已泄露，吊销对应会话
+			if manager.db.Model(&model.Session{}).Where("prev_hash = ?", hash).
+				Update("is_revoked", true).RowsAffected > 0 {
+				log.Warnf("a rotated refresh token is reused from %v, its session is revoked", ctx.RemoteAddr())
+			}
+			responseError(ctx, errors.InvalidToken)
+			return
+		}
+		if !manager.checkSession(ctx, session) {
+			responseError(ctx, errors.InvalidToken)
+			return
+		}
+		// 检查用户
+		user, err := manager.userManager.Get(session.UserID)
+		if err != nil {
+			responseError(ctx, errors.InvalidToken)
+			return
+		}
+		if manager.isUserFrozen(*user) {
+			responseError(ctx, errors.UserFrozen)
+			return
+		}
+		// 轮换刷新token
+		refresh, err := generateRefreshToken()
+		if err != nil {
+			responseError(ctx, err)
+			return
+		}
+		result = manager.db.Model(&model.Session{}).Where("id = ? AND refresh_hash = ?", session.ID, hash).
+			UpdateColumns(map[string]interface{}{
+				"refresh_hash": hashToken(refresh),
+				"prev_hash":    hash,
+				"last_seen":    time.Now(),
+			})
+		if result.Error != nil {
+			responseError(ctx, result.Error)
+			return
+		}
+		if result.RowsAffected == 0 { // 并发刷新
+			responseError(ctx, errors.InvalidToken)
+			return
+		}
+		// 生成访问token
+		data, ok := manager.signAccessToken(ctx, signer, *user, session, refresh)
+		if !ok {
+			return
+		}
+		responseSuccess(ctx, "data", data)
+	}
+}
+
+// 为会话签发访问token，失败时已回包
+func (manager *Manager) signAccessToken(ctx iris.Context, signer *jwt.Signer, user model.User,
+	session model.Session, refresh string) (iris.Map, bool) {
+	claims := UserClaims{
+		ID:        user.ID,
+		Name:      user.Name,
+		Level:     user.Level,
+		SessionID: session.ID,
+	}
+	token, err := signer.Sign(claims, jwt.MaxAge(accessMaxAge()))
+	if err != nil {
+		log.Errorf("sign token error: %v", err)
+		responseError(ctx, errors.Unknown)
+		return nil, false
+	}
+	return iris.Map{
+		"token":        string(token),
+		"refreshToken": refresh,
+		"expireAt":     session.ExpireAt,
+		"username":     user.Name,
+		"level":        user.Level,
+	}, true
+}
+
+// HandlerOfGetSessions 获取当前用户的有效会话
+func (manager *Manager) HandlerOfGetSessions(ctx iris.Context) {
+	self := manager.getUserClaims(ctx)
+	var all []model.Session
+	if err := manager.db.Where("user_id = ? AND is_revoked = ? AND expire_at > ?", self.ID, false, time.Now()).
+		Order("created_at desc").Find(&all).Error; err != nil {
+		responseError(ctx, err)
+		return
+	}
+	now, idle := time.Now(), viper.GetDuration("user.idleTimeout")
+	sessions := make([]iris.Map, 0, len(all))
+	for _, session := range all {
+		if !session.IsActive(now, idle) {
+			continue
+		}
+		sessions = append(sessions, iris.Map{
+			"session": session,
+			"current": session.ID == self.SessionID,
+		})
+	}
+	responseSuccess(ctx, "sessions", sessions)
+}
+
+type RevokeSessionRequest struct {
+	ID     string `url:"id"`     // 吊销指定会话
+	Others bool   `url:"others"` // 吊销当前会话以外的所有会话
+}
+
+// HandlerOfRevokeSession 吊销当前用户的会话
+func (manager *Manager) HandlerOfRevokeSession(ctx iris.Context) {
+	// 校验参数
+	var request RevokeSessionRequest
+	if err := ctx.ReadQuery(&request); err != nil || (len(request.ID) == 0 && !request.Others) {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	self := manager.getUserClaims(ctx)
+	// 吊销
+	if request.Others {
+		if err := manager.revokeUserSessions(self.ID, self.SessionID); err != nil {
+			responseError(ctx, err)
+			return
+		}
+	} else {
+		result := manager.db.Model(&model.Session{}).Where("id = ? AND user_id = ?", request.ID, self.ID).
+			Update("is_revoked", true)
+		if result.Error != nil {
+			responseError(ctx, result.Error)
+			return
+		}
+		if result.RowsAffected == 0 {
+			responseError(ctx, errors.NoSuchSession)
+			return
+		}
+	}
+	responseSuccess(ctx, "", nil)
+}
+
+// 吊销用户的所有会话，except为保留的会话ID
+func (manager *Manager) revokeUserSessions(userID uint, except string) error {
+	return manager.db.Model(&model.Session{}).
+		Where("user_id = ? AND id <> ? AND is_revoked = ?", userID, except, false).
+		Update("is_revoked", true).Error
+}
+
+func generateRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return refreshTokenPrefix + hex.EncodeToString(b), nil
+}
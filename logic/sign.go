@@ -0,0 +1,57 @@
+package logic
+
+import (
+	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/kataras/iris/v12"
+)
+
+// Sign 使用实例密钥签名，密钥内容不离开keeper
+func (manager *Manager) Sign(ctx iris.Context) {
+	k, span := traceKeeper(ctx, manager.getKeeper(ctx), ctx.GetHeader("identifier"), "Sign")
+	defer span.End()
+	signer, ok := k.(keeper.Signer)
+	if !ok {
+		responseError(ctx, errors.KeeperNotSupport)
+		return
+	}
+	// 解析参数
+	var req keeper.SignRequest
+	if err := ctx.ReadJSON(&req); err != nil || req.ID == 0 {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	// 签名
+	signature, err := signer.Sign(req)
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	responseSuccess(ctx, "data", signature)
+}
+
+// Verify 使用实例密钥验签
+func (manager *Manager) Verify(ctx iris.Context) {
+	k, span := traceKeeper(ctx, manager.getKeeper(ctx), ctx.GetHeader("identifier"), "Verify")
+	defer span.End()
+	signer, ok := k.(keeper.Signer)
+	if !ok {
+		responseError(ctx, errors.KeeperNotSupport)
+		return
+	}
+	// 解析参数
+	var req keeper.VerifyRequest
+	if err := ctx.ReadJSON(&req); err != nil || req.ID == 0 {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	// 验签
+	valid, err := signer.Verify(req)
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	responseSuccess(ctx, "data", iris.Map{
+		"valid": valid,
+	})
+}
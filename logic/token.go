@@ -0,0 +1,231 @@
+package logic
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/model"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	apiTokenPrefix       = "kk_"
+	apiTokenPrefixLength = 10 // 保存用于辨认的明文长度
+	ctxAPITokenClaimsKey = "kk.token.claims"
+)
+
+// API令牌不可调用的API：令牌管理、账户安全、需由本人进行的审批及全局操作，按注册路由的路径模板匹配
+var apiTokenForbiddenPaths = []string{
+	"/api/token",
+	"/api/session",
+	"/api/user/password",
+	"/api/user/2fa",
+	"/api/operation/approve",
+	"/api/operation/reject",
+	"/api/seal",
+	"/api/config/reload",
+}
+
+// 校验API令牌并设置用户信息，失败时已回包
+func (manager *Manager) verifyAPIToken(ctx iris.Context, raw string) {
+	now := time.Now()
+	var token model.APIToken
+	result := manager.db.Where("hash = ?", hashToken(raw)).Limit(1).Find(&token)
+	if result.Error != nil || result.RowsAffected == 0 || token.IsRevoked || token.IsExpired(now) {
+		responseError(ctx, errors.InvalidToken)
+		return
+	}
+	// 检查所属用户
+	user, err := manager.userManager.Get(token.UserID)
+	if err != nil {
+		responseError(ctx, errors.InvalidToken)
+		return
+	}
+	if manager.isUserFrozen(*user) {
+		responseError(ctx, errors.UserFrozen)
+		return
+	}
+	// 检查可调用的API：使用匹配到的路由而非原始请求路径，避免多余斜杠、大小写等变体绕过
+	route := ctx.GetCurrentRoute()
+	if route == nil || !apiTokenAllowRoute(token, route.Method(), route.Path()) {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	// 记录使用情况，至多每分钟一次
+	if now.Sub(token.LastUsed) > time.Minute || token.LastIP != ctx.RemoteAddr() {
+		_ = manager.db.Model(&token).UpdateColumns(model.APIToken{LastUsed: now, LastIP: ctx.RemoteAddr()}).Error
+	}
+	level := token.Level
+	if user.Level < level {
+		level = user.Level
+	}
+	ctx.Values().Set(ctxAPITokenClaimsKey, &UserClaims{
+		ID:        user.ID,
+		Name:      user.Name,
+		Level:     level,
+		TokenID:   token.ID,
+		Instances: token.GetInstances(),
+	})
+	ctx.Next()
+}
+
+// 令牌是否可调用该路由，path为路由注册时的路径模板
+func apiTokenAllowRoute(token model.APIToken, method, path string) bool {
+	path = strings.TrimSuffix(path, "/")
+	for _, forbidden := range apiTokenForbiddenPaths {
+		if path == forbidden || strings.HasPrefix(path, forbidden+"/") {
+			return false
+		}
+	}
+	return token.AllowRequest(method, path)
+}
+
+type GetTokensRequest struct {
+	UserID uint `url:"userID"` // 为空代表查询自己的令牌；否则代表查询指定用户的令牌
+}
+
+// HandlerOfGetTokens 获取API令牌列表
+func (manager *Manager) HandlerOfGetTokens(ctx iris.Context) {
+	// 校验参数
+	var request GetTokensRequest
+	if err := ctx.ReadQuery(&request); err != nil {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	// 权限检查
+	self := manager.getUserClaims(ctx)
+	if request.UserID != 0 && request.UserID != self.ID && self.Level < model.UserLevelRoot {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	if request.UserID == 0 {
+		request.UserID = self.ID
+	}
+	// 查询
+	tokens := make([]model.APIToken, 0)
+	if err := manager.db.Where("user_id = ?", request.UserID).Order("id").Find(&tokens).Error; err != nil {
+		responseError(ctx, err)
+		return
+	}
+	responseSuccess(ctx, "tokens", tokens)
+}
+
+type AddTokenRequest struct {
+	Name      string    `json:"name"`
+	Level     int       `json:"level"`
+	Scopes    []string  `json:"scopes"`    // 形如"GET /api/keys"，为空代表不限
+	Instances []string  `json:"instances"` // 为空代表不限
+	ExpireAt  time.Time `json:"expireAt"`  // 零值代表永不过期
+}
+
+// HandlerOfAddToken 为当前用户创建API令牌，令牌明文仅在此返回一次
+func (manager *Manager) HandlerOfAddToken(ctx iris.Context) {
+	// 校验参数
+	var request AddTokenRequest
+	if err := ctx.ReadJSON(&request); err != nil {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	self := manager.getUserClaims(ctx)
+	if len(request.Name) == 0 || (!request.ExpireAt.IsZero() && request.ExpireAt.Before(time.Now())) ||
+		request.Level < model.UserLevelGeneral || request.Level > self.Level {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	for _, scope := range request.Scopes {
+		if len(strings.Fields(scope)) != 2 || strings.Contains(scope, model.APITokenDelimiter) {
+			responseError(ctx, errors.InvalidRequest)
+			return
+		}
+	}
+	for _, identifier := range request.Instances {
+		if !instanceIdentifierRegexp.MatchString(identifier) {
+			responseError(ctx, errors.InvalidRequest)
+			return
+		}
+	}
+	// 生成令牌
+	raw, err := generateAPIToken()
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	token := model.APIToken{
+		UserID:    self.ID,
+		Name:      request.Name,
+		Prefix:    raw[:apiTokenPrefixLength],
+		Hash:      hashToken(raw),
+		Level:     request.Level,
+		Scopes:    strings.Join(request.Scopes, model.APITokenDelimiter),
+		Instances: strings.Join(request.Instances, model.APITokenDelimiter),
+		ExpireAt:  request.ExpireAt,
+	}
+	if err = manager.db.Create(&token).Error; err != nil {
+		responseError(ctx, err)
+		return
+	}
+	log.Infof("api token %v(%v) is created by user %v(%v)", token.Name, token.ID, self.Name, self.ID)
+	responseSuccess(ctx, "data", iris.Map{
+		"token": raw,
+		"info":  token,
+	})
+}
+
+// HandlerOfRevokeToken 吊销API令牌
+func (manager *Manager) HandlerOfRevokeToken(ctx iris.Context) {
+	// 校验参数
+	id := uint(ctx.URLParamUint64("id"))
+	if id == 0 {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	var token model.APIToken
+	result := manager.db.Where("id = ?", id).Limit(1).Find(&token)
+	if result.Error != nil {
+		responseError(ctx, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		responseError(ctx, errors.NoSuchToken)
+		return
+	}
+	// 权限检查
+	self := manager.getUserClaims(ctx)
+	if token.UserID != self.ID && self.Level < model.UserLevelRoot {
+		responseError(ctx, errors.PermissionDeny)
+		return
+	}
+	// 吊销
+	if err := manager.db.Model(&token).Update("is_revoked", true).Error; err != nil {
+		responseError(ctx, err)
+		return
+	}
+	log.Infof("api token %v(%v) is revoked by user %v(%v)", token.Name, token.ID, self.Name, self.ID)
+	responseSuccess(ctx, "", nil)
+}
+
+// 生成形如 kk_<64位十六进制> 的令牌明文
+func generateAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + hex.EncodeToString(b), nil
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// 是否为API令牌请求
+func isAPITokenRequest(ctx iris.Context) (string, bool) {
+	token := jwt.FromHeader(ctx)
+	return token, strings.HasPrefix(token, apiTokenPrefix)
+}
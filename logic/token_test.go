@@ -0,0 +1,42 @@
+package logic
+
+import (
+	"testing"
+
+	"github.com/RicheyJang/key_keeper/model"
+)
+
+func TestAPITokenAllowRoute(t *testing.T) {
+	unlimited := model.APIToken{}
+	scoped := model.APIToken{Scopes: "GET /api/instance,* /api/keys"}
+	tests := []struct {
+		name   string
+		token  model.APIToken
+		method string
+		path   string
+		allow  bool
+	}{
+		{"unlimited", unlimited, "GET", "/api/instance", true},
+		{"token management", unlimited, "GET", "/api/token", false},
+		{"token management trailing slash", unlimited, "PUT", "/api/token/", false},
+		{"password", unlimited, "POST", "/api/user/password", false},
+		{"2fa", unlimited, "POST", "/api/user/2fa/disable", false},
+		{"approve", unlimited, "POST", "/api/operation/approve", false},
+		{"reject", unlimited, "POST", "/api/operation/reject", false},
+		{"seal", unlimited, "POST", "/api/seal", false},
+		{"config reload", unlimited, "POST", "/api/config/reload", false},
+		{"similar prefix", unlimited, "GET", "/api/tokens", true},
+		{"scope matched", scoped, "GET", "/api/instance", true},
+		{"scope method mismatch", scoped, "PUT", "/api/instance", false},
+		{"scope wildcard method", scoped, "DELETE", "/api/keys", true},
+		{"scope sub path", scoped, "GET", "/api/instance/cert", true},
+		{"out of scope", scoped, "GET", "/api/user", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := apiTokenAllowRoute(test.token, test.method, test.path); got != test.allow {
+				t.Fatalf("apiTokenAllowRoute(%v %v) = %v, want %v", test.method, test.path, got, test.allow)
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package logic
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/model"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/RicheyJang/key_keeper/utils/totp"
+	"github.com/kataras/iris/v12"
+	orgjwt "github.com/kataras/jwt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	totpIssuer         = "KeyKeeper"
+	totpSkew           = 1 // 允许前后各一个周期的时钟偏差
+	challengeMaxAge    = 5 * time.Minute
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+// 双因素认证挑战token内信息
+type challengeClaims struct {
+	UserID uint `json:"uid"`
+	Enroll bool `json:"enroll"` // 是否为登录时强制注册
+}
+
+// 该权限等级的用户是否必须启用双因素认证
+func require2FA(level int) bool {
+	required := viper.GetInt("user.require2FALevel")
+	return required >= 0 && level >= required
+}
+
+// 回包双因素认证挑战，若策略要求但用户尚未注册，则同时下发新的TOTP密钥
+func (manager *Manager) responseChallenge(ctx iris.Context, user model.User) {
+	claims := challengeClaims{UserID: user.ID, Enroll: !user.TOTPEnabled}
+	data := iris.Map{"enroll": claims.Enroll}
+	if claims.Enroll {
+		secret := user.TOTPSecret // 沿用尚未验证的密钥，避免每次登录均使已扫码的密钥失效
+		if len(secret) == 0 {
+			var err error
+			if secret, err = totp.GenerateSecret(); err != nil {
+				responseError(ctx, err)
+				return
+			}
+			if err = manager.userManager.SetTOTP(user.ID, secret, false); err != nil {
+				responseError(ctx, err)
+				return
+			}
+		}
+		data["secret"] = secret
+		data["uri"] = totp.ProvisioningURI(totpIssuer, user.Name, secret)
+	}
+	token, err := orgjwt.Sign(orgjwt.HS256, getChallengeSecretKey(), claims, orgjwt.MaxAge(challengeMaxAge))
+	if err != nil {
+		log.Errorf("sign challenge error: %v", err)
+		responseError(ctx, errors.Unknown)
+		return
+	}
+	data["challenge"] = string(token)
+	responseSuccess(ctx, "data", data)
+}
+
+type TwoFactorLoginRequest struct {
+	Challenge    string `json:"challenge"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// GetTwoFactorLoginHandler 获取双因素认证登录处理函数
+func (manager *Manager) GetTwoFactorLoginHandler() iris.Handler {
+	signer := newLoginSigner()
+	return func(ctx iris.Context) {
+		// 绑定请求
+		var req TwoFactorLoginRequest
+		if err := ctx.ReadJSON(&req); err != nil || len(req.Challenge) == 0 {
+			responseError(ctx, errors.InvalidRequest)
+			return
+		}
+		// 校验挑战
+		verified, err := orgjwt.Verify(orgjwt.HS256, getChallengeSecretKey(), []byte(req.Challenge))
+		if err != nil {
+			responseError(ctx, errors.InvalidChallenge)
+			return
+		}
+		var claims challengeClaims
+		if err = verified.Claims(&claims); err != nil || claims.UserID == 0 {
+			responseError(ctx, errors.InvalidChallenge)
+			return
+		}
+		user, err := manager.userManager.Get(claims.UserID)
+		if err != nil || len(user.TOTPSecret) == 0 || claims.Enroll == user.TOTPEnabled {
+			responseError(ctx, errors.InvalidChallenge)
+			return
+		}
+		if manager.isUserFrozen(*user) {
+			responseError(ctx, errors.UserFrozen)
+			return
+		}
+		if manager.loginLocked(ctx.RemoteAddr(), user, time.Now()) { // 锁定期间不消耗验证码及恢复码，仅记录失败
+			if !totp.Validate(user.TOTPSecret, req.Code, time.Now(), totpSkew) {
+				manager.recordLoginFailure(ctx, user)
+			}
+			responseError(ctx, errors.UserLocked)
+			return
+		}
+		// 校验验证码或恢复码，恢复码仅在已启用后可用
+		passed, err := manager.validateTOTP(user, req.Code)
+		if err != nil {
+			responseError(ctx, err)
+			return
+		}
+		if !passed && user.TOTPEnabled && len(req.RecoveryCode) > 0 {
+			if passed, err = manager.userManager.UseRecoveryCode(user.ID, req.RecoveryCode); err != nil {
+				responseError(ctx, err)
+				return
+			}
+			if passed {
+				log.Warnf("user %v(%v) login with a recovery code", user.Name, user.ID)
+			}
+		}
+		if !passed {
+			manager.recordLoginFailure(ctx, user)
+			responseError(ctx, errors.InvalidTOTPCode)
+			return
+		}
+		// 登录时强制注册：启用并下发恢复码
+		var codes []string
+		if !user.TOTPEnabled {
+			if codes, err = manager.enableTOTP(user.ID, user.TOTPSecret); err != nil {
+				responseError(ctx, err)
+				return
+			}
+		}
+		// 生成token
+		data, ok := manager.signIn(ctx, signer, *user)
+		if !ok {
+			return
+		}
+		if codes != nil {
+			data["recoveryCodes"] = codes
+		}
+		responseSuccess(ctx, "data", data)
+	}
+}
+
+// HandlerOfEnrollTOTP 为当前用户生成待验证的TOTP密钥
+func (manager *Manager) HandlerOfEnrollTOTP(ctx iris.Context) {
+	self := manager.getUserClaims(ctx)
+	user, err := manager.userManager.Get(self.ID)
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	if user.TOTPEnabled {
+		responseError(ctx, errors.TwoFactorEnrolled)
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	if err = manager.userManager.SetTOTP(user.ID, secret, false); err != nil {
+		responseError(ctx, err)
+		return
+	}
+	responseSuccess(ctx, "data", iris.Map{
+		"secret": secret,
+		"uri":    totp.ProvisioningURI(totpIssuer, user.Name, secret),
+	})
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// HandlerOfVerifyTOTP 验证当前用户的待验证TOTP密钥并启用双因素认证
+func (manager *Manager) HandlerOfVerifyTOTP(ctx iris.Context) {
+	// 校验参数
+	var request TOTPCodeRequest
+	if err := ctx.ReadJSON(&request); err != nil {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	self := manager.getUserClaims(ctx)
+	user, err := manager.userManager.Get(self.ID)
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	if user.TOTPEnabled {
+		responseError(ctx, errors.TwoFactorEnrolled)
+		return
+	}
+	if len(user.TOTPSecret) == 0 {
+		responseError(ctx, errors.TwoFactorNotEnroll)
+		return
+	}
+	passed, err := manager.validateTOTP(user, request.Code)
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	if !passed {
+		responseError(ctx, errors.InvalidTOTPCode)
+		return
+	}
+	// 启用
+	codes, err := manager.enableTOTP(user.ID, user.TOTPSecret)
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	responseSuccess(ctx, "data", iris.Map{"recoveryCodes": codes})
+}
+
+// HandlerOfRegenerateRecoveryCodes 使用验证码重新生成当前用户的恢复码
+func (manager *Manager) HandlerOfRegenerateRecoveryCodes(ctx iris.Context) {
+	// 校验参数
+	var request TOTPCodeRequest
+	if err := ctx.ReadJSON(&request); err != nil {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	self := manager.getUserClaims(ctx)
+	user, err := manager.userManager.Get(self.ID)
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	if !user.TOTPEnabled {
+		responseError(ctx, errors.TwoFactorNotEnroll)
+		return
+	}
+	passed, err := manager.validateTOTP(user, request.Code)
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	if !passed {
+		responseError(ctx, errors.InvalidTOTPCode)
+		return
+	}
+	// 重新生成
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		responseError(ctx, err)
+		return
+	}
+	if err = manager.userManager.SetRecoveryCodes(user.ID, codes); err != nil {
+		responseError(ctx, err)
+		return
+	}
+	responseSuccess(ctx, "data", iris.Map{"recoveryCodes": codes})
+}
+
+type DisableTOTPRequest struct {
+	UserID   uint   `json:"id"`       // 为空代表关闭自己的双因素认证；否则代表重置指定用户的双因素认证
+	Password string `json:"password"` // 重置指定用户时，此项可以为空
+}
+
+// HandlerOfDisableTOTP 关闭或重置双因素认证处理函数
+func (manager *Manager) HandlerOfDisableTOTP(ctx iris.Context) {
+	// 校验参数
+	var request DisableTOTPRequest
+	if err := ctx.ReadJSON(&request); err != nil {
+		responseError(ctx, errors.InvalidRequest)
+		return
+	}
+	// 权限检查
+	self := manager.getUserClaims(ctx)
+	if request.UserID != 0 && request.UserID != self.ID { // 重置其他用户，该用户下次登录时按策略重新注册
+		if self.Level < model.UserLevelRoot {
+			responseError(ctx, errors.PermissionDeny)
+			return
+		}
+	} else { // 关闭自己的双因素认证
+		user, ok := manager.checkPassword(ctx, self.Name, request.Password)
+		if !ok {
+			return
+		}
+		if require2FA(user.Level) {
+			responseError(ctx, errors.TwoFactorRequired)
+			return
+		}
+		request.UserID = self.ID
+	}
+	// 关闭
+	if err := manager.userManager.SetTOTP(request.UserID, "", false); err != nil {
+		responseError(ctx, err)
+		return
+	}
+	log.Infof("two-factor authentication of user %v is disabled by user %v(%v)", request.UserID, self.Name, self.ID)
+	responseSuccess(ctx, "", nil)
+}
+
+// 校验TOTP验证码，同一时间步的验证码仅可使用一次
+func (manager *Manager) validateTOTP(user *model.User, code string) (bool, error) {
+	step, ok := totp.ValidateStep(user.TOTPSecret, code, time.Now(), totpSkew)
+	if !ok {
+		return false, nil
+	}
+	return manager.userManager.UseTOTPStep(user.ID, step)
+}
+
+// 启用TOTP并生成新的恢复码
+func (manager *Manager) enableTOTP(id uint, secret string) ([]string, error) {
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err = manager.userManager.SetTOTP(id, secret, true); err != nil {
+		return nil, err
+	}
+	if err = manager.userManager.SetRecoveryCodes(id, codes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// 生成一组形如 xxxxx-xxxxx 的恢复码
+func generateRecoveryCodes() ([]string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, recoveryCodeLength)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(b))[:recoveryCodeLength]
+		codes = append(codes, code[:recoveryCodeLength/2]+"-"+code[recoveryCodeLength/2:])
+	}
+	return codes, nil
+}
+
+// 挑战token的签名密钥，与登录token的密钥相互独立，避免挑战token被当作登录token使用
+func getChallengeSecretKey() []byte {
+	return deriveSecretKey("login-challenge")
+}
@@ -0,0 +1,120 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/keeper/safer"
+	"github.com/RicheyJang/key_keeper/utils/logger"
+	"github.com/RicheyJang/key_keeper/utils/tracing"
+	"github.com/glebarez/sqlite"
+	"github.com/kataras/iris/v12"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// 一次内部API请求应产生 请求 -> keeper -> gorm 嵌套的span，且日志带有链路追踪ID
+func TestTracingSpanNesting(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown := tracing.SetupWithExporter(exporter, tracing.Option{ServiceName: "kk-test", SampleRatio: 1})
+	defer func() {
+		_ = shutdown(context.Background())
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	}()
+	var output bytes.Buffer
+	formatter, level, out := log.StandardLogger().Formatter, log.GetLevel(), log.StandardLogger().Out
+	log.SetFormatter(&logger.SimpleFormatter{})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(&output)
+	defer func() {
+		log.SetFormatter(formatter)
+		log.SetLevel(level)
+		log.SetOutput(out)
+	}()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "kk.db")), &gorm.Config{Logger: logger.NewGormLogger()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	kp, err := safer.GetSafer(keeper.Option{Identifier: DefaultInstanceIdentifier, DB: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = kp.DistributeKey(keeper.DistributeKeyRequest{ID: 1, Length: 32, Algorithm: keeper.AlgorithmAESGCM}); err != nil {
+		t.Fatal(err)
+	}
+	manager := &Manager{db: db}
+	manager.defaultIns.kp = kp
+	exporter.Reset()
+	output.Reset()
+
+	app := iris.New()
+	app.Use(tracing.Iris("inner"))
+	app.Post("/api/inner/version", manager.GetLatestVersionKey)
+	if err = app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/inner/version", strings.NewReader(`{"id":1}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("identifier", DefaultInstanceIdentifier)
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %v: %v", rec.Code, rec.Body.String())
+	}
+
+	var request, keeperSpan *tracetest.SpanStub
+	var queries []tracetest.SpanStub
+	spans := exporter.GetSpans()
+	for i := range spans {
+		switch span := &spans[i]; {
+		case span.Name == "POST /api/inner/version":
+			request = span
+		case span.Name == "keeper.GetLatestVersionKey":
+			keeperSpan = span
+		case strings.HasPrefix(span.Name, "gorm."):
+			queries = append(queries, *span)
+		}
+	}
+	if request == nil || keeperSpan == nil || len(queries) == 0 {
+		t.Fatalf("missing spans, got %v", spanNames(spans))
+	}
+	if request.Parent.IsValid() {
+		t.Fatal("request span has a parent")
+	}
+	if keeperSpan.Parent.SpanID() != request.SpanContext.SpanID() {
+		t.Fatal("keeper span is not a child of the request span")
+	}
+	traceID := request.SpanContext.TraceID()
+	for _, query := range queries {
+		if query.Parent.SpanID() != keeperSpan.SpanContext.SpanID() || query.SpanContext.TraceID() != traceID {
+			t.Fatalf("query span %v is not a child of the keeper span", query.Name)
+		}
+	}
+	// gorm日志带有该请求的链路追踪ID
+	if !strings.Contains(output.String(), "[debug]["+traceID.String()+"]: ") {
+		t.Fatalf("trace id %v is missing in log output:\n%v", traceID, output.String())
+	}
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	return names
+}
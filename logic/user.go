@@ -118,6 +118,11 @@ func (manager *Manager) HandlerOfSetUserLevel(ctx iris.Context) {
 		responseError(ctx, errors.InvalidRequest)
 		return
 	}
+	// 提升至root需双人审批
+	if request.Level == model.UserLevelRoot &&
+		manager.requestApproval(ctx, model.OperationSetRootLevel, "", request) {
+		return
+	}
 	// 请求
 	err = manager.userManager.SetLevel(request.UserID, request.Level)
 	if err != nil {
@@ -237,8 +242,7 @@ func (manager *Manager) HandlerOfChangePasswd(ctx iris.Context) {
 			return
 		}
 	} else { // 自己修改自己的密码
-		if _, err = manager.userManager.CheckUser(self.Name, request.OldPassword); err != nil {
-			responseError(ctx, errors.WrongPasswd)
+		if _, ok := manager.checkPassword(ctx, self.Name, request.OldPassword); !ok {
 			return
 		}
 		request.UserID = self.ID
@@ -248,5 +252,13 @@ func (manager *Manager) HandlerOfChangePasswd(ctx iris.Context) {
 		responseError(ctx, err)
 		return
 	}
+	// 吊销该用户的其他会话
+	except := ""
+	if request.UserID == self.ID {
+		except = self.SessionID
+	}
+	if err = manager.revokeUserSessions(request.UserID, except); err != nil {
+		log.Warnf("revoke sessions of user %v error: %v", request.UserID, err)
+	}
 	responseSuccess(ctx, "", nil)
 }
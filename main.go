@@ -1,11 +1,20 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/RicheyJang/key_keeper/keeper"
 	"github.com/RicheyJang/key_keeper/keeper/safer"
 
 	"github.com/RicheyJang/key_keeper/model"
@@ -13,11 +22,16 @@ import (
 	"github.com/RicheyJang/key_keeper/keeper/example"
 	"github.com/RicheyJang/key_keeper/logic"
 	"github.com/RicheyJang/key_keeper/utils"
+	"github.com/RicheyJang/key_keeper/utils/ca"
 	"github.com/RicheyJang/key_keeper/utils/errors"
 	"github.com/RicheyJang/key_keeper/utils/logger"
+	"github.com/RicheyJang/key_keeper/utils/oidc"
+	"github.com/RicheyJang/key_keeper/utils/tlspolicy"
+	"github.com/RicheyJang/key_keeper/utils/tracing"
 
 	"github.com/fsnotify/fsnotify"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"gorm.io/driver/mysql"
@@ -30,6 +44,7 @@ func init() {
 	pflag.StringP("host", "h", ":7709", "key service running host")
 	pflag.StringP("web", "w", ":7710", "web service running host")
 	pflag.StringP("log", "l", "info", "the level of logging")
+	pflag.StringP("mode", "m", "server", "running mode: server or agent")
 	configPath := pflag.StringP("config", "c", "./config.toml", "configuration file path")
 	pflag.Parse()
 	// Host配置
@@ -37,6 +52,8 @@ func init() {
 	_ = viper.BindPFlag("host", pflag.Lookup("host"))
 	viper.SetDefault("web", ":7710")
 	_ = viper.BindPFlag("web", pflag.Lookup("web"))
+	viper.SetDefault("mode", "server")
+	_ = viper.BindPFlag("mode", pflag.Lookup("mode"))
 	// 日志配置
 	viper.SetDefault("log.level", "info")
 	_ = viper.BindPFlag("log.level", pflag.Lookup("log"))
@@ -53,8 +70,96 @@ func init() {
 	viper.SetDefault("cert.ca", "cert/ca.crt")
 	viper.SetDefault("cert.self", "cert/server.crt")
 	viper.SetDefault("cert.private", "cert/server_rsa_private.pem")
+	viper.SetDefault("cert.crl", "")                                // 客户端证书吊销列表路径，文件变更后自动重新加载
+	viper.SetDefault("cert.refresh", time.Duration(10*time.Second)) // 从数据库同步其它副本吊销、签发证书的间隔，也是已签发证书吊销状态的缓存时长，不大于0时使用默认值
+	// TLS策略配置，[tls.web]、[tls.inner]中的非空项可覆盖之
+	viper.SetDefault("tls.minVersion", "1.2")
+	viper.SetDefault("tls.maxVersion", "")
+	viper.SetDefault("tls.cipherSuites", []string{}) // 为空使用Go默认值
+	viper.SetDefault("tls.curves", []string{})       // 为空使用Go默认值
+	// 内置CA配置
+	viper.SetDefault("ca.enable", false)                                // 启用后首次运行时自动生成CA及服务端证书，并可签发实例客户端证书
+	viper.SetDefault("ca.private", "cert/ca_private.pem")               // CA私钥路径
+	viper.SetDefault("ca.hosts", []string{"localhost", "127.0.0.1"})    // 自动生成的服务端证书的域名及IP
+	viper.SetDefault("ca.validity", time.Duration(10*365*24*time.Hour)) // 自动生成的CA及服务端证书有效期
+	// Agent模式配置
+	viper.SetDefault("agent.listen", "unix:kk-agent.sock") // 以unix:开头时监听Unix Socket，否则监听TCP
+	viper.SetDefault("agent.secret", "")                   // 本地请求须在secret请求头中携带，监听TCP时必填
+	viper.SetDefault("agent.identifier", "default")
+	viper.SetDefault("agent.upstreams", []string{"https://localhost:7709"})
+	viper.SetDefault("agent.ca", "cert/ca.crt")
+	viper.SetDefault("agent.cert", "cert/client.crt")
+	viper.SetDefault("agent.private", "cert/client_rsa_private.pem")
+	viper.SetDefault("agent.grace", time.Duration(time.Hour))
+	viper.SetDefault("agent.sealFile", "")
+	// 密封模式配置
+	viper.SetDefault("seal.enable", false)
+	viper.SetDefault("seal.shares", 5)
+	viper.SetDefault("seal.threshold", 3)
+	// 双人审批配置
+	viper.SetDefault("approval.enable", false)
+	viper.SetDefault("approval.window", time.Duration(24*time.Hour))
+	// 登录防爆破配置
+	viper.SetDefault("lockout.threshold", 5)    // 同一用户连续失败该次数后锁定，0代表不锁定
+	viper.SetDefault("lockout.ipThreshold", 20) // 同一来源IP连续失败该次数后锁定，0代表不锁定
+	viper.SetDefault("lockout.duration", time.Duration(time.Minute))
+	viper.SetDefault("lockout.maxDuration", time.Duration(time.Hour))
+	// LDAP/AD认证配置
+	viper.SetDefault("auth.ldap.enable", false)
+	viper.SetDefault("auth.ldap.url", "ldap://localhost:389")
+	viper.SetDefault("auth.ldap.startTLS", false)
+	viper.SetDefault("auth.ldap.ca", "")
+	viper.SetDefault("auth.ldap.timeout", time.Duration(10*time.Second))
+	viper.SetDefault("auth.ldap.bindDN", "")
+	viper.SetDefault("auth.ldap.bindPassword", "")
+	viper.SetDefault("auth.ldap.baseDN", "dc=example,dc=com")
+	viper.SetDefault("auth.ldap.userFilter", "(uid=%s)")
+	viper.SetDefault("auth.ldap.nameAttribute", "uid")
+	viper.SetDefault("auth.ldap.groupAttribute", "memberOf")
+	viper.SetDefault("auth.ldap.groupBaseDN", "")
+	viper.SetDefault("auth.ldap.groupFilter", "(member=%s)")
+	viper.SetDefault("auth.ldap.groups", map[string]interface{}{}) // 组DN -> 权限等级
+	viper.SetDefault("auth.ldap.defaultLevel", 0)                  // 小于0代表不属于任何映射组的用户不可登录
+	viper.SetDefault("auth.ldap.allowRoot", false)                 // 是否允许组映射为root等级，否则最高为admin
+	// OIDC单点登录配置
+	viper.SetDefault("auth.oidc.enable", false)
+	viper.SetDefault("auth.oidc.issuer", "https://idp.example.com")
+	viper.SetDefault("auth.oidc.clientID", "key-keeper")
+	viper.SetDefault("auth.oidc.clientSecret", "")
+	viper.SetDefault("auth.oidc.redirectURL", "https://localhost:7710/oidc/callback") // 前端页面，负责将code及state提交至回调API
+	viper.SetDefault("auth.oidc.scopes", []string{"openid", "profile", "email"})
+	viper.SetDefault("auth.oidc.usernameClaim", "preferred_username")
+	viper.SetDefault("auth.oidc.groupsClaim", "groups")
+	viper.SetDefault("auth.oidc.groups", map[string]interface{}{}) // claim值 -> 权限等级
+	viper.SetDefault("auth.oidc.defaultLevel", 0)                  // 小于0代表未匹配任何组的用户不可登录
+	// 内部API限流配置，实例可单独设置
+	viper.SetDefault("rateLimit.instance", 0)      // 每个实例每秒请求数上限，0代表不限
+	viper.SetDefault("rateLimit.instanceBurst", 0) // 每个实例突发请求数上限，0代表取每秒上限
+	viper.SetDefault("rateLimit.cert", 0)          // 每个客户端证书每秒请求数上限，0代表不限
+	viper.SetDefault("rateLimit.certBurst", 0)     // 每个客户端证书突发请求数上限，0代表取每秒上限
+	// 密钥元数据缓存配置
+	viper.SetDefault("cache.size", 1024)                          // 每个实例缓存的密钥数量上限，0代表不缓存
+	viper.SetDefault("cache.refresh", time.Duration(time.Second)) // 检查其它副本修改密钥的间隔，0代表不检查
+	// 监控指标配置
+	viper.SetDefault("metrics.listen", "127.0.0.1:7712") // Prometheus指标导出地址（HTTP），为空代表不导出
+	// 链路追踪配置
+	viper.SetDefault("trace.enable", false)
+	viper.SetDefault("trace.endpoint", "localhost:4318") // OTLP/HTTP接收端地址
+	viper.SetDefault("trace.urlPath", "")                // 为空使用/v1/traces
+	viper.SetDefault("trace.insecure", true)             // 不使用TLS连接接收端
+	viper.SetDefault("trace.headers", map[string]interface{}{})
+	viper.SetDefault("trace.serviceName", "key-keeper")
+	viper.SetDefault("trace.sampleRatio", 1.0) // 新链路的采样比例，遵循上游的采样决定
+	// 健康检查配置
+	viper.SetDefault("health.listen", "127.0.0.1:7713")                  // 存活及就绪检查地址（HTTP），为空代表不提供
+	viper.SetDefault("health.certExpiry", time.Duration(7*24*time.Hour)) // 服务端证书距过期少于该时长时视为未就绪
 	// 其它设置
-	viper.SetDefault("user.maxAge", time.Duration(10*time.Hour))
+	viper.SetDefault("user.maxAge", time.Duration(10*time.Hour))         // 会话绝对有效时长
+	viper.SetDefault("user.accessMaxAge", time.Duration(15*time.Minute)) // 访问token有效时长，过期后使用刷新token换取
+	viper.SetDefault("user.idleTimeout", time.Duration(30*time.Minute))  // 会话闲置超时时长，0代表不限
+	viper.SetDefault("user.bindIP", false)                               // 会话绑定登录IP
+	viper.SetDefault("user.bindUserAgent", false)                        // 会话绑定登录User-Agent
+	viper.SetDefault("user.require2FALevel", -1)                         // 该等级及以上的用户必须启用双因素认证，小于0代表不强制
 	configDir, configFile := filepath.Split(*configPath)
 	if err := flushConfig(configDir, configFile); err != nil {
 		log.Fatal("setup config error: ", err)
@@ -66,26 +171,86 @@ func main() {
 	if err := logger.SetupLogger(); err != nil {
 		log.Fatal(err)
 	}
+	// Agent模式：无需数据库
+	if strings.ToLower(viper.GetString("mode")) == "agent" {
+		AgentServer(viper.Sub("agent"))
+		return
+	}
+	// 初始化链路追踪
+	if err := setupTracing(viper.Sub("trace")); err != nil {
+		log.Fatal(err)
+	}
 	// 初始化数据库
 	db, err := setupDatabase(viper.Sub("db"))
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// 初始化用户管理器
+	userManager := model.NewUserManger(db)
+	if err = setupAuthenticators(userManager); err != nil {
+		log.Fatal(err)
+	}
+
+	// 初始化内置CA
+	authority, err := setupAuthority(viper.Sub("ca"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// 读取服务端证书
+	if err = serverCerts.setup(); err != nil {
+		log.Fatal("Failed to read certificates: ", err)
+	}
+
 	// 初始化Manager
 	manager, err := logic.NewManager(logic.Option{
 		DB:          db,
-		UserManager: model.NewUserManger(db),
+		UserManager: userManager,
+		Seal: logic.SealOption{
+			Enable:    viper.GetBool("seal.enable"),
+			Shares:    viper.GetInt("seal.shares"),
+			Threshold: viper.GetInt("seal.threshold"),
+		},
+		Approval: logic.ApprovalOption{
+			Enable: viper.GetBool("approval.enable"),
+			Window: viper.GetDuration("approval.window"),
+		},
+		OIDC: getOIDCOption(viper.Sub("auth.oidc")),
+		CA:   authority,
+		Revocation: logic.RevocationOption{
+			CRL:     viper.GetString("cert.crl"),
+			CA:      viper.GetString("cert.ca"),
+			Refresh: viper.GetDuration("cert.refresh"),
+		},
+		KeyCache: keeper.CacheOption{
+			Size:    viper.GetInt("cache.size"),
+			Refresh: viper.GetDuration("cache.refresh"),
+		},
+		TLS: map[string]tlspolicy.Policy{
+			"web":   getTLSPolicy("web"),
+			"inner": getTLSPolicy("inner"),
+		},
 		KGs: []logic.KeeperGeneratorPair{ // 密钥保管器 及其 对应的生成器 列表
 			{KeeperName: "Safer", Generator: safer.GetSafer},
-			{KeeperName: "Example", Generator: example.NewExampleKeeper},
+			{KeeperName: "Example", Generator: example.NewExampleKeeper, Algorithms: example.Algorithms},
 		},
 	})
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// 配置热加载
+	setupConfigReload(manager)
+	// 就绪检查
+	manager.AddReadinessCheck("certificate", serverCerts.check)
+
 	// 启动各个服务
+	if addr := viper.GetString("metrics.listen"); len(addr) > 0 {
+		go MetricsServer(manager, addr)
+	}
+	if addr := viper.GetString("health.listen"); len(addr) > 0 {
+		go HealthServer(manager, addr)
+	}
 	go WebServer(manager, viper.GetString("web"))
 	InnerServer(manager, viper.GetString("host"))
 }
@@ -112,14 +277,215 @@ func flushConfig(configPath string, configFileName string) error {
 			return err
 		}
 	}
-	viper.WatchConfig()
-	viper.OnConfigChange(func(e fsnotify.Event) { // 配置文件发生变更之后会调用的回调函数
+	if err := watchConfig(viper.ConfigFileUsed()); err != nil {
+		log.Errorf("watch config error: %v", err)
+	}
+	return nil
+}
+
+// 监听配置文件变更；不使用viper.WatchConfig，使文件读取与SIGHUP、API触发的重新加载串行进行
+func watchConfig(file string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	file = filepath.Clean(file)
+	if err = watcher.Add(filepath.Dir(file)); err != nil { // 监听所在目录以兼容替换文件式的更新
+		_ = watcher.Close()
+		return err
+	}
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != file || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				log.Infof("reload config from %v", event.Name)
+				reloadConfig()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("watch config %v error: %v", file, err)
+			}
+		}
+	}()
+	return nil
+}
+
+var configLock sync.Mutex // Manager尚未创建（如Agent模式）时串行重新加载配置
+
+// 热加载配置：通知Manager中的订阅者；Manager尚未创建（如Agent模式）时仅重新加载日志
+func reloadConfig() {
+	manager := logic.GetManager()
+	if manager == nil {
+		configLock.Lock()
+		defer configLock.Unlock()
+		if err := viper.ReadInConfig(); err != nil {
+			log.Errorf("read config error: %v", err)
+			return
+		}
 		_ = logger.SetupLogger()
-		log.Infof("reload config from %v", e.Name)
+		return
+	}
+	if _, err := manager.ReloadConfig(); err != nil {
+		log.Errorf("read config error: %v", err)
+	}
+}
+
+// 订阅可热加载的配置，并在收到SIGHUP时重新读取配置文件
+func setupConfigReload(manager *logic.Manager) {
+	manager.Subscribe("log", func() {
+		if err := logger.SetupLogger(); err != nil {
+			log.Errorf("reload logger error: %v", err)
+		}
+	})
+	manager.Subscribe("cert", serverCerts.reload)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			log.Infof("reload config on SIGHUP")
+			reloadConfig()
+		}
+	}()
+}
+
+// 初始化外部用户认证器
+func setupAuthenticators(userManager *model.UserManager) error {
+	if config := viper.Sub("auth.ldap"); config != nil && config.GetBool("enable") {
+		var tlsConfig *tls.Config
+		if ca := config.GetString("ca"); len(ca) > 0 {
+			crt, err := ioutil.ReadFile(ca)
+			if err != nil {
+				return err
+			}
+			pool := x509.NewCertPool()
+			pool.AppendCertsFromPEM(crt)
+			tlsConfig = &tls.Config{RootCAs: pool}
+		}
+		groups := make(map[string]int)
+		for group, level := range config.GetStringMap("groups") {
+			groups[group] = cast.ToInt(level)
+		}
+		authenticator, err := model.NewLDAPAuthenticator(model.LDAPOption{
+			URL:            config.GetString("url"),
+			StartTLS:       config.GetBool("startTLS"),
+			TLSConfig:      tlsConfig,
+			Timeout:        config.GetDuration("timeout"),
+			BindDN:         config.GetString("bindDN"),
+			BindPassword:   config.GetString("bindPassword"),
+			BaseDN:         config.GetString("baseDN"),
+			UserFilter:     config.GetString("userFilter"),
+			NameAttribute:  config.GetString("nameAttribute"),
+			GroupAttribute: config.GetString("groupAttribute"),
+			GroupBaseDN:    config.GetString("groupBaseDN"),
+			GroupFilter:    config.GetString("groupFilter"),
+			GroupLevels:    groups,
+			DefaultLevel:   config.GetInt("defaultLevel"),
+			AllowRoot:      config.GetBool("allowRoot"),
+		})
+		if err != nil {
+			return err
+		}
+		userManager.AddAuthenticator(authenticator)
+		log.Infof("ldap authentication is enabled with %v", config.GetString("url"))
+	}
+	return nil
+}
+
+// 初始化内置CA：CA或服务端证书不存在时自动生成
+func setupAuthority(config *viper.Viper) (*ca.Authority, error) {
+	if config == nil || !config.GetBool("enable") {
+		return nil, nil
+	}
+	authority, err := ca.Bootstrap(ca.BootstrapOption{
+		CA:         viper.GetString("cert.ca"),
+		CAPrivate:  config.GetString("private"),
+		Self:       viper.GetString("cert.self"),
+		Private:    viper.GetString("cert.private"),
+		Hosts:      config.GetStringSlice("hosts"),
+		CAValidity: config.GetDuration("validity"),
+		Validity:   config.GetDuration("validity"),
+	})
+	if err != nil {
+		return nil, errors.Newf(-1, "setup certificate authority error: %v", err)
+	}
+	log.Infof("certificate authority is enabled: %v", authority.Cert.Subject.CommonName)
+	return authority, nil
+}
+
+// 初始化链路追踪：导出至OTLP接收端，未启用时所有span均不记录
+func setupTracing(config *viper.Viper) error {
+	if config == nil || !config.GetBool("enable") {
+		return nil
+	}
+	shutdown, err := tracing.Setup(tracing.Option{
+		Endpoint:    config.GetString("endpoint"),
+		URLPath:     config.GetString("urlPath"),
+		Insecure:    config.GetBool("insecure"),
+		Headers:     config.GetStringMapString("headers"),
+		ServiceName: config.GetString("serviceName"),
+		SampleRatio: config.GetFloat64("sampleRatio"),
 	})
+	if err != nil {
+		return errors.Newf(-1, "setup tracing error: %v", err)
+	}
+	// 各服务退出时均经由log.Fatal，退出前刷新尚未导出的span
+	log.RegisterExitHandler(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			log.Errorf("shutdown tracing error: %v", err)
+		}
+	})
+	log.Infof("tracing is enabled, exporting to %v", config.GetString("endpoint"))
 	return nil
 }
 
+// 读取指定监听服务的TLS策略：[tls]为默认策略，[tls.<listener>]中的非空项覆盖之
+func getTLSPolicy(listener string) tlspolicy.Policy {
+	read := func(prefix string) tlspolicy.Policy {
+		return tlspolicy.Policy{
+			MinVersion:   viper.GetString(prefix + ".minVersion"),
+			MaxVersion:   viper.GetString(prefix + ".maxVersion"),
+			CipherSuites: viper.GetStringSlice(prefix + ".cipherSuites"),
+			Curves:       viper.GetStringSlice(prefix + ".curves"),
+		}
+	}
+	return read("tls").Merge(read("tls." + listener))
+}
+
+// 读取OIDC单点登录参数
+func getOIDCOption(config *viper.Viper) logic.OIDCOption {
+	if config == nil || !config.GetBool("enable") {
+		return logic.OIDCOption{}
+	}
+	groups := make(map[string]int)
+	for group, level := range config.GetStringMap("groups") {
+		groups[group] = cast.ToInt(level)
+	}
+	return logic.OIDCOption{
+		Enable: true,
+		Provider: oidc.Config{
+			Issuer:       config.GetString("issuer"),
+			ClientID:     config.GetString("clientID"),
+			ClientSecret: config.GetString("clientSecret"),
+			RedirectURL:  config.GetString("redirectURL"),
+			Scopes:       config.GetStringSlice("scopes"),
+		},
+		UsernameClaim: config.GetString("usernameClaim"),
+		GroupsClaim:   config.GetString("groupsClaim"),
+		GroupLevels:   groups,
+		DefaultLevel:  config.GetInt("defaultLevel"),
+	}
+}
+
 // 初始化gorm数据库
 func setupDatabase(config *viper.Viper) (db *gorm.DB, err error) {
 	if config == nil {
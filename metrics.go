@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/RicheyJang/key_keeper/logic"
+	"github.com/RicheyJang/key_keeper/utils/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// MetricsServer 于独立端口导出Prometheus指标
+func MetricsServer(manager *logic.Manager, addr string) {
+	metrics.Register(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "kk",
+			Name:      "instances",
+			Help:      "Number of loaded instances.",
+		}, func() float64 {
+			return float64(manager.InstanceCount())
+		}),
+		rateLimitCollector{manager: manager},
+	)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	log.Infof("metrics server is listening on %v", addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+var rateLimitDesc = prometheus.NewDesc("kk_ratelimit_requests_total",
+	"Number of inner API requests checked by rate limiters.",
+	[]string{"kind", "key", "result"}, nil)
+
+// 内部API限流计数
+type rateLimitCollector struct {
+	manager *logic.Manager
+}
+
+func (c rateLimitCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- rateLimitDesc
+}
+
+func (c rateLimitCollector) Collect(ch chan<- prometheus.Metric) {
+	instances, certs := c.manager.RateLimitStats()
+	collect := func(kind string, stats []logic.RateLimitStat) {
+		for _, stat := range stats {
+			ch <- prometheus.MustNewConstMetric(rateLimitDesc, prometheus.CounterValue, float64(stat.Allowed), kind, stat.Key, "allowed")
+			ch <- prometheus.MustNewConstMetric(rateLimitDesc, prometheus.CounterValue, float64(stat.Rejected), kind, stat.Key, "rejected")
+		}
+	}
+	collect("instance", instances)
+	collect("cert", certs)
+}
@@ -0,0 +1,42 @@
+package model
+
+// 用户来源
+const (
+	UserSourceLocal = ""     // 本地用户，密码保存于用户表
+	UserSourceLDAP  = "ldap" // LDAP/AD用户
+	UserSourceOIDC  = "oidc" // OIDC单点登录用户
+)
+
+// Identity 外部认证源返回的用户身份
+type Identity struct {
+	Name    string
+	Level   int
+	Issuer  string // 外部认证源标识，如OIDC的iss
+	Subject string // 外部认证源中的唯一标识，如OIDC的sub；非空时以Issuer及Subject而非用户名匹配本地用户
+}
+
+// Authenticator 外部用户认证器
+type Authenticator interface {
+	// Source 认证器对应的用户来源
+	Source() string
+	// Authenticate 验证用户名及密码，验证失败时返回errors.WrongPasswd
+	Authenticate(name, passwd string) (*Identity, error)
+}
+
+// AddAuthenticator 添加外部用户认证器，本地不存在的用户将依次尝试各认证器，成功后自动创建
+func (m *UserManager) AddAuthenticator(authenticator Authenticator) {
+	if authenticator == nil {
+		return
+	}
+	m.authenticators = append(m.authenticators, authenticator)
+}
+
+// 获取指定来源的认证器
+func (m *UserManager) getAuthenticator(source string) Authenticator {
+	for _, authenticator := range m.authenticators {
+		if authenticator.Source() == source {
+			return authenticator
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// Certificate 内置CA签发的实例客户端证书，私钥不落库
+type Certificate struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Serial     string    `gorm:"column:serial;uniqueIndex;size:64" json:"serial"` // 十六进制序列号
+	Identifier string    `gorm:"column:identifier;index" json:"identifier"`       // 绑定的实例标识符，即证书CN
+	CertPEM    string    `gorm:"column:cert_pem;type:text" json:"-"`
+	NotBefore  time.Time `gorm:"column:not_before" json:"notBefore"`
+	NotAfter   time.Time `gorm:"column:not_after" json:"notAfter"`
+	UserID     uint      `gorm:"column:user_id" json:"userID"` // 签发者
+	IsRevoked  bool      `gorm:"column:is_revoked" json:"isRevoked"`
+	RevokedAt  time.Time `gorm:"column:revoked_at" json:"revokedAt"`
+	Reason     string    `gorm:"column:reason" json:"reason"` // 吊销原因
+	CreatedAt  time.Time `json:"createTime"`
+}
+
+func (cert Certificate) TableName() string {
+	return "t_manager_certs"
+}
+
+// IsValid 证书在now时刻是否有效
+func (cert Certificate) IsValid(now time.Time) bool {
+	return !cert.IsRevoked && now.After(cert.NotBefore) && now.Before(cert.NotAfter)
+}
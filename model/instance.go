@@ -15,6 +15,9 @@ type Instance struct {
 	Users      string    `gorm:"column:users" json:"users"`
 	DSafeLevel int       `gorm:"column:d_safe_level" json:"level"`
 	IPs        string    `gorm:"column:ips" json:"ips"`
+	IsKilled   bool      `gorm:"column:is_killed" json:"isKilled"`   // 是否已被紧急熔断
+	RateLimit  float64   `gorm:"column:rate_limit" json:"rateLimit"` // 内部API每秒请求数上限，0代表使用默认值，小于0代表不限
+	RateBurst  int       `gorm:"column:rate_burst" json:"rateBurst"` // 内部API突发请求数上限，0代表使用默认值
 	CreatedAt  time.Time `json:"createTime"`
 }
 
@@ -60,3 +63,19 @@ func (ins *Instance) DeleteUser(user string) {
 	}
 	ins.Users = strings.Join(newUsers, InstanceUserDelimiter)
 }
+
+// KillRecord 实例紧急熔断记录
+type KillRecord struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Identifier string    `gorm:"column:identifier;index" json:"identifier"`
+	UserID     uint      `gorm:"column:user_id" json:"userID"`
+	UserName   string    `gorm:"column:user_name" json:"userName"`
+	Reason     string    `gorm:"column:reason" json:"reason"`
+	Escrow     bool      `gorm:"column:escrow" json:"escrow"`          // 是否保留了密钥托管副本
+	ShredError string    `gorm:"column:shred_error" json:"shredError"` // 粉碎密钥材料失败的原因，为空代表已粉碎
+	CreatedAt  time.Time `json:"createTime"`
+}
+
+func (record KillRecord) TableName() string {
+	return "t_manager_kill_records"
+}
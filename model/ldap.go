@@ -0,0 +1,247 @@
+package model
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/go-ldap/ldap/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+// LDAPConn LDAP连接，可替换为进程内的LDAP替身
+type LDAPConn interface {
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close()
+}
+
+// LDAPOption LDAP/AD认证器参数
+type LDAPOption struct {
+	URL       string      // 如 ldap://host:389 或 ldaps://host:636
+	StartTLS  bool        // 是否在ldap://连接上启用StartTLS
+	TLSConfig *tls.Config // ldaps或StartTLS时使用
+	Timeout   time.Duration
+
+	BindDN       string // 用于查询用户的服务账号，为空则匿名查询
+	BindPassword string
+
+	BaseDN        string // 用户查询根
+	UserFilter    string // 用户查询过滤器，%s替换为转义后的用户名，如 (uid=%s) 或 (sAMAccountName=%s)
+	NameAttribute string // 作为本地用户名的属性，为空则使用输入的用户名
+
+	GroupAttribute string         // 用户条目中记录所属组的属性，如 memberOf
+	GroupBaseDN    string         // 不为空时改为在此查询用户所属的组
+	GroupFilter    string         // 组查询过滤器，%s替换为转义后的用户DN，如 (member=%s)
+	GroupLevels    map[string]int // 组DN -> 权限等级，属于多个组时取最高者
+	DefaultLevel   int            // 不属于任何映射组时的权限等级，小于0代表拒绝登录
+	AllowRoot      bool           // 是否允许由目录授予root等级，为false时最高为admin，root须经本地授权
+
+	Dial func(option LDAPOption) (LDAPConn, error) // 为空时连接URL
+}
+
+type ldapGroupLevel struct {
+	dn    *ldap.DN
+	level int
+}
+
+// LDAPAuthenticator LDAP/AD认证器：服务账号查询用户条目后以用户DN及密码绑定，并按所属组映射权限等级
+type LDAPAuthenticator struct {
+	option LDAPOption
+	groups []ldapGroupLevel
+}
+
+// NewLDAPAuthenticator 创建LDAP/AD认证器
+func NewLDAPAuthenticator(option LDAPOption) (*LDAPAuthenticator, error) {
+	if len(option.URL) == 0 && option.Dial == nil {
+		return nil, errors.New(-1, "Initial Error: ldap url is empty")
+	}
+	if !strings.Contains(option.UserFilter, "%s") {
+		return nil, errors.New(-1, "Initial Error: ldap user filter must contain %s")
+	}
+	if len(option.GroupBaseDN) > 0 && !strings.Contains(option.GroupFilter, "%s") {
+		return nil, errors.New(-1, "Initial Error: ldap group filter must contain %s")
+	}
+	if option.Dial == nil {
+		option.Dial = dialLDAP
+	}
+	a := &LDAPAuthenticator{option: option}
+	for group, level := range option.GroupLevels {
+		dn, err := ldap.ParseDN(group)
+		if err != nil {
+			return nil, errors.Newf(-1, "Initial Error: invalid ldap group %q: %v", group, err)
+		}
+		a.groups = append(a.groups, ldapGroupLevel{dn: dn, level: level})
+	}
+	return a, nil
+}
+
+// Source 用户来源
+func (a *LDAPAuthenticator) Source() string {
+	return UserSourceLDAP
+}
+
+// Authenticate 验证用户名及密码
+func (a *LDAPAuthenticator) Authenticate(name, passwd string) (*Identity, error) {
+	if len(name) == 0 || len(passwd) == 0 { // 空密码将成为匿名绑定
+		return nil, errors.WrongPasswd
+	}
+	conn, err := a.option.Dial(a.option)
+	if err != nil {
+		log.Errorf("dial ldap %v error: %v", a.option.URL, err)
+		return nil, err
+	}
+	defer conn.Close()
+	// 查询用户条目
+	if err = a.bindService(conn); err != nil {
+		return nil, err
+	}
+	attributes := []string{"dn"}
+	if len(a.option.NameAttribute) > 0 {
+		attributes = append(attributes, a.option.NameAttribute)
+	}
+	if len(a.option.GroupBaseDN) == 0 && len(a.option.GroupAttribute) > 0 {
+		attributes = append(attributes, a.option.GroupAttribute)
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(a.option.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, a.timeLimit(), false, fmt.Sprintf(a.option.UserFilter, ldap.EscapeFilter(name)), attributes, nil))
+	if err != nil {
+		log.Errorf("search ldap user %q error: %v", name, err)
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		return nil, errors.WrongPasswd
+	}
+	entry := result.Entries[0]
+	// 以用户身份绑定以验证密码
+	if err = conn.Bind(entry.DN, passwd); err != nil {
+		return nil, errors.WrongPasswd
+	}
+	// 映射权限等级
+	groups, err := a.userGroups(conn, entry)
+	if err != nil {
+		return nil, err
+	}
+	level := a.groupLevel(groups)
+	if level < 0 {
+		log.Warnf("ldap user %q is not in any permitted group", entry.DN)
+		return nil, errors.WrongPasswd
+	}
+	identity := &Identity{Name: name, Level: level}
+	if len(a.option.NameAttribute) > 0 {
+		if value := entry.GetAttributeValue(a.option.NameAttribute); len(value) > 0 {
+			identity.Name = value
+		}
+	}
+	return identity, nil
+}
+
+// 以服务账号绑定
+func (a *LDAPAuthenticator) bindService(conn LDAPConn) error {
+	if len(a.option.BindDN) == 0 {
+		return nil
+	}
+	if err := conn.Bind(a.option.BindDN, a.option.BindPassword); err != nil {
+		log.Errorf("bind ldap service account %q error: %v", a.option.BindDN, err)
+		return err
+	}
+	return nil
+}
+
+// 获取用户所属的组DN
+func (a *LDAPAuthenticator) userGroups(conn LDAPConn, entry *ldap.Entry) ([]string, error) {
+	if len(a.option.GroupBaseDN) == 0 {
+		if len(a.option.GroupAttribute) == 0 {
+			return nil, nil
+		}
+		return entry.GetAttributeValues(a.option.GroupAttribute), nil
+	}
+	// 在组查询根下查询，用户本身可能无权查询组
+	if err := a.bindService(conn); err != nil {
+		return nil, err
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(a.option.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, a.timeLimit(), false, fmt.Sprintf(a.option.GroupFilter, ldap.EscapeFilter(entry.DN)), []string{"dn"}, nil))
+	if err != nil {
+		log.Errorf("search ldap groups of %q error: %v", entry.DN, err)
+		return nil, err
+	}
+	groups := make([]string, 0, len(result.Entries))
+	for _, group := range result.Entries {
+		groups = append(groups, group.DN)
+	}
+	return groups, nil
+}
+
+// 根据所属组计算权限等级，取最高者
+func (a *LDAPAuthenticator) groupLevel(groups []string) int {
+	level, matched := -1, false
+	for _, group := range groups {
+		dn, err := ldap.ParseDN(group)
+		if err != nil {
+			continue
+		}
+		for _, gl := range a.groups {
+			if gl.dn.EqualFold(dn) && gl.level > level {
+				level, matched = gl.level, true
+			}
+		}
+	}
+	if !matched {
+		level = a.option.DefaultLevel
+	}
+	maxLevel := UserLevelAdmin
+	if a.option.AllowRoot {
+		maxLevel = UserLevelRoot
+	}
+	if level > maxLevel {
+		level = maxLevel
+	}
+	return level
+}
+
+func (a *LDAPAuthenticator) timeLimit() int {
+	return int(a.option.Timeout / time.Second)
+}
+
+// 默认连接方式
+func dialLDAP(option LDAPOption) (LDAPConn, error) {
+	opts := []ldap.DialOpt{ldap.DialWithDialer(&net.Dialer{Timeout: option.Timeout})}
+	if option.TLSConfig != nil {
+		opts = append(opts, ldap.DialWithTLSConfig(option.TLSConfig))
+	}
+	conn, err := ldap.DialURL(option.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if option.Timeout > 0 {
+		conn.SetTimeout(option.Timeout)
+	}
+	if option.StartTLS {
+		config := option.TLSConfig
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if config.ServerName == "" {
+			config = config.Clone()
+			config.ServerName = hostOfURL(option.URL)
+		}
+		if err = conn.StartTLS(config); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func hostOfURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
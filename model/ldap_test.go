@@ -0,0 +1,246 @@
+package model
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// 进程内的LDAP替身：仅支持形如(attr=value)的等值过滤器
+type fakeLDAP struct {
+	entries   []*ldap.Entry
+	passwords map[string]string // DN -> 密码
+	bound     string            // 当前绑定的DN
+	closed    bool
+}
+
+func newFakeLDAP() *fakeLDAP {
+	return &fakeLDAP{
+		entries: []*ldap.Entry{
+			ldap.NewEntry("cn=svc,dc=example,dc=com", nil),
+			ldap.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{
+				"uid":      {"alice"},
+				"memberOf": {"cn=kk-admins,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"},
+			}),
+			ldap.NewEntry("uid=bob,ou=people,dc=example,dc=com", map[string][]string{
+				"uid":      {"bob"},
+				"memberOf": {"cn=staff,ou=groups,dc=example,dc=com"},
+			}),
+			ldap.NewEntry("uid=carol,ou=people,dc=example,dc=com", map[string][]string{
+				"uid": {"carol"},
+			}),
+			ldap.NewEntry("cn=kk-admins,ou=groups,dc=example,dc=com", map[string][]string{
+				"member": {"uid=alice,ou=people,dc=example,dc=com"},
+			}),
+			ldap.NewEntry("cn=kk-root,ou=groups,dc=example,dc=com", map[string][]string{
+				"member": {"uid=bob,ou=people,dc=example,dc=com"},
+			}),
+		},
+		passwords: map[string]string{
+			"cn=svc,dc=example,dc=com":              "svc-secret",
+			"uid=alice,ou=people,dc=example,dc=com": "alice-secret",
+			"uid=bob,ou=people,dc=example,dc=com":   "bob-secret",
+			"uid=carol,ou=people,dc=example,dc=com": "carol-secret",
+		},
+	}
+}
+
+func (f *fakeLDAP) dial(LDAPOption) (LDAPConn, error) {
+	return f, nil
+}
+
+func (f *fakeLDAP) Bind(username, password string) error {
+	if expected, ok := f.passwords[username]; !ok || expected != password || len(password) == 0 {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, nil)
+	}
+	f.bound = username
+	return nil
+}
+
+func (f *fakeLDAP) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	attr, value, ok := parseEqualityFilter(request.Filter)
+	if !ok {
+		return nil, ldap.NewError(ldap.LDAPResultFilterError, nil)
+	}
+	result := new(ldap.SearchResult)
+	for _, entry := range f.entries {
+		if !strings.HasSuffix(strings.ToLower(entry.DN), strings.ToLower(request.BaseDN)) {
+			continue
+		}
+		for _, v := range entry.GetAttributeValues(attr) {
+			if strings.EqualFold(v, value) {
+				result.Entries = append(result.Entries, entry)
+				break
+			}
+		}
+	}
+	if request.SizeLimit > 0 && len(result.Entries) > request.SizeLimit {
+		return result, ldap.NewError(ldap.LDAPResultSizeLimitExceeded, nil)
+	}
+	return result, nil
+}
+
+func (f *fakeLDAP) Close() {
+	f.closed = true
+}
+
+// 解析(attr=value)，并还原ldap.EscapeFilter的转义
+func parseEqualityFilter(filter string) (string, string, bool) {
+	if !strings.HasPrefix(filter, "(") || !strings.HasSuffix(filter, ")") {
+		return "", "", false
+	}
+	parts := strings.SplitN(filter[1:len(filter)-1], "=", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	var value strings.Builder
+	for i := 0; i < len(parts[1]); i++ {
+		if parts[1][i] == '\\' && i+2 < len(parts[1]) {
+			b, err := hex.DecodeString(parts[1][i+1 : i+3])
+			if err != nil {
+				return "", "", false
+			}
+			value.Write(b)
+			i += 2
+			continue
+		}
+		value.WriteByte(parts[1][i])
+	}
+	return parts[0], value.String(), true
+}
+
+func newTestLDAPAuthenticator(t *testing.T, modify func(option *LDAPOption)) (*LDAPAuthenticator, *fakeLDAP) {
+	t.Helper()
+	fake := newFakeLDAP()
+	option := LDAPOption{
+		BindDN:         "cn=svc,dc=example,dc=com",
+		BindPassword:   "svc-secret",
+		BaseDN:         "ou=people,dc=example,dc=com",
+		UserFilter:     "(uid=%s)",
+		NameAttribute:  "uid",
+		GroupAttribute: "memberOf",
+		GroupLevels: map[string]int{
+			"cn=kk-admins,ou=groups,dc=example,dc=com": UserLevelAdmin,
+			"CN=KK-Root,OU=Groups,DC=example,DC=com":   UserLevelRoot,
+		},
+		DefaultLevel: UserLevelGeneral,
+		Dial:         fake.dial,
+	}
+	if modify != nil {
+		modify(&option)
+	}
+	a, err := NewLDAPAuthenticator(option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, fake
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	cases := []struct {
+		name     string
+		modify   func(option *LDAPOption)
+		user     string
+		password string
+		level    int
+		err      error
+	}{
+		{"admin group", nil, "alice", "alice-secret", UserLevelAdmin, nil},
+		{"unmapped group uses default", nil, "bob", "bob-secret", UserLevelGeneral, nil},
+		{"no group uses default", nil, "carol", "carol-secret", UserLevelGeneral, nil},
+		{"wrong password", nil, "alice", "wrong", 0, errors.WrongPasswd},
+		{"empty password", nil, "alice", "", 0, errors.WrongPasswd},
+		{"user not found", nil, "mallory", "any", 0, errors.WrongPasswd},
+		{"filter injection", nil, "*", "alice-secret", 0, errors.WrongPasswd},
+		{"default level denies", func(option *LDAPOption) {
+			option.DefaultLevel = -1
+		}, "carol", "carol-secret", 0, errors.WrongPasswd},
+		{"groups searched by member", func(option *LDAPOption) {
+			option.GroupBaseDN = "ou=groups,dc=example,dc=com"
+			option.GroupFilter = "(member=%s)"
+			option.AllowRoot = true
+		}, "bob", "bob-secret", UserLevelRoot, nil},
+		{"root group capped at admin", func(option *LDAPOption) {
+			option.GroupBaseDN = "ou=groups,dc=example,dc=com"
+			option.GroupFilter = "(member=%s)"
+		}, "bob", "bob-secret", UserLevelAdmin, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a, fake := newTestLDAPAuthenticator(t, c.modify)
+			identity, err := a.Authenticate(c.user, c.password)
+			if err != c.err {
+				t.Fatalf("got error %v, want %v", err, c.err)
+			}
+			if err == nil && (identity.Name != c.user || identity.Level != c.level) {
+				t.Fatalf("got identity %+v, want %s with level %d", identity, c.user, c.level)
+			}
+			if len(c.password) > 0 && !fake.closed {
+				t.Fatal("connection is not closed")
+			}
+		})
+	}
+}
+
+func TestLDAPServiceBindFailure(t *testing.T) {
+	a, _ := newTestLDAPAuthenticator(t, func(option *LDAPOption) {
+		option.BindPassword = "wrong"
+	})
+	_, err := a.Authenticate("alice", "alice-secret")
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		t.Fatalf("got %v, want the service bind error", err)
+	}
+}
+
+func TestLDAPGroupLevel(t *testing.T) {
+	a, _ := newTestLDAPAuthenticator(t, func(option *LDAPOption) {
+		option.GroupLevels["cn=super,ou=groups,dc=example,dc=com"] = UserLevelRoot + 5
+	})
+	allowRoot, _ := newTestLDAPAuthenticator(t, func(option *LDAPOption) {
+		option.GroupLevels["cn=super,ou=groups,dc=example,dc=com"] = UserLevelRoot + 5
+		option.AllowRoot = true
+	})
+	cases := []struct {
+		groups    []string
+		level     int
+		rootLevel int // 允许root时的等级
+	}{
+		{nil, UserLevelGeneral, UserLevelGeneral},
+		{[]string{"cn=staff,ou=groups,dc=example,dc=com"}, UserLevelGeneral, UserLevelGeneral},
+		{[]string{"cn=kk-admins,ou=groups,dc=example,dc=com"}, UserLevelAdmin, UserLevelAdmin},
+		{[]string{"CN=kk-admins, OU=groups, DC=example, DC=com"}, UserLevelAdmin, UserLevelAdmin}, // DN比较不区分大小写及空格
+		{[]string{"cn=kk-admins,ou=groups,dc=example,dc=com", "cn=kk-root,ou=groups,dc=example,dc=com"}, UserLevelAdmin, UserLevelRoot},
+		{[]string{"cn=super,ou=groups,dc=example,dc=com"}, UserLevelAdmin, UserLevelRoot}, // 不超过root
+		{[]string{"not a dn"}, UserLevelGeneral, UserLevelGeneral},
+	}
+	for _, c := range cases {
+		if level := a.groupLevel(c.groups); level != c.level {
+			t.Errorf("groups %v: got level %d, want %d", c.groups, level, c.level)
+		}
+		if level := allowRoot.groupLevel(c.groups); level != c.rootLevel {
+			t.Errorf("groups %v with root allowed: got level %d, want %d", c.groups, level, c.rootLevel)
+		}
+	}
+}
+
+func TestNewLDAPAuthenticatorInvalid(t *testing.T) {
+	cases := []struct {
+		name   string
+		option LDAPOption
+	}{
+		{"empty url", LDAPOption{UserFilter: "(uid=%s)"}},
+		{"user filter without placeholder", LDAPOption{URL: "ldap://localhost", UserFilter: "(uid=alice)"}},
+		{"group filter without placeholder", LDAPOption{URL: "ldap://localhost", UserFilter: "(uid=%s)",
+			GroupBaseDN: "ou=groups,dc=example,dc=com", GroupFilter: "(member=x)"}},
+		{"invalid group dn", LDAPOption{URL: "ldap://localhost", UserFilter: "(uid=%s)",
+			GroupLevels: map[string]int{"not a dn": 1}}},
+	}
+	for _, c := range cases {
+		if _, err := NewLDAPAuthenticator(c.option); err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+}
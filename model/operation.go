@@ -0,0 +1,42 @@
+package model
+
+import "time"
+
+// 待审批操作类型
+const (
+	OperationDestroyKey      = "destroy-key"
+	OperationDestroyInstance = "destroy-instance"
+	OperationSetRootLevel    = "set-root-level"
+	OperationIssueCert       = "issue-cert" // 批准后由发起者凭操作ID签发，私钥仅返回给发起者
+)
+
+// 待审批操作状态
+const (
+	OperationPending  = "pending"
+	OperationApproved = "approved" // 已批准并执行成功
+	OperationFailed   = "failed"   // 已批准但执行失败
+	OperationRejected = "rejected"
+	OperationExpired  = "expired"
+	OperationUsed     = "used" // 已批准的签发授权已被发起者使用
+)
+
+// Operation 需双人审批的破坏性或敏感操作
+type Operation struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Type          string    `gorm:"column:type" json:"type"`
+	Identifier    string    `gorm:"column:identifier;index" json:"identifier"` // 操作所涉及的实例，可为空
+	Payload       string    `gorm:"column:payload" json:"payload"`             // 操作参数（JSON）
+	Status        string    `gorm:"column:status;index" json:"status"`
+	Message       string    `gorm:"column:message" json:"message"` // 执行失败时的错误信息
+	RequesterID   uint      `gorm:"column:requester_id" json:"requesterID"`
+	RequesterName string    `gorm:"column:requester_name" json:"requesterName"`
+	ApproverID    uint      `gorm:"column:approver_id" json:"approverID"`
+	ApproverName  string    `gorm:"column:approver_name" json:"approverName"`
+	ExpireAt      time.Time `gorm:"column:expire_at" json:"expireTime"`
+	CreatedAt     time.Time `json:"createTime"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+func (op Operation) TableName() string {
+	return "t_manager_operations"
+}
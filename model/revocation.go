@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// RevokedSerial 本地维护的已吊销证书序列号，作用于所有CA签发的客户端证书
+type RevokedSerial struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Serial    string    `gorm:"column:serial;uniqueIndex;size:64" json:"serial"` // 十六进制序列号
+	Reason    string    `gorm:"column:reason" json:"reason"`
+	UserID    uint      `gorm:"column:user_id" json:"userID"`
+	UserName  string    `gorm:"column:user_name" json:"userName"`
+	CreatedAt time.Time `json:"createTime"`
+}
+
+func (serial RevokedSerial) TableName() string {
+	return "t_manager_revoked_serials"
+}
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// SealInfo 密封模式下的根密钥信息，根密钥本身不落盘，仅以分片形式由多名操作员保管
+type SealInfo struct {
+	ID        uint      `gorm:"primaryKey"`
+	Shares    int       `gorm:"column:shares"`    // 分片总数
+	Threshold int       `gorm:"column:threshold"` // 解封所需分片数
+	Verifier  []byte    `gorm:"column:verifier"`  // 由根密钥加密的校验值，用于验证恢复出的根密钥
+	Digests   string    `gorm:"column:digests"`   // 各分片的摘要（16进制，逗号分隔），用于逐份校验提交的分片，为空代表旧版本未记录
+	CreatedAt time.Time `json:"createTime"`
+}
+
+func (info SealInfo) TableName() string {
+	return "t_manager_seal"
+}
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// Session Web登录会话：短期访问token通过其中的会话ID关联至此，长期刷新token仅保存摘要
+type Session struct {
+	ID          string    `gorm:"primaryKey;size:32" json:"id"`
+	UserID      uint      `gorm:"column:user_id;index" json:"userID"`
+	RefreshHash string    `gorm:"column:refresh_hash;index;size:64" json:"-"`
+	PrevHash    string    `gorm:"column:prev_hash;index;size:64" json:"-"` // 上一个刷新token的摘要，用于发现被盗用的刷新token
+	IP          string    `gorm:"column:ip" json:"ip"`
+	UserAgent   string    `gorm:"column:user_agent" json:"userAgent"`
+	LastSeen    time.Time `gorm:"column:last_seen" json:"lastSeen"`
+	ExpireAt    time.Time `gorm:"column:expire_at" json:"expireAt"` // 绝对过期时间
+	IsRevoked   bool      `gorm:"column:is_revoked" json:"isRevoked"`
+	CreatedAt   time.Time `json:"createTime"`
+}
+
+func (session Session) TableName() string {
+	return "t_manager_sessions"
+}
+
+// IsActive 会话在now时刻是否有效
+func (session Session) IsActive(now time.Time, idleTimeout time.Duration) bool {
+	if session.IsRevoked || now.After(session.ExpireAt) {
+		return false
+	}
+	return idleTimeout <= 0 || now.Sub(session.LastSeen) <= idleTimeout
+}
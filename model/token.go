@@ -0,0 +1,74 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// APIToken 用于自动化调用Web API的长期令牌，仅保存其摘要
+type APIToken struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"column:user_id;index" json:"userID"`
+	Name      string    `gorm:"column:name" json:"name"`
+	Prefix    string    `gorm:"column:prefix" json:"prefix"` // 令牌明文的前若干位，便于辨认
+	Hash      string    `gorm:"column:hash;uniqueIndex;size:64" json:"-"`
+	Level     int       `gorm:"column:level" json:"level"`         // 令牌权限等级，实际生效的为其与所属用户等级的较小者
+	Scopes    string    `gorm:"column:scopes" json:"scopes"`       // 可调用的API，形如"GET /api/keys"，为空代表不限
+	Instances string    `gorm:"column:instances" json:"instances"` // 可访问的实例标识，为空代表不限
+	ExpireAt  time.Time `gorm:"column:expire_at" json:"expireAt"`  // 零值代表永不过期
+	LastUsed  time.Time `gorm:"column:last_used" json:"lastUsed"`
+	LastIP    string    `gorm:"column:last_ip" json:"lastIP"`
+	IsRevoked bool      `gorm:"column:is_revoked" json:"isRevoked"`
+	CreatedAt time.Time `json:"createTime"`
+}
+
+func (token APIToken) TableName() string {
+	return "t_manager_tokens"
+}
+
+const APITokenDelimiter = ","
+
+func (token APIToken) GetScopes() []string {
+	return splitNonEmpty(token.Scopes, APITokenDelimiter)
+}
+
+func (token APIToken) GetInstances() []string {
+	return splitNonEmpty(token.Instances, APITokenDelimiter)
+}
+
+// IsExpired 令牌是否已过期
+func (token APIToken) IsExpired(now time.Time) bool {
+	return !token.ExpireAt.IsZero() && now.After(token.ExpireAt)
+}
+
+// AllowRequest 令牌是否可调用该API：scope形如"METHOD /path"，METHOD可为*，path按路径段前缀匹配
+func (token APIToken) AllowRequest(method, path string) bool {
+	scopes := token.GetScopes()
+	if len(scopes) == 0 {
+		return true
+	}
+	for _, scope := range scopes {
+		fields := strings.Fields(scope)
+		if len(fields) != 2 {
+			continue
+		}
+		if fields[0] != "*" && !strings.EqualFold(fields[0], method) {
+			continue
+		}
+		prefix := strings.TrimSuffix(fields[1], "/")
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func splitNonEmpty(s, sep string) []string {
+	var res []string
+	for _, item := range strings.Split(s, sep) {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			res = append(res, item)
+		}
+	}
+	return res
+}
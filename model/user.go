@@ -3,6 +3,7 @@ package model
 import (
 	"crypto/sha256"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/RicheyJang/key_keeper/utils/errors"
@@ -24,8 +25,21 @@ type User struct {
 	IsFrozen  bool      `gorm:"column:is_frozen" json:"isFrozen"`
 	LastLogin time.Time `gorm:"column:last_login" json:"lastLogin"`
 	LastIP    string    `gorm:"column:last_ip" json:"lastIP"`
+	Source    string    `gorm:"column:source;default:''" json:"source"` // 用户来源，为空代表本地用户
+	Issuer    string    `gorm:"column:issuer;default:''" json:"-"`      // 外部用户的认证源标识
+	Subject   string    `gorm:"column:subject;default:''" json:"-"`     // 外部用户在认证源中的唯一标识
 	CreatedAt time.Time `json:"createTime"`
 	UpdatedAt time.Time `json:"updatedAt"`
+
+	TOTPSecret    string `gorm:"column:totp_secret" json:"-"`
+	TOTPEnabled   bool   `gorm:"column:totp_enabled" json:"totpEnabled"`
+	TOTPLastStep  int64  `gorm:"column:totp_last_step;not null;default:0" json:"-"` // 最近一次使用的TOTP时间步，用于防重放
+	RecoveryCodes string `gorm:"column:recovery_codes" json:"-"`                    // 逗号分隔的恢复码SHA256
+
+	FailedLogins    int       `gorm:"column:failed_logins" json:"failedLogins"` // 自上次成功登录以来的连续失败次数
+	LastFailedLogin time.Time `gorm:"column:last_failed_login" json:"lastFailedLogin"`
+	LastFailedIP    string    `gorm:"column:last_failed_ip" json:"lastFailedIP"`
+	LockedUntil     time.Time `gorm:"column:locked_until" json:"lockedUntil"`
 }
 
 func (user User) TableName() string {
@@ -33,7 +47,8 @@ func (user User) TableName() string {
 }
 
 type UserManager struct {
-	db *gorm.DB
+	db             *gorm.DB
+	authenticators []Authenticator // 外部用户认证器
 }
 
 // NewUserManger 创建新的用户管理器
@@ -73,6 +88,13 @@ func (m *UserManager) Get(id uint) (*User, error) {
 	return &user, nil
 }
 
+// GetByName 根据用户名获取用户，不存在时返回零值
+func (m *UserManager) GetByName(name string) (User, error) {
+	var user User
+	err := m.db.Where("name = ?", name).Limit(1).Find(&user).Error
+	return user, err
+}
+
 // Add 新增用户
 func (m *UserManager) Add(user *User) error {
 	// 验证用户
@@ -115,12 +137,100 @@ func (m *UserManager) Count(filter UserFilter) int64 {
 // CheckUser 验证用户名密码
 func (m *UserManager) CheckUser(name, passwd string) (User, error) {
 	var user User
-	if err := m.db.Where("name = ?", name).First(&user).Error; err != nil {
-		return user, err
+	result := m.db.Where("name = ?", name).Limit(1).Find(&user)
+	if result.Error != nil {
+		return user, result.Error
+	}
+	if result.RowsAffected == 0 { // 本地不存在：尝试外部认证器
+		return m.provisionUser(name, passwd)
 	}
-	if passwdToSha256(passwd) != user.Passwd {
+	if user.Source == UserSourceLocal {
+		if passwdToSha256(passwd) != user.Passwd {
+			return user, errors.WrongPasswd
+		}
+		return user, nil
+	}
+	// 外部用户：交由对应认证器验证
+	authenticator := m.getAuthenticator(user.Source)
+	if authenticator == nil {
 		return user, errors.WrongPasswd
 	}
+	identity, err := authenticator.Authenticate(name, passwd)
+	if err != nil {
+		return user, err
+	}
+	return m.SyncExternalUser(user.Source, *identity)
+}
+
+// 依次尝试各外部认证器，成功后自动创建对应用户
+func (m *UserManager) provisionUser(name, passwd string) (User, error) {
+	for _, authenticator := range m.authenticators {
+		identity, err := authenticator.Authenticate(name, passwd)
+		if err != nil {
+			continue
+		}
+		return m.SyncExternalUser(authenticator.Source(), *identity)
+	}
+	return User{}, errors.WrongPasswd
+}
+
+// LoadExternalUser 获取外部身份对应的本地用户，不存在时返回零值；同名用户属于其它来源或其它外部身份时返回errors.UserExist
+func (m *UserManager) LoadExternalUser(source string, identity Identity) (User, error) {
+	if len(identity.Name) == 0 || source == UserSourceLocal {
+		return User{}, errors.InvalidRequest
+	}
+	var user User
+	if len(identity.Subject) > 0 { // 以认证源中的唯一标识匹配，用户名可能被用户自行修改
+		result := m.db.Where("source = ? AND issuer = ? AND subject = ?", source, identity.Issuer, identity.Subject).Limit(1).Find(&user)
+		if result.Error != nil {
+			return User{}, result.Error
+		}
+		if result.RowsAffected > 0 {
+			return user, nil
+		}
+	}
+	result := m.db.Where("name = ?", identity.Name).Limit(1).Find(&user)
+	if result.Error != nil {
+		return User{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return User{}, nil
+	}
+	// 不允许外部用户冒用同名的其它来源用户或其它外部身份
+	if user.Source != source || len(identity.Subject) > 0 {
+		log.Warnf("%v user %q conflicts with an existing %q user", source, identity.Name, user.Source)
+		return User{}, errors.UserExist
+	}
+	return user, nil
+}
+
+// SyncExternalUser 根据外部认证源返回的身份获取本地用户：不存在时自动创建，存在时以外部认证源为准同步权限等级
+func (m *UserManager) SyncExternalUser(source string, identity Identity) (User, error) {
+	user, err := m.LoadExternalUser(source, identity)
+	if err != nil {
+		return User{}, err
+	}
+	if user.ID == 0 { // 自动创建
+		user = User{
+			Name:    identity.Name,
+			Level:   identity.Level,
+			Source:  source,
+			Issuer:  identity.Issuer,
+			Subject: identity.Subject,
+		}
+		if err = m.db.Create(&user).Error; err != nil {
+			return User{}, err
+		}
+		log.Infof("user %v(%v) is provisioned from %v with level %d", user.Name, user.ID, user.Source, user.Level)
+		return user, nil
+	}
+	if identity.Level != user.Level {
+		if err = m.SetLevel(user.ID, identity.Level); err != nil {
+			return user, err
+		}
+		log.Infof("level of user %v(%v) is synced from %v: %d -> %d", user.Name, user.ID, user.Source, user.Level, identity.Level)
+		user.Level = identity.Level
+	}
 	return user, nil
 }
 
@@ -135,6 +245,44 @@ func (m *UserManager) SaveUserLoginInfo(user User) error {
 	}).Error
 }
 
+// SaveLoginFailure 递增登录失败次数，并按lockout计算的截止时间延长锁定，返回失败次数及锁定截止时间
+func (m *UserManager) SaveLoginFailure(id uint, ip string, lockout func(failures int) time.Time) (int, time.Time, error) {
+	if id == 0 {
+		return 0, time.Time{}, errors.InvalidRequest
+	}
+	var user User
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		// 在数据库中递增，避免并发失败相互覆盖
+		if err := tx.Model(&User{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{ // 不更新updated_at
+			"failed_logins":     gorm.Expr("failed_logins + 1"),
+			"last_failed_login": time.Now(),
+			"last_failed_ip":    ip,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Select("failed_logins", "locked_until").Where("id = ?", id).First(&user).Error; err != nil {
+			return err
+		}
+		if until := lockout(user.FailedLogins); until.After(user.LockedUntil) {
+			user.LockedUntil = until
+			return tx.Model(&User{}).Where("id = ?", id).UpdateColumn("locked_until", until).Error
+		}
+		return nil
+	})
+	return user.FailedLogins, user.LockedUntil, err
+}
+
+// ClearLoginFailure 清除用户的登录失败计数及锁定
+func (m *UserManager) ClearLoginFailure(id uint) error {
+	if id == 0 {
+		return errors.InvalidRequest
+	}
+	return m.db.Model(&User{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"failed_logins": 0,
+		"locked_until":  time.Time{},
+	}).Error
+}
+
 // Freeze 冻结用户
 func (m *UserManager) Freeze(id uint, isFrozen bool) error {
 	return m.db.Model(&User{}).Where("id = ?", id).Update("is_frozen", isFrozen).Error
@@ -156,14 +304,84 @@ func (m *UserManager) SetLevel(id uint, level int) error {
 	return m.db.Model(&User{}).Where("id = ?", id).Update("level", level).Error
 }
 
-// ChangePasswd 修改用户密码
+// ChangePasswd 修改用户密码，外部用户的密码由其认证源管理
 func (m *UserManager) ChangePasswd(id uint, passwd string) error {
 	if len(passwd) == 0 {
 		return errors.InvalidRequest
 	}
+	user, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	if user.Source != UserSourceLocal {
+		return errors.ExternalUser
+	}
 	return m.db.Model(&User{}).Where("id = ?", id).Update("passwd", passwdToSha256(passwd)).Error
 }
 
+// SetTOTP 设置用户的TOTP密钥及启用状态，secret为空代表关闭双因素认证
+func (m *UserManager) SetTOTP(id uint, secret string, enabled bool) error {
+	if id == 0 {
+		return errors.InvalidRequest
+	}
+	updates := map[string]interface{}{
+		"totp_secret":  secret,
+		"totp_enabled": enabled,
+	}
+	if !enabled { // 新的待验证密钥或关闭：重置已使用的时间步
+		updates["totp_last_step"] = 0
+	}
+	if len(secret) == 0 {
+		updates["recovery_codes"] = ""
+	}
+	return m.db.Model(&User{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// UseTOTPStep 记录已使用的TOTP时间步，不晚于上次使用的时间步时返回false，防止验证码被重放
+func (m *UserManager) UseTOTPStep(id uint, step int64) (bool, error) {
+	result := m.db.Model(&User{}).Where("id = ? AND totp_last_step < ?", id, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil // 并发使用同一验证码时仅一方成功
+}
+
+// SetRecoveryCodes 重置用户的恢复码，仅保存其摘要
+func (m *UserManager) SetRecoveryCodes(id uint, codes []string) error {
+	if id == 0 {
+		return errors.InvalidRequest
+	}
+	hashed := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashed = append(hashed, passwdToSha256(normalizeRecoveryCode(code)))
+	}
+	return m.db.Model(&User{}).Where("id = ?", id).Update("recovery_codes", strings.Join(hashed, ",")).Error
+}
+
+// UseRecoveryCode 校验并消耗一个恢复码，每个恢复码仅可使用一次
+func (m *UserManager) UseRecoveryCode(id uint, code string) (bool, error) {
+	user, err := m.Get(id)
+	if err != nil {
+		return false, err
+	}
+	target := passwdToSha256(normalizeRecoveryCode(code))
+	hashed := strings.Split(user.RecoveryCodes, ",")
+	for i, h := range hashed {
+		if len(h) == 0 || h != target {
+			continue
+		}
+		remain := append(hashed[:i:i], hashed[i+1:]...)
+		result := m.db.Model(&User{}).Where("id = ? AND recovery_codes = ?", id, user.RecoveryCodes).
+			Update("recovery_codes", strings.Join(remain, ","))
+		if result.Error != nil {
+			return false, result.Error
+		}
+		return result.RowsAffected > 0, nil // 并发使用同一恢复码时仅一方成功
+	}
+	return false, nil
+}
+
 func (m *UserManager) setupFilterSession(filter UserFilter) *gorm.DB {
 	db := m.db.Model(&User{})
 	if filter.Offset > 0 {
@@ -180,3 +398,7 @@ func (m *UserManager) setupFilterSession(filter UserFilter) *gorm.DB {
 func passwdToSha256(passwd string) string {
 	return fmt.Sprintf("%x", sha256.Sum256([]byte(passwd)))
 }
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestUserManager(t *testing.T) *UserManager {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1) // 内存数据库仅存在于单个连接中
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	return NewUserManger(db)
+}
+
+func TestSyncExternalUser(t *testing.T) {
+	m := newTestUserManager(t)
+	alice := Identity{Name: "alice", Level: UserLevelGeneral, Issuer: "https://idp.example.com", Subject: "1001"}
+	created, err := m.SyncExternalUser(UserSourceOIDC, alice)
+	if err != nil || created.ID == 0 || created.Subject != "1001" {
+		t.Fatalf("got %+v, %v", created, err)
+	}
+	// 用户名被修改后仍以iss及sub匹配，并同步权限等级
+	renamed := alice
+	renamed.Name, renamed.Level = "alice2", UserLevelAdmin
+	user, err := m.SyncExternalUser(UserSourceOIDC, renamed)
+	if err != nil || user.ID != created.ID || user.Level != UserLevelAdmin {
+		t.Fatalf("got %+v, %v", user, err)
+	}
+
+	cases := []struct {
+		name     string
+		source   string
+		identity Identity
+	}{
+		{"local user name", UserSourceOIDC, Identity{Name: "root", Issuer: alice.Issuer, Subject: "1002"}},
+		{"other subject", UserSourceOIDC, Identity{Name: "alice", Issuer: alice.Issuer, Subject: "1003"}},
+		{"other issuer", UserSourceOIDC, Identity{Name: "alice", Issuer: "https://evil.example.com", Subject: "1001"}},
+		{"other source", UserSourceLDAP, Identity{Name: "alice"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if user, err := m.SyncExternalUser(c.source, c.identity); err != errors.UserExist {
+				t.Fatalf("got %+v, %v, want UserExist", user, err)
+			}
+		})
+	}
+
+	// 不存在时返回零值，不自动创建
+	user, err = m.LoadExternalUser(UserSourceOIDC, Identity{Name: "bob", Issuer: alice.Issuer, Subject: "1004"})
+	if err != nil || user.ID != 0 {
+		t.Fatalf("got %+v, %v", user, err)
+	}
+	var count int64
+	m.db.Model(&User{}).Where("name = ?", "bob").Count(&count)
+	if count != 0 {
+		t.Fatal("user is created by LoadExternalUser")
+	}
+}
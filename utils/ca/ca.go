@@ -0,0 +1,276 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/RicheyJang/key_keeper/utils"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// 内置证书颁发机构：签发服务端证书及绑定实例标识符（CN）的客户端证书，私钥统一使用ECDSA P-256
+
+const (
+	defaultCAName   = "Key Keeper CA"
+	defaultValidity = 365 * 24 * time.Hour
+)
+
+// Authority 证书颁发机构
+type Authority struct {
+	Cert    *x509.Certificate
+	CertPEM []byte
+	Key     crypto.Signer
+}
+
+// Load 从PEM文件读取CA证书及私钥
+func Load(certFile, keyFile string) (*Authority, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := ParseCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, errors.New("not a CA certificate")
+	}
+	key, err := ParsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{Cert: cert, CertPEM: certPEM, Key: key}, nil
+}
+
+// BootstrapOption 首次运行时生成CA及服务端证书的参数，已存在的文件不会被覆盖
+type BootstrapOption struct {
+	CA         string        // CA证书路径
+	CAPrivate  string        // CA私钥路径
+	Self       string        // 服务端证书路径
+	Private    string        // 服务端私钥路径
+	Hosts      []string      // 服务端证书的域名及IP
+	CAValidity time.Duration // CA证书有效期
+	Validity   time.Duration // 服务端证书有效期
+}
+
+// Bootstrap 若CA证书与私钥均不存在则生成之，若服务端证书与私钥均不存在则由CA签发之
+func Bootstrap(option BootstrapOption) (*Authority, error) {
+	var authority *Authority
+	var err error
+	if !utils.FileExists(option.CA) && !utils.FileExists(option.CAPrivate) {
+		if authority, err = NewAuthority(defaultCAName, option.CAValidity); err != nil {
+			return nil, err
+		}
+		if err = writePair(option.CA, authority.CertPEM, option.CAPrivate, authority.Key); err != nil {
+			return nil, err
+		}
+	} else if authority, err = Load(option.CA, option.CAPrivate); err != nil {
+		return nil, err
+	}
+	if !utils.FileExists(option.Self) && !utils.FileExists(option.Private) {
+		certPEM, key, _, err := authority.IssueServer(option.Hosts, option.Validity)
+		if err != nil {
+			return nil, err
+		}
+		if err = writePair(option.Self, certPEM, option.Private, key); err != nil {
+			return nil, err
+		}
+	}
+	return authority, nil
+}
+
+// NewAuthority 生成自签名CA
+func NewAuthority(name string, validity time.Duration) (*Authority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template, err := newTemplate(name, validity)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.MaxPathLenZero = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{Cert: cert, CertPEM: encodeCertificate(der), Key: key}, nil
+}
+
+// IssueServer 签发服务端证书，hosts可为域名或IP
+func (a *Authority) IssueServer(hosts []string, validity time.Duration) ([]byte, crypto.Signer, *x509.Certificate, error) {
+	name := "localhost"
+	if len(hosts) > 0 {
+		name = hosts[0]
+	}
+	template, err := newTemplate(name, validity)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	return a.issue(template)
+}
+
+// IssueClient 签发客户端证书，其CN为实例标识符
+func (a *Authority) IssueClient(identifier string, validity time.Duration) ([]byte, crypto.Signer, *x509.Certificate, error) {
+	template, err := newTemplate(identifier, validity)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	return a.issue(template)
+}
+
+func (a *Authority) issue(template *x509.Certificate) ([]byte, crypto.Signer, *x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if template.NotAfter.After(a.Cert.NotAfter) { // 不超过CA有效期
+		template.NotAfter = a.Cert.NotAfter
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, a.Cert, key.Public(), a.Key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return encodeCertificate(der), key, cert, nil
+}
+
+// EncodePKCS12 将证书、私钥及CA证书打包为PKCS#12格式
+func (a *Authority) EncodePKCS12(cert *x509.Certificate, key crypto.Signer, password string) ([]byte, error) {
+	return pkcs12.Encode(rand.Reader, key, cert, []*x509.Certificate{a.Cert}, password)
+}
+
+// EncodePrivateKey 将私钥编码为PKCS#8格式PEM
+func EncodePrivateKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParseCertificate 解析PEM格式证书（取首个证书）
+func ParseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+	return nil, errors.New("no certificate found")
+}
+
+// ParseCertificates 解析PEM格式的所有证书，忽略无法解析的部分
+func ParseCertificates(certPEM []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
+
+// ParsePrivateKey 解析PEM格式私钥，支持PKCS#8、PKCS#1及SEC 1格式
+func ParsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no private key found")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, errors.New("unsupported private key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported private key")
+}
+
+// SerialString 证书序列号的十六进制表示
+func SerialString(serial *big.Int) string {
+	return strings.ToLower(hex.EncodeToString(serial.Bytes()))
+}
+
+func newTemplate(name string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	if validity <= 0 {
+		validity = defaultValidity
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-5 * time.Minute), // 容忍时钟偏差
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+func encodeCertificate(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// 写入证书及私钥文件，私钥仅所有者可读
+func writePair(certFile string, certPEM []byte, keyFile string, key crypto.Signer) error {
+	keyPEM, err := EncodePrivateKey(key)
+	if err != nil {
+		return err
+	}
+	for _, file := range []string{certFile, keyFile} {
+		if err = os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return err
+		}
+	}
+	if err = ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, certPEM, 0644)
+}
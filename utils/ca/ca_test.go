@@ -0,0 +1,235 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestAuthority(t *testing.T, validity time.Duration) *Authority {
+	t.Helper()
+	authority, err := NewAuthority("Test CA", validity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authority
+}
+
+func TestIssue(t *testing.T) {
+	authority := newTestAuthority(t, 48*time.Hour)
+	roots := x509.NewCertPool()
+	roots.AddCert(authority.Cert)
+	tests := []struct {
+		name     string
+		issue    func() ([]byte, *x509.Certificate, error)
+		usage    x509.ExtKeyUsage
+		cn       string
+		dns      string
+		ip       string
+		validity time.Duration
+	}{
+		{
+			name: "client",
+			issue: func() ([]byte, *x509.Certificate, error) {
+				certPEM, _, cert, err := authority.IssueClient("my-instance", 24*time.Hour)
+				return certPEM, cert, err
+			},
+			usage: x509.ExtKeyUsageClientAuth, cn: "my-instance", validity: 24 * time.Hour,
+		},
+		{
+			name: "client capped by ca",
+			issue: func() ([]byte, *x509.Certificate, error) {
+				certPEM, _, cert, err := authority.IssueClient("my-instance", 365*24*time.Hour)
+				return certPEM, cert, err
+			},
+			usage: x509.ExtKeyUsageClientAuth, cn: "my-instance", validity: 48 * time.Hour,
+		},
+		{
+			name: "server",
+			issue: func() ([]byte, *x509.Certificate, error) {
+				certPEM, _, cert, err := authority.IssueServer([]string{"kk.example.com", "127.0.0.1"}, 24*time.Hour)
+				return certPEM, cert, err
+			},
+			usage: x509.ExtKeyUsageServerAuth, cn: "kk.example.com", dns: "kk.example.com", ip: "127.0.0.1", validity: 24 * time.Hour,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			certPEM, cert, err := test.issue()
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := ParseCertificate(certPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.SerialNumber.Cmp(cert.SerialNumber) != 0 || parsed.Subject.CommonName != test.cn {
+				t.Fatalf("unexpected certificate: serial %v, cn %q", parsed.SerialNumber, parsed.Subject.CommonName)
+			}
+			if _, err = parsed.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{test.usage}}); err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if parsed.NotAfter.After(authority.Cert.NotAfter) {
+				t.Fatalf("not after %v exceeds the ca %v", parsed.NotAfter, authority.Cert.NotAfter)
+			}
+			if d := time.Until(parsed.NotAfter) - test.validity; d > time.Minute || d < -time.Minute {
+				t.Fatalf("unexpected validity, not after %v", parsed.NotAfter)
+			}
+			if len(test.dns) > 0 && (len(parsed.DNSNames) != 1 || parsed.DNSNames[0] != test.dns) {
+				t.Fatalf("unexpected dns names %v", parsed.DNSNames)
+			}
+			if len(test.ip) > 0 && (len(parsed.IPAddresses) != 1 || parsed.IPAddresses[0].String() != test.ip) {
+				t.Fatalf("unexpected ip addresses %v", parsed.IPAddresses)
+			}
+		})
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := EncodePrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		pem  []byte
+		ok   bool
+	}{
+		{"pkcs8", pkcs8, true},
+		{"pkcs1", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), true},
+		{"sec1", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}), true},
+		{"not pem", []byte("not a key"), false},
+		{"garbage", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("garbage")}), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := ParsePrivateKey(test.pem)
+			if (err == nil) != test.ok {
+				t.Fatalf("got error %v, want ok %v", err, test.ok)
+			}
+			if test.ok && key.Public() == nil {
+				t.Fatal("empty public key")
+			}
+		})
+	}
+}
+
+func TestParseSerial(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"0a:1b:2c", "0a1b2c", true},
+		{" 0A1B2C ", "0a1b2c", true},
+		{"00:0a", "0a", true},
+		{"", "", false},
+		{"0", "", false},
+		{"xyz", "", false},
+	}
+	for _, test := range tests {
+		got, err := ParseSerial(test.in)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("ParseSerial(%q) = %q, %v; want %q, ok %v", test.in, got, err, test.want, test.ok)
+		}
+	}
+}
+
+// 由authority签发吊销serials的CRL并写入文件
+func writeTestCRL(t *testing.T, authority *Authority, serials []*big.Int, asPEM bool) string {
+	t.Helper()
+	now := time.Now()
+	var revoked []pkix.RevokedCertificate
+	for _, serial := range serials {
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: serial, RevocationTime: now})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              big.NewInt(1),
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(time.Hour),
+		RevokedCertificates: revoked,
+	}, authority.Cert, authority.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := der
+	if asPEM {
+		raw = pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	}
+	file := filepath.Join(t.TempDir(), "ca.crl")
+	if err = ioutil.WriteFile(file, raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadCRL(t *testing.T) {
+	authority := newTestAuthority(t, 24*time.Hour)
+	other := newTestAuthority(t, 24*time.Hour)
+	_, _, revoked, err := authority.IssueClient("revoked", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, valid, err := authority.IssueClient("valid", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		signer  *Authority
+		pem     bool
+		issuers []*x509.Certificate
+		ok      bool
+	}{
+		{"pem", authority, true, []*x509.Certificate{authority.Cert}, true},
+		{"der", authority, false, []*x509.Certificate{authority.Cert}, true},
+		{"any trusted issuer", authority, true, []*x509.Certificate{other.Cert, authority.Cert}, true},
+		{"untrusted issuer", other, true, []*x509.Certificate{authority.Cert}, false},
+		{"no issuers", authority, true, nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := writeTestCRL(t, test.signer, []*big.Int{revoked.SerialNumber}, test.pem)
+			crl, err := LoadCRL(file, test.issuers)
+			if (err == nil) != test.ok {
+				t.Fatalf("got error %v, want ok %v", err, test.ok)
+			}
+			if !test.ok {
+				return
+			}
+			if _, ok := crl.Serials[SerialString(revoked.SerialNumber)]; !ok {
+				t.Fatal("revoked serial is missing")
+			}
+			if _, ok := crl.Serials[SerialString(valid.SerialNumber)]; ok {
+				t.Fatal("valid serial is listed")
+			}
+			if crl.NextUpdate.Before(crl.ThisUpdate) {
+				t.Fatalf("unexpected update times %v, %v", crl.ThisUpdate, crl.NextUpdate)
+			}
+		})
+	}
+	if _, err = LoadCRL(filepath.Join(t.TempDir(), "missing.crl"), []*x509.Certificate{authority.Cert}); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,58 @@
+package ca
+
+import (
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// CRL 已解析并验证签名的证书吊销列表
+type CRL struct {
+	Serials    map[string]struct{} // 已吊销的十六进制序列号
+	ThisUpdate time.Time
+	NextUpdate time.Time
+}
+
+// LoadCRL 读取PEM或DER格式的CRL文件，并使用issuers中的任一CA证书验证其签名
+func LoadCRL(file string, issuers []*x509.Certificate) (*CRL, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	list, err := x509.ParseCRL(raw)
+	if err != nil {
+		return nil, err
+	}
+	verified := false
+	for _, issuer := range issuers {
+		if issuer.CheckCRLSignature(list) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("crl is not signed by a trusted CA")
+	}
+	crl := &CRL{
+		Serials:    make(map[string]struct{}, len(list.TBSCertList.RevokedCertificates)),
+		ThisUpdate: list.TBSCertList.ThisUpdate,
+		NextUpdate: list.TBSCertList.NextUpdate,
+	}
+	for _, revoked := range list.TBSCertList.RevokedCertificates {
+		crl.Serials[SerialString(revoked.SerialNumber)] = struct{}{}
+	}
+	return crl, nil
+}
+
+// ParseSerial 将十六进制序列号（可含冒号分隔）规范化
+func ParseSerial(s string) (string, error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ":", "")
+	serial, ok := new(big.Int).SetString(s, 16)
+	if !ok || serial.Sign() <= 0 {
+		return "", errors.New("invalid serial number")
+	}
+	return SerialString(serial), nil
+}
@@ -0,0 +1,69 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"strings"
+
+	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Encrypt 使用指定AEAD算法加密，输出格式为 nonce|密文
+func Encrypt(algorithm string, key []byte, plain []byte, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomBytes(aead.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, aad), nil
+}
+
+// Decrypt 使用指定AEAD算法解密，输入格式为 nonce|密文
+func Decrypt(algorithm string, key []byte, sealed []byte, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, errors.InvalidCiphertext
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, errors.InvalidCiphertext
+	}
+	return plain, nil
+}
+
+// 仅支持AEAD算法：CBC、CTR、XTS等无认证的分组模式密文可被篡改，不用于加解密服务
+// 密钥长度不符（如非对称、HMAC密钥）同样视为不支持的算法
+func newAEAD(algorithm string, key []byte) (cipher.AEAD, error) {
+	switch keeper.Algorithm(strings.ToLower(algorithm)) {
+	case keeper.AlgorithmChaCha20Poly1305:
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return nil, errors.UnsupportedAlgorithm
+		}
+		return aead, nil
+	case keeper.AlgorithmAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.UnsupportedAlgorithm
+		}
+		return cipher.NewGCM(block)
+	}
+	return nil, errors.UnsupportedAlgorithm
+}
+
+func randomBytes(length int) ([]byte, error) {
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,85 @@
+package crypt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/RicheyJang/key_keeper/utils/errors"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	cases := []struct {
+		name      string
+		algorithm string
+		keyLength int
+		aad       []byte
+	}{
+		{"aes-128-gcm", "aes-gcm", 16, nil},
+		{"aes-192-gcm", "aes-gcm", 24, nil},
+		{"aes-256-gcm with aad", "AES-GCM", 32, []byte("header")},
+		{"chacha20-poly1305", "chacha20-poly1305", 32, nil},
+		{"chacha20-poly1305 with aad", "chacha20-poly1305", 32, []byte("header")},
+	}
+	plain := []byte("key keeper")
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			key := bytes.Repeat([]byte{7}, c.keyLength)
+			sealed, err := Encrypt(c.algorithm, key, plain, c.aad)
+			if err != nil {
+				t.Fatalf("encrypt: %v", err)
+			}
+			got, err := Decrypt(c.algorithm, key, sealed, c.aad)
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatalf("got %q, want %q", got, plain)
+			}
+			// 篡改密文或附加认证数据均须解密失败
+			sealed[len(sealed)-1] ^= 1
+			if _, err = Decrypt(c.algorithm, key, sealed, c.aad); err != errors.InvalidCiphertext {
+				t.Fatalf("tampered ciphertext: got %v", err)
+			}
+			sealed[len(sealed)-1] ^= 1
+			if _, err = Decrypt(c.algorithm, key, sealed, append(c.aad, 'x')); err != errors.InvalidCiphertext {
+				t.Fatalf("wrong aad: got %v", err)
+			}
+		})
+	}
+}
+
+func TestEncryptUnsupported(t *testing.T) {
+	cases := []struct {
+		name      string
+		algorithm string
+		keyLength int
+	}{
+		{"cbc", "aes-cbc", 32},
+		{"ctr", "aes-ctr", 32},
+		{"xts", "aes-xts", 64},
+		{"hmac", "hmac-sha256", 32},
+		{"gcm with bad key length", "aes-gcm", 20},
+		{"chacha with bad key length", "chacha20-poly1305", 16},
+		{"asymmetric key", "ed25519", 48},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			key := make([]byte, c.keyLength)
+			if _, err := Encrypt(c.algorithm, key, []byte("plain"), nil); err != errors.UnsupportedAlgorithm {
+				t.Fatalf("encrypt: got %v", err)
+			}
+			if _, err := Decrypt(c.algorithm, key, make([]byte, 64), nil); err != errors.UnsupportedAlgorithm {
+				t.Fatalf("decrypt: got %v", err)
+			}
+		})
+	}
+}
+
+func TestDecryptShortCiphertext(t *testing.T) {
+	key := make([]byte, 32)
+	for _, algorithm := range []string{"aes-gcm", "chacha20-poly1305"} {
+		if _, err := Decrypt(algorithm, key, make([]byte, 8), nil); err != errors.InvalidCiphertext {
+			t.Fatalf("%s: got %v", algorithm, err)
+		}
+	}
+}
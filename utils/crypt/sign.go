@@ -0,0 +1,84 @@
+package crypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"hash"
+	"strings"
+
+	"github.com/RicheyJang/key_keeper/keeper"
+	"github.com/RicheyJang/key_keeper/utils/errors"
+)
+
+// Sign 签名：HMAC算法使用原始密钥；非对称算法使用PKCS#8格式私钥
+func Sign(algorithm string, key []byte, message []byte) ([]byte, error) {
+	if newHash, ok := hmacHash(algorithm); ok {
+		mac := hmac.New(newHash, key)
+		_, _ = mac.Write(message)
+		return mac.Sum(nil), nil
+	}
+	private, err := x509.ParsePKCS8PrivateKey(key)
+	if err != nil {
+		return nil, errors.UnsupportedAlgorithm
+	}
+	switch private := private.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(private, message), nil
+	case *ecdsa.PrivateKey:
+		return ecdsa.SignASN1(rand.Reader, private, ecdsaDigest(private.PublicKey, message))
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256(message)
+		return rsa.SignPSS(rand.Reader, private, crypto.SHA256, digest[:], nil)
+	}
+	return nil, errors.UnsupportedAlgorithm
+}
+
+// Verify 验签：HMAC算法使用原始密钥；非对称算法使用PKCS#8格式私钥所对应的公钥
+func Verify(algorithm string, key []byte, message []byte, signature []byte) (bool, error) {
+	if newHash, ok := hmacHash(algorithm); ok {
+		mac := hmac.New(newHash, key)
+		_, _ = mac.Write(message)
+		return hmac.Equal(mac.Sum(nil), signature), nil
+	}
+	private, err := x509.ParsePKCS8PrivateKey(key)
+	if err != nil {
+		return false, errors.UnsupportedAlgorithm
+	}
+	switch private := private.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Verify(private.Public().(ed25519.PublicKey), message, signature), nil
+	case *ecdsa.PrivateKey:
+		return ecdsa.VerifyASN1(&private.PublicKey, ecdsaDigest(private.PublicKey, message), signature), nil
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256(message)
+		return rsa.VerifyPSS(&private.PublicKey, crypto.SHA256, digest[:], signature, nil) == nil, nil
+	}
+	return false, errors.UnsupportedAlgorithm
+}
+
+func hmacHash(algorithm string) (func() hash.Hash, bool) {
+	switch keeper.Algorithm(strings.ToLower(algorithm)) {
+	case keeper.AlgorithmHMACSHA256:
+		return sha256.New, true
+	case keeper.AlgorithmHMACSHA512:
+		return sha512.New, true
+	}
+	return nil, false
+}
+
+// 根据曲线选择摘要算法：P-384使用SHA-384，其余使用SHA-256
+func ecdsaDigest(public ecdsa.PublicKey, message []byte) []byte {
+	if public.Curve.Params().BitSize > 256 {
+		digest := sha512.Sum384(message)
+		return digest[:]
+	}
+	digest := sha256.Sum256(message)
+	return digest[:]
+}
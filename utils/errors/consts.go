@@ -13,6 +13,12 @@ const (
 	CodeInstanceExist  = 10008
 	CodeInstanceFrozen = 10009
 	CodeKeeperSupport  = 10010
+	CodeSealed         = 10011
+	CodeOperation      = 10012
+	CodeTwoFactor      = 10013
+	CodeUserLocked     = 10014
+	CodeRateLimited    = 10015
+	CodeNotReady       = 10016
 )
 
 var (
@@ -22,11 +28,45 @@ var (
 	InvalidKeeper    = New(CodeRequest, "invalid keeper")
 	WrongPasswd      = New(CodeWrongPasswd, "wrong password")
 	UserFrozen       = New(CodeUserFrozen, "user is frozen")
+	UserLocked       = New(CodeUserLocked, "too many failed login attempts, try again later")
 	InvalidToken     = New(CodeNeedLogin, "invalid token")
 	PermissionDeny   = New(CodePermission, "permission deny")
 	UserExist        = New(CodeUserExist, "user already exist")
+	ExternalUser     = New(CodeRequest, "password of external user is managed by its directory")
 	NoSuchInstance   = New(CodeRequest, "no such instance")
 	InstanceExist    = New(CodeInstanceExist, "instance identifier already exist")
 	InstanceFrozen   = New(CodeInstanceFrozen, "current instance has been frozen")
+	RateLimited      = New(CodeRateLimited, "too many requests, try again later")
 	KeeperNotSupport = New(CodeKeeperSupport, "current keeper not support this operation")
+
+	UnsupportedAlgorithm = New(CodeRequest, "unsupported algorithm")
+	InvalidKeyLength     = New(CodeRequest, "invalid key length for this algorithm")
+	InvalidCiphertext    = New(CodeRequest, "invalid ciphertext")
+	EscrowUnavailable    = New(CodeRequest, "key escrow requires seal mode")
+
+	Sealed       = New(CodeSealed, "key keeper is sealed")
+	InvalidShare = New(CodeRequest, "invalid unseal share")
+
+	NoSuchOperation   = New(CodeOperation, "no such operation")
+	OperationNotPend  = New(CodeOperation, "operation is not pending")
+	OperationExpired  = New(CodeOperation, "operation has expired")
+	SelfApproveForbid = New(CodeOperation, "cannot approve your own operation")
+	OperationNotAppr  = New(CodeOperation, "operation is not approved or has been used")
+
+	InvalidTOTPCode    = New(CodeTwoFactor, "invalid two-factor code")
+	InvalidChallenge   = New(CodeNeedLogin, "invalid or expired login challenge")
+	TwoFactorNotEnroll = New(CodeTwoFactor, "two-factor authentication is not enrolled")
+	TwoFactorEnrolled  = New(CodeTwoFactor, "two-factor authentication is already enabled")
+	TwoFactorRequired  = New(CodeTwoFactor, "two-factor authentication is required for this level")
+
+	OIDCNotEnabled = New(CodeRequest, "oidc login is not enabled")
+	NoSuchToken    = New(CodeRequest, "no such api token")
+	NoSuchSession  = New(CodeRequest, "no such session")
+
+	CANotEnabled = New(CodeRequest, "certificate authority is not enabled")
+	NoSuchCert   = New(CodeRequest, "no such certificate")
+	CertRevoked  = New(CodePermission, "client certificate is revoked or expired")
+	NoClientCert = New(CodePermission, "client certificate is required")
+
+	NotReady = New(CodeNotReady, "key keeper is not ready")
 )
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"time"
 
+	"github.com/RicheyJang/key_keeper/utils/metrics"
+	"github.com/RicheyJang/key_keeper/utils/tracing"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 	gormlogger "gorm.io/gorm/logger"
@@ -28,20 +30,26 @@ func (l *loggerGorm) LogMode(gormlogger.LogLevel) gormlogger.Interface {
 }
 
 func (l *loggerGorm) Info(ctx context.Context, s string, args ...interface{}) {
-	log.WithContext(ctx).Infof(s, args)
+	log.WithContext(ctx).Infof(s, args...)
 }
 
 func (l *loggerGorm) Warn(ctx context.Context, s string, args ...interface{}) {
-	log.WithContext(ctx).Warnf(s, args)
+	log.WithContext(ctx).Warnf(s, args...)
 }
 
 func (l *loggerGorm) Error(ctx context.Context, s string, args ...interface{}) {
-	log.WithContext(ctx).Errorf(s, args)
+	log.WithContext(ctx).Errorf(s, args...)
 }
 
 func (l *loggerGorm) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
 	elapsed := time.Since(begin)
-	sql, _ := fc()
+	sql, rows := fc()
+	queryErr := err
+	if errors.Is(err, gorm.ErrRecordNotFound) { // 未找到记录不视为查询出错
+		queryErr = nil
+	}
+	metrics.ObserveQuery(sql, elapsed, queryErr)
+	tracing.TraceQuery(ctx, begin, sql, rows, queryErr)
 	fields := log.Fields{}
 	if l.SourceField != "" {
 		fields[l.SourceField] = utils.FileWithLineNum()
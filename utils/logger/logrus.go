@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/RicheyJang/key_keeper/utils/tracing"
 	"github.com/kataras/iris/v12"
 	requestLogger "github.com/kataras/iris/v12/middleware/logger"
 	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
@@ -85,6 +86,12 @@ func (f SimpleFormatter) Format(entry *log.Entry) ([]byte, error) {
 	output.WriteRune('[')
 	output.WriteString(entry.Level.String())
 	output.WriteRune(']')
+	// 链路追踪ID
+	if traceID := tracing.TraceID(entry.Context); len(traceID) > 0 {
+		output.WriteRune('[')
+		output.WriteString(traceID)
+		output.WriteRune(']')
+	}
 	// 消息
 	output.WriteString(stringOfStarter)
 	output.WriteString(entry.Message)
@@ -104,28 +111,21 @@ func (f SimpleFormatter) Format(entry *log.Entry) ([]byte, error) {
 
 func Iris(prefix string) iris.Handler {
 	return requestLogger.New(requestLogger.Config{
-		Status:     true,
-		IP:         true,
-		Method:     true,
-		Path:       true,
-		Query:      true,
-		LogFunc:    getIrisLoggerFunc(prefix),
-		LogFuncCtx: nil,
+		LogFunc:    nil,
+		LogFuncCtx: getIrisLoggerFunc(prefix), // 携带请求上下文，以便记录链路追踪ID
 		Skippers:   nil,
 	})
 }
 
-func getIrisLoggerFunc(prefix string) func(endTime time.Time, latency time.Duration,
-	status, ip, method, path string, message interface{}, headerMessage interface{}) {
-	fmtStr := prefix + " %3v | %13v | %15v | %-7v  %#v | msg: %v"
-	return func(endTime time.Time, latency time.Duration, status, ip, method, path string, message interface{}, headerMessage interface{}) {
-		log.Infof(fmtStr,
-			status,
+func getIrisLoggerFunc(prefix string) func(ctx iris.Context, latency time.Duration) {
+	fmtStr := prefix + " %3v | %13v | %15v | %-7v  %#v"
+	return func(ctx iris.Context, latency time.Duration) {
+		log.WithContext(ctx.Request().Context()).Infof(fmtStr,
+			ctx.GetStatusCode(),
 			latency,
-			ip,
-			method,
-			path,
-			message,
+			ctx.RemoteAddr(),
+			ctx.Method(),
+			ctx.Request().URL.RequestURI(),
 		)
 	}
 }
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "kk"
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by server, route and status.",
+	}, []string{"server", "method", "route", "status"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by server and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"server", "method", "route"})
+	keyFetches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "key_fetches_total",
+		Help:      "Number of keys fetched through the inner API by instance and key ID.",
+	}, []string{"instance", "key"})
+	apiErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_errors_total",
+		Help:      "Number of API errors by error code.",
+	}, []string{"code"})
+	logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Number of login attempts by result.",
+	}, []string{"result"})
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of database queries by statement type.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"statement", "result"})
+)
+
+// Handler 指标导出处理函数
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Register 注册自定义指标，重复注册时忽略
+func Register(collectors ...prometheus.Collector) {
+	for _, c := range collectors {
+		if err := prometheus.Register(c); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+				panic(err)
+			}
+		}
+	}
+}
+
+// Iris 记录请求数及延迟的中间件，以路由模板而非实际路径区分请求
+func Iris(server string) iris.Handler {
+	return func(ctx iris.Context) {
+		start := time.Now()
+		ctx.Next()
+		route := "unknown"
+		if r := ctx.GetCurrentRoute(); r != nil {
+			route = r.Path()
+		}
+		method := ctx.Method()
+		requests.WithLabelValues(server, method, route, strconv.Itoa(ctx.GetStatusCode())).Inc()
+		requestDuration.WithLabelValues(server, method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveKeyFetch 记录一次密钥获取
+func ObserveKeyFetch(instance string, id uint) {
+	keyFetches.WithLabelValues(instance, strconv.FormatUint(uint64(id), 10)).Inc()
+}
+
+// ObserveError 记录一次API错误
+func ObserveError(code int) {
+	apiErrors.WithLabelValues(strconv.Itoa(code)).Inc()
+}
+
+// ObserveLogin 记录一次登录结果
+func ObserveLogin(success bool) {
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	logins.WithLabelValues(result).Inc()
+}
+
+// ObserveQuery 记录一次数据库查询，按语句首个关键字分类
+func ObserveQuery(sql string, elapsed time.Duration, err error) {
+	statement := "other"
+	if fields := strings.Fields(sql); len(fields) > 0 {
+		switch keyword := strings.ToLower(fields[0]); keyword {
+		case "select", "insert", "update", "delete":
+			statement = keyword
+		}
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	queryDuration.WithLabelValues(statement, result).Observe(elapsed.Seconds())
+}
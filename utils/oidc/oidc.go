@@ -0,0 +1,399 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/jwt"
+)
+
+// OpenID Connect 授权码流程的最小实现：发现、授权地址、code换取token、ID Token验证
+
+var (
+	ErrInvalidIDToken = errors.New("oidc: invalid id token")
+	ErrUnknownKey     = errors.New("oidc: unknown signing key")
+)
+
+const (
+	clockSkew      = time.Minute      // 允许的时钟偏差
+	jwksMinRefresh = 10 * time.Second // 未知kid时重新拉取JWKS的最小间隔
+)
+
+// Config OIDC客户端配置
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	HTTPClient   *http.Client // 为空时使用默认客户端
+}
+
+// Provider OIDC身份提供方
+type Provider struct {
+	config Config
+
+	lock      sync.Mutex
+	discovery *discovery
+	keys      map[string]keyEntry // kid -> 公钥
+	keysAt    time.Time
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type keyEntry struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// Token 令牌端点的响应
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// New 创建Provider，发现文档将在首次使用时拉取
+func New(config Config) (*Provider, error) {
+	if len(config.Issuer) == 0 || len(config.ClientID) == 0 || len(config.RedirectURL) == 0 {
+		return nil, errors.New("oidc: issuer, client id and redirect url are required")
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid"}
+	}
+	return &Provider{config: config}, nil
+}
+
+// AuthCodeURL 生成授权地址，verifier为PKCE校验码
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// Exchange 使用授权码换取token
+func (p *Provider) Exchange(code, verifier string) (*Token, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	var token Token
+	if err = p.doJSON(req, &token); err != nil {
+		return nil, err
+	}
+	if len(token.IDToken) == 0 {
+		return nil, errors.New("oidc: token response without id_token")
+	}
+	return &token, nil
+}
+
+// VerifyIDToken 验证ID Token的签名、签发者、受众、有效期及nonce，返回全部claims
+func (p *Provider) VerifyIDToken(raw string, nonce string) (map[string]interface{}, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	// 根据头部的alg及kid选取公钥，验证签名及有效期
+	verified, err := jwt.VerifyWithHeaderValidator(nil, nil, []byte(raw), p.resolveKey, jwt.TokenValidatorFunc(allowSkew))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	var claims map[string]interface{}
+	if err = verified.Claims(&claims); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	// 签名库仅在exp存在时检查有效期，ID Token必须携带exp
+	if verified.StandardClaims.Expiry <= 0 {
+		return nil, fmt.Errorf("%w: missing exp", ErrInvalidIDToken)
+	}
+	// 验证签发者、受众及nonce
+	if iss, _ := claims["iss"].(string); iss != d.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, iss)
+	}
+	if !containsString(claims["aud"], p.config.ClientID) {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidIDToken)
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// 解析ID Token头部并选取对应的算法及公钥，仅接受非对称算法
+func (p *Provider) resolveKey(_ string, headerDecoded []byte) (jwt.Alg, jwt.PublicKey, error) {
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerDecoded, &header); err != nil {
+		return nil, nil, ErrInvalidIDToken
+	}
+	alg := asymmetricAlg(header.Alg)
+	if alg == nil {
+		return nil, nil, jwt.ErrTokenAlg
+	}
+	key, err := p.getKey(header.Kid, header.Alg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return alg, key, nil
+}
+
+// 容忍签发时间略晚于本地时间
+func allowSkew(_ []byte, claims jwt.Claims, err error) error {
+	if err == jwt.ErrIssuedInTheFuture && claims.IssuedAt-time.Now().Unix() <= int64(clockSkew/time.Second) {
+		return nil
+	}
+	return err
+}
+
+func (p *Provider) getDiscovery() (*discovery, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var d discovery
+	if err = p.doJSON(req, &d); err != nil {
+		return nil, err
+	}
+	if d.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("oidc: issuer mismatch, expected %q but got %q", p.config.Issuer, d.Issuer)
+	}
+	if len(d.AuthorizationEndpoint) == 0 || len(d.TokenEndpoint) == 0 || len(d.JWKSURI) == 0 {
+		return nil, errors.New("oidc: incomplete discovery document")
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// 获取签名公钥，kid未知时重新拉取JWKS
+func (p *Provider) getKey(kid, alg string) (crypto.PublicKey, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	entry, ok := p.findKey(kid, alg)
+	if !ok && time.Since(p.keysAt) > jwksMinRefresh {
+		if err = p.refreshKeys(d.JWKSURI); err != nil {
+			return nil, err
+		}
+		entry, ok = p.findKey(kid, alg)
+	}
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return entry.key, nil
+}
+
+func (p *Provider) findKey(kid, alg string) (keyEntry, bool) {
+	if len(kid) > 0 {
+		entry, ok := p.keys[kid]
+		return entry, ok && (len(entry.alg) == 0 || entry.alg == alg)
+	}
+	if len(p.keys) == 1 { // 无kid时仅允许唯一公钥
+		for _, entry := range p.keys {
+			return entry, len(entry.alg) == 0 || entry.alg == alg
+		}
+	}
+	return keyEntry{}, false
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (p *Provider) refreshKeys(uri string) error {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err = p.doJSON(req, &set); err != nil {
+		return err
+	}
+	keys := make(map[string]keyEntry)
+	for _, k := range set.Keys {
+		if len(k.Use) > 0 && k.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(k)
+		if err != nil {
+			continue // 忽略不支持的公钥
+		}
+		keys[k.Kid] = keyEntry{alg: k.Alg, key: key}
+	}
+	p.keys, p.keysAt = keys, time.Now()
+	return nil
+}
+
+func parseJWK(k jsonWebKey) (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("oidc: invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("oidc: unsupported curve")
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("oidc: invalid ec point")
+		}
+		return key, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("oidc: unsupported okp key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errors.New("oidc: unsupported key type")
+}
+
+func asymmetricAlg(name string) jwt.Alg {
+	switch name {
+	case "RS256":
+		return jwt.RS256
+	case "RS384":
+		return jwt.RS384
+	case "RS512":
+		return jwt.RS512
+	case "PS256":
+		return jwt.PS256
+	case "PS384":
+		return jwt.PS384
+	case "PS512":
+		return jwt.PS512
+	case "ES256":
+		return jwt.ES256
+	case "ES384":
+		return jwt.ES384
+	case "ES512":
+		return jwt.ES512
+	case "EdDSA":
+		return jwt.EdDSA
+	}
+	return nil
+}
+
+func (p *Provider) doJSON(req *http.Request, dest interface{}) error {
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %v %v returned %v: %s", req.Method, req.URL.Path, resp.Status, body)
+	}
+	return json.Unmarshal(body, dest)
+}
+
+func containsString(value interface{}, target string) bool {
+	switch v := value.(type) {
+	case string:
+		return v == target
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == target {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kataras/jwt"
+)
+
+// 测试用身份提供方：提供发现文档及JWKS
+type testIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(discovery{
+			Issuer:                issuer.server.URL,
+			AuthorizationEndpoint: issuer.server.URL + "/authorize",
+			TokenEndpoint:         issuer.server.URL + "/token",
+			JWKSURI:               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []jsonWebKey{{
+				Kty: "RSA",
+				Kid: "k1",
+				Use: "sig",
+				Alg: "RS256",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (issuer *testIssuer) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	header := map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid}
+	token, err := jwt.SignWithHeader(jwt.RS256, issuer.key, claims, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(token)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider, err := New(Config{Issuer: issuer.server.URL, ClientID: "kk", RedirectURL: "https://localhost/cb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   issuer.server.URL,
+			"aud":   "kk",
+			"sub":   "alice",
+			"nonce": "n1",
+			"iat":   now,
+			"exp":   now + 300,
+		}
+	}
+	tests := []struct {
+		name   string
+		kid    string
+		modify func(claims map[string]interface{})
+		nonce  string
+		ok     bool
+	}{
+		{"valid", "k1", func(map[string]interface{}) {}, "n1", true},
+		{"audience array", "k1", func(c map[string]interface{}) { c["aud"] = []string{"other", "kk"} }, "n1", true},
+		{"nonce mismatch", "k1", func(map[string]interface{}) {}, "n2", false},
+		{"missing nonce", "k1", func(c map[string]interface{}) { delete(c, "nonce") }, "n1", false},
+		{"audience mismatch", "k1", func(c map[string]interface{}) { c["aud"] = "other" }, "n1", false},
+		{"missing audience", "k1", func(c map[string]interface{}) { delete(c, "aud") }, "n1", false},
+		{"issuer mismatch", "k1", func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, "n1", false},
+		{"expired", "k1", func(c map[string]interface{}) { c["iat"], c["exp"] = now-600, now-300 }, "n1", false},
+		{"missing exp", "k1", func(c map[string]interface{}) { delete(c, "exp") }, "n1", false},
+		{"issued in the future", "k1", func(c map[string]interface{}) { c["iat"] = now + 600 }, "n1", false},
+		{"unknown key", "k2", func(map[string]interface{}) {}, "n1", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := valid()
+			test.modify(claims)
+			got, err := provider.VerifyIDToken(issuer.sign(t, test.kid, claims), test.nonce)
+			if test.ok {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got["sub"] != "alice" {
+					t.Fatalf("unexpected claims: %v", got)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !errors.Is(err, ErrInvalidIDToken) && !errors.Is(err, ErrUnknownKey) {
+				t.Fatalf("unexpected error type: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejectsSymmetricAlg(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider, err := New(Config{Issuer: issuer.server.URL, ClientID: "kk", RedirectURL: "https://localhost/cb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 以HS256签名，即使密钥可被猜测也不应被接受
+	now := time.Now().Unix()
+	token, err := jwt.SignWithHeader(jwt.HS256, []byte("secret"), map[string]interface{}{
+		"iss": issuer.server.URL, "aud": "kk", "nonce": "n1", "iat": now, "exp": now + 300,
+	}, map[string]string{"alg": "HS256", "typ": "JWT", "kid": "k1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = provider.VerifyIDToken(string(token), "n1"); err == nil {
+		t.Fatal("expected HS256 id token to be rejected")
+	}
+}
@@ -0,0 +1,121 @@
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+)
+
+// 每份分片的格式为：各字节多项式取值 | x坐标(1字节)
+
+var (
+	ErrInvalidParts  = errors.New("shamir: parts must be at least threshold and at most 255")
+	ErrInvalidSecret = errors.New("shamir: secret is empty")
+	ErrInvalidShares = errors.New("shamir: invalid shares")
+)
+
+// Split 将secret拆分为parts份，任意threshold份即可恢复
+func Split(secret []byte, parts, threshold int) ([][]byte, error) {
+	if parts < threshold || parts > 255 || threshold < 2 {
+		return nil, ErrInvalidParts
+	}
+	if len(secret) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	// 为每份分片分配互不相同的x坐标（1~255）
+	xs, err := randomCoordinates(parts)
+	if err != nil {
+		return nil, err
+	}
+	shares := make([][]byte, parts)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = xs[i]
+	}
+	// 对secret的每个字节构建 threshold-1 次随机多项式
+	coefficients := make([]byte, threshold)
+	for index, b := range secret {
+		coefficients[0] = b
+		if _, err = rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			shares[i][index] = evaluate(coefficients, xs[i])
+		}
+	}
+	return shares, nil
+}
+
+// Combine 使用拉格朗日插值由若干份分片恢复secret
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, ErrInvalidShares
+	}
+	length := len(shares[0])
+	if length < 2 {
+		return nil, ErrInvalidShares
+	}
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool)
+	for i, share := range shares {
+		if len(share) != length {
+			return nil, ErrInvalidShares
+		}
+		x := share[length-1]
+		if x == 0 || seen[x] {
+			return nil, ErrInvalidShares
+		}
+		seen[x] = true
+		xs[i] = x
+	}
+	secret := make([]byte, length-1)
+	ys := make([]byte, len(shares))
+	for index := range secret {
+		for i, share := range shares {
+			ys[i] = share[index]
+		}
+		secret[index] = interpolateAtZero(xs, ys)
+	}
+	return secret, nil
+}
+
+func randomCoordinates(parts int) ([]byte, error) {
+	var all [255]byte
+	for i := range all {
+		all[i] = byte(i + 1)
+	}
+	// Fisher-Yates 洗牌：rand.Int保证均匀分布，避免取模偏差
+	for i := len(all) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return nil, err
+		}
+		all[i], all[j.Int64()] = all[j.Int64()], all[i]
+	}
+	return all[:parts], nil
+}
+
+// 秦九韶算法求多项式在x处的取值
+func evaluate(coefficients []byte, x byte) byte {
+	var res byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		res = add(mul(res, x), coefficients[i])
+	}
+	return res
+}
+
+func interpolateAtZero(xs, ys []byte) byte {
+	var res byte
+	for i := range xs {
+		basis := byte(1)
+		for j := range xs {
+			if i == j {
+				continue
+			}
+			// basis *= x_j / (x_j - x_i)
+			basis = mul(basis, div(xs[j], add(xs[j], xs[i])))
+		}
+		res = add(res, mul(ys[i], basis))
+	}
+	return res
+}
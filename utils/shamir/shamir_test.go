@@ -0,0 +1,104 @@
+package shamir
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitCombine(t *testing.T) {
+	cases := []struct {
+		name      string
+		parts     int
+		threshold int
+		secret    []byte
+	}{
+		{"2 of 2", 2, 2, []byte("root key")},
+		{"3 of 5", 5, 3, bytes.Repeat([]byte{0xAB}, 32)},
+		{"5 of 5", 5, 5, []byte{0}},
+		{"2 of 255", 255, 2, []byte("secret")},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			shares, err := Split(c.secret, c.parts, c.threshold)
+			if err != nil {
+				t.Fatalf("split: %v", err)
+			}
+			if len(shares) != c.parts {
+				t.Fatalf("got %d shares, want %d", len(shares), c.parts)
+			}
+			// 任意连续threshold份均可恢复
+			for start := 0; start+c.threshold <= c.parts; start++ {
+				got, err := Combine(shares[start : start+c.threshold])
+				if err != nil {
+					t.Fatalf("combine: %v", err)
+				}
+				if !bytes.Equal(got, c.secret) {
+					t.Fatalf("shares %d..%d recovered %x, want %x", start, start+c.threshold, got, c.secret)
+				}
+			}
+			// 不足threshold份无法恢复
+			if c.threshold > 2 {
+				got, err := Combine(shares[:c.threshold-1])
+				if err == nil && bytes.Equal(got, c.secret) {
+					t.Fatal("secret recovered from fewer shares than threshold")
+				}
+			}
+		})
+	}
+}
+
+func TestSplitInvalid(t *testing.T) {
+	cases := []struct {
+		name      string
+		parts     int
+		threshold int
+		secret    []byte
+		err       error
+	}{
+		{"threshold greater than parts", 2, 3, []byte("s"), ErrInvalidParts},
+		{"threshold less than 2", 3, 1, []byte("s"), ErrInvalidParts},
+		{"too many parts", 256, 2, []byte("s"), ErrInvalidParts},
+		{"empty secret", 3, 2, nil, ErrInvalidSecret},
+	}
+	for _, c := range cases {
+		if _, err := Split(c.secret, c.parts, c.threshold); err != c.err {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.err)
+		}
+	}
+}
+
+func TestCombineInvalid(t *testing.T) {
+	shares, err := Split([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		shares [][]byte
+	}{
+		{"single share", shares[:1]},
+		{"duplicate coordinate", [][]byte{shares[0], shares[0]}},
+		{"length mismatch", [][]byte{shares[0], shares[1][1:]}},
+		{"zero coordinate", [][]byte{shares[0], append(append([]byte{}, shares[1][:len(shares[1])-1]...), 0)}},
+		{"too short", [][]byte{{1}, {2}}},
+	}
+	for _, c := range cases {
+		if _, err := Combine(c.shares); err != ErrInvalidShares {
+			t.Errorf("%s: got %v, want %v", c.name, err, ErrInvalidShares)
+		}
+	}
+}
+
+func TestRandomCoordinates(t *testing.T) {
+	xs, err := randomCoordinates(255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[byte]bool)
+	for _, x := range xs {
+		if x == 0 || seen[x] {
+			t.Fatalf("invalid or duplicate coordinate %d", x)
+		}
+		seen[x] = true
+	}
+}
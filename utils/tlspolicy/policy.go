@@ -0,0 +1,124 @@
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// Policy TLS策略，空值代表使用Go默认值
+type Policy struct {
+	MinVersion   string   `json:"minVersion"`   // 1.0、1.1、1.2或1.3
+	MaxVersion   string   `json:"maxVersion"`   // 同上
+	CipherSuites []string `json:"cipherSuites"` // IANA名称，如TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256，仅作用于TLS 1.2及以下
+	Curves       []string `json:"curves"`       // X25519、P256、P384或P521
+}
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var curves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// Merge 以override中的非空项覆盖p
+func (p Policy) Merge(override Policy) Policy {
+	if len(override.MinVersion) > 0 {
+		p.MinVersion = override.MinVersion
+	}
+	if len(override.MaxVersion) > 0 {
+		p.MaxVersion = override.MaxVersion
+	}
+	if len(override.CipherSuites) > 0 {
+		p.CipherSuites = override.CipherSuites
+	}
+	if len(override.Curves) > 0 {
+		p.Curves = override.Curves
+	}
+	return p
+}
+
+// Apply 将策略应用至config，策略非法时返回错误且不修改config
+func (p Policy) Apply(config *tls.Config) error {
+	minVersion, err := parseVersion(p.MinVersion)
+	if err != nil {
+		return err
+	}
+	maxVersion, err := parseVersion(p.MaxVersion)
+	if err != nil {
+		return err
+	}
+	if minVersion != 0 && maxVersion != 0 && minVersion > maxVersion {
+		return fmt.Errorf("tls min version %v is greater than max version %v", p.MinVersion, p.MaxVersion)
+	}
+	suites, err := parseCipherSuites(p.CipherSuites)
+	if err != nil {
+		return err
+	}
+	curveIDs, err := parseCurves(p.Curves)
+	if err != nil {
+		return err
+	}
+	config.MinVersion, config.MaxVersion = minVersion, maxVersion
+	config.CipherSuites, config.CurvePreferences = suites, curveIDs
+	return nil
+}
+
+func parseVersion(version string) (uint16, error) {
+	if len(version) == 0 {
+		return 0, nil
+	}
+	v, ok := versions[strings.TrimPrefix(strings.ToUpper(version), "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unknown tls version %v", version)
+	}
+	return v, nil
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	insecure := make(map[string]struct{})
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = struct{}{}
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		if _, ok := insecure[strings.ToUpper(name)]; ok {
+			return nil, fmt.Errorf("insecure tls cipher suite %v", name)
+		}
+		id, ok := known[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown tls cipher suite %v", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func parseCurves(names []string) ([]tls.CurveID, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	ids := make([]tls.CurveID, 0, len(names))
+	for _, name := range names {
+		id, ok := curves[strings.TrimPrefix(strings.ToUpper(name), "CURVE")]
+		if !ok {
+			return nil, fmt.Errorf("unknown tls curve %v", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,66 @@
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		want   *tls.Config
+		ok     bool
+	}{
+		{"empty", Policy{}, &tls.Config{}, true},
+		{"versions", Policy{MinVersion: "1.2", MaxVersion: "TLS1.3"},
+			&tls.Config{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS13}, true},
+		{"same version", Policy{MinVersion: "1.3", MaxVersion: "1.3"},
+			&tls.Config{MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13}, true},
+		{"cipher suites", Policy{CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", "tls_ecdhe_rsa_with_aes_128_gcm_sha256"}},
+			&tls.Config{CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}}, true},
+		{"insecure cipher suite", Policy{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"}}, nil, false},
+		{"curves", Policy{Curves: []string{"x25519", "CurveP256", "P384"}},
+			&tls.Config{CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}}, true},
+		{"unknown min version", Policy{MinVersion: "1.4"}, nil, false},
+		{"unknown max version", Policy{MaxVersion: "ssl3"}, nil, false},
+		{"min above max", Policy{MinVersion: "1.3", MaxVersion: "1.2"}, nil, false},
+		{"unknown cipher suite", Policy{CipherSuites: []string{"TLS_NOT_A_SUITE"}}, nil, false},
+		{"unknown curve", Policy{Curves: []string{"P224"}}, nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// 非法策略不应修改原有配置
+			original := &tls.Config{MinVersion: tls.VersionTLS10, CurvePreferences: []tls.CurveID{tls.CurveP521}}
+			config := original.Clone()
+			err := test.policy.Apply(config)
+			if (err == nil) != test.ok {
+				t.Fatalf("got error %v, want ok %v", err, test.ok)
+			}
+			want := test.want
+			if !test.ok {
+				want = original
+			}
+			if config.MinVersion != want.MinVersion || config.MaxVersion != want.MaxVersion ||
+				!reflect.DeepEqual(config.CipherSuites, want.CipherSuites) ||
+				!reflect.DeepEqual(config.CurvePreferences, want.CurvePreferences) {
+				t.Fatalf("got min %x max %x suites %v curves %v, want min %x max %x suites %v curves %v",
+					config.MinVersion, config.MaxVersion, config.CipherSuites, config.CurvePreferences,
+					want.MinVersion, want.MaxVersion, want.CipherSuites, want.CurvePreferences)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base := Policy{MinVersion: "1.2", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}, Curves: []string{"X25519"}}
+	got := base.Merge(Policy{MinVersion: "1.3", Curves: []string{"P256"}})
+	want := Policy{MinVersion: "1.3", CipherSuites: base.CipherSuites, Curves: []string{"P256"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if got = base.Merge(Policy{}); !reflect.DeepEqual(got, base) {
+		t.Fatalf("empty override changed the policy: %+v", got)
+	}
+}
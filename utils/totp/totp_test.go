@@ -0,0 +1,104 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录B中SHA1的测试向量（取后6位）
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+
+func TestCode(t *testing.T) {
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, c := range cases {
+		code, err := Code(rfcSecret, time.Unix(c.unix, 0))
+		if err != nil {
+			t.Fatalf("%d: %v", c.unix, err)
+		}
+		if code != c.code {
+			t.Errorf("%d: got %s, want %s", c.unix, code, c.code)
+		}
+	}
+}
+
+func TestValidateStep(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / Period
+	cases := []struct {
+		name   string
+		secret string
+		at     time.Time // 生成验证码的时刻
+		skew   int
+		step   int64
+		ok     bool
+	}{
+		{"current", rfcSecret, now, 1, step, true},
+		{"previous step within skew", rfcSecret, now.Add(-Period * time.Second), 1, step - 1, true},
+		{"next step within skew", rfcSecret, now.Add(Period * time.Second), 1, step + 1, true},
+		{"outside skew", rfcSecret, now.Add(-2 * Period * time.Second), 1, 0, false},
+		{"no skew", rfcSecret, now.Add(-Period * time.Second), 0, 0, false},
+		{"lower case secret", strings.ToLower(rfcSecret), now, 0, step, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, c.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := ValidateStep(c.secret, code, now, c.skew)
+			if got != c.step || ok != c.ok {
+				t.Fatalf("got %d, %v; want %d, %v", got, ok, c.step, c.ok)
+			}
+			if Validate(c.secret, code, now, c.skew) != c.ok {
+				t.Fatal("Validate disagrees with ValidateStep")
+			}
+		})
+	}
+}
+
+func TestValidateInvalid(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := Code(rfcSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		secret string
+		code   string
+	}{
+		{"wrong length", rfcSecret, code[:Digits-1]},
+		{"invalid secret", "not base32!", code},
+		{"other secret", "JBSWY3DPEHPK3PXP", code},
+	}
+	for _, c := range cases {
+		if Validate(c.secret, c.code, now, 1) {
+			t.Errorf("%s: should not validate", c.name)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateSecret()
+	if a == b || len(a) != 32 {
+		t.Fatalf("unexpected secrets %q and %q", a, b)
+	}
+	if _, err = Code(a, time.Now()); err != nil {
+		t.Fatalf("generated secret is not usable: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/RicheyJang/key_keeper"
+
+// Option 链路追踪参数
+type Option struct {
+	Endpoint    string            // OTLP/HTTP接收端地址，如localhost:4318
+	URLPath     string            // 接收端路径，为空使用/v1/traces
+	Insecure    bool              // 不使用TLS连接接收端
+	Headers     map[string]string // 附加的请求头，如认证信息
+	ServiceName string
+	SampleRatio float64 // 采样比例，不大于0时不采样新链路，仍遵循上游的采样决定
+}
+
+// Setup 创建OTLP导出器并设为全局TracerProvider，返回用于刷新并关闭导出器的函数
+func Setup(option Option) (func(context.Context) error, error) {
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(option.Endpoint)}
+	if len(option.URLPath) > 0 {
+		opts = append(opts, otlptracehttp.WithURLPath(option.URLPath))
+	}
+	if option.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(option.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(option.Headers))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return install(sdktrace.WithBatcher(exporter), option), nil
+}
+
+// SetupWithExporter 使用指定导出器同步导出，用于本地调试及测试，如tracetest.NewInMemoryExporter()
+func SetupWithExporter(exporter sdktrace.SpanExporter, option Option) func(context.Context) error {
+	return install(sdktrace.WithSyncer(exporter), option)
+}
+
+func install(export sdktrace.TracerProviderOption, option Option) func(context.Context) error {
+	provider := sdktrace.NewTracerProvider(
+		export,
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(option.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(option.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown
+}
+
+// Start 在ctx下开启子span，未启用链路追踪时返回不记录的span
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// TraceID 获取ctx中的链路追踪ID，不存在时返回空串
+func TraceID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+// RecordError 将错误记录至ctx中的span
+func RecordError(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Iris 为每个请求开启span的中间件，遵循请求头中的上游链路，并将span置于请求上下文中
+func Iris(server string) iris.Handler {
+	return func(ctx iris.Context) {
+		r := ctx.Request()
+		parent := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		route := r.URL.Path
+		if current := ctx.GetCurrentRoute(); current != nil {
+			route = current.Path()
+		}
+		spanCtx, span := otel.Tracer(tracerName).Start(parent, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("kk.server", server),
+				semconv.HTTPMethodKey.String(r.Method),
+				semconv.HTTPRouteKey.String(route),
+				semconv.HTTPTargetKey.String(r.URL.RequestURI()),
+				semconv.NetPeerIPKey.String(ctx.RemoteAddr()),
+			))
+		defer span.End()
+		ctx.ResetRequest(r.WithContext(spanCtx))
+
+		ctx.Next()
+		status := ctx.GetStatusCode()
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// TraceQuery 以已执行完毕的数据库查询补录span
+func TraceQuery(ctx context.Context, begin time.Time, sql string, rows int64, err error) {
+	if !trace.SpanFromContext(ctx).IsRecording() { // 无上级span时不单独记录
+		return
+	}
+	name := "gorm"
+	if fields := strings.Fields(sql); len(fields) > 0 {
+		name += "." + strings.ToLower(fields[0])
+	}
+	_, span := otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(begin),
+		trace.WithAttributes(
+			semconv.DBStatementKey.String(sql),
+			attribute.Int64("db.rows_affected", rows),
+		))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
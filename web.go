@@ -1,16 +1,19 @@
 package main
 
 import (
+	"crypto/tls"
 	"embed"
 	"net/http"
 
 	"github.com/RicheyJang/key_keeper/logic"
 	"github.com/RicheyJang/key_keeper/utils/logger"
+	"github.com/RicheyJang/key_keeper/utils/metrics"
+	"github.com/RicheyJang/key_keeper/utils/tracing"
 	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/core/host"
 	"github.com/kataras/iris/v12/core/router"
 	irisRecover "github.com/kataras/iris/v12/middleware/recover"
 	log "github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 )
 
 func WebServer(manager *logic.Manager, addr string) {
@@ -19,14 +22,27 @@ func WebServer(manager *logic.Manager, addr string) {
 	app.Use(irisRecover.New())
 
 	// 设置路由
+	app.Get("/healthz", manager.HandlerOfHealthz)
+	app.Get("/readyz", manager.HandlerOfPublicReadyz)
 	app.PartyFunc("/api", func(api router.Party) {
 		api.Use(logger.Iris("[ Web ]")) // 日志
+		api.Use(metrics.Iris("web"))    // 监控指标
+		api.Use(tracing.Iris("web"))    // 链路追踪
 		// 注册后端API
 		api.Get("/meta", manager.HandlerOfGetMetaInfo)
 		api.Post("/login", manager.GetLoginHandler())
+		api.Post("/login/2fa", manager.GetTwoFactorLoginHandler())
+		api.Post("/login/refresh", manager.GetRefreshHandler())
+		api.Get("/login/oidc", manager.HandlerOfOIDCLogin)
+		api.Post("/login/oidc/callback", manager.GetOIDCCallbackHandler())
+		api.Get("/public/key", manager.HandlerOfGetPublicKey)
+		api.Post("/unseal", manager.HandlerOfUnseal)
 
 		api.Use(manager.GetVerifyHandler())
+		api.Use(manager.PreCheckOfSession)
 		api.Post("/logout", manager.HandlerOfLogout)
+		api.Post("/seal", manager.HandlerOfSeal)
+		api.Post("/config/reload", manager.HandlerOfReloadConfig)
 
 		api.PartyFunc("/user", func(userAPI router.Party) {
 			userAPI.Get("/", manager.HandlerOfGetUsers)
@@ -35,7 +51,24 @@ func WebServer(manager *logic.Manager, addr string) {
 
 			userAPI.Post("/level", manager.HandlerOfSetUserLevel)
 			userAPI.Post("/freeze", manager.HandlerOfFreezeUser)
+			userAPI.Post("/unlock", manager.HandlerOfUnlockUser)
 			userAPI.Post("/password", manager.HandlerOfChangePasswd)
+
+			userAPI.Post("/2fa/enroll", manager.HandlerOfEnrollTOTP)
+			userAPI.Post("/2fa/verify", manager.HandlerOfVerifyTOTP)
+			userAPI.Post("/2fa/disable", manager.HandlerOfDisableTOTP)
+			userAPI.Post("/2fa/recovery", manager.HandlerOfRegenerateRecoveryCodes)
+		})
+
+		api.PartyFunc("/token", func(tokenAPI router.Party) {
+			tokenAPI.Get("/", manager.HandlerOfGetTokens)
+			tokenAPI.Put("/", manager.HandlerOfAddToken)
+			tokenAPI.Delete("/", manager.HandlerOfRevokeToken)
+		})
+
+		api.PartyFunc("/session", func(sessionAPI router.Party) {
+			sessionAPI.Get("/", manager.HandlerOfGetSessions)
+			sessionAPI.Delete("/", manager.HandlerOfRevokeSession)
 		})
 
 		api.PartyFunc("/instance", func(insAPI router.Party) {
@@ -44,6 +77,27 @@ func WebServer(manager *logic.Manager, addr string) {
 			insAPI.Delete("/", manager.HandlerOfDestroyInstance)
 
 			insAPI.Post("/freeze", manager.HandlerOfFreezeInstance)
+			insAPI.Post("/kill", manager.HandlerOfKillInstance)
+			insAPI.Get("/ratelimit", manager.HandlerOfGetRateLimitStats)
+			insAPI.Post("/ratelimit", manager.HandlerOfSetRateLimit)
+
+			insAPI.Get("/cert", manager.HandlerOfGetCerts)
+			insAPI.Put("/cert", manager.HandlerOfIssueCert)
+			insAPI.Delete("/cert", manager.HandlerOfRevokeCert)
+			insAPI.Get("/cert/download", manager.HandlerOfDownloadCert)
+		})
+
+		api.PartyFunc("/revocation", func(revAPI router.Party) {
+			revAPI.Get("/", manager.HandlerOfGetRevokedSerials)
+			revAPI.Put("/", manager.HandlerOfRevokeSerial)
+			revAPI.Delete("/", manager.HandlerOfDeleteRevokedSerial)
+		})
+
+		api.PartyFunc("/operation", func(opAPI router.Party) {
+			opAPI.Get("/", manager.HandlerOfGetOperations)
+			opAPI.Post("/approve", manager.HandlerOfApproveOperation)
+			opAPI.Post("/reject", manager.HandlerOfRejectOperation)
+			opAPI.Post("/expire", manager.HandlerOfExpireOperations)
 		})
 
 		api.PartyFunc("/keys", func(keysAPI router.Party) {
@@ -57,11 +111,30 @@ func WebServer(manager *logic.Manager, addr string) {
 	setupStatic(app)
 
 	// 启动
-	log.Fatal(app.Run(iris.TLS(addr, viper.GetString("cert.self"), viper.GetString("cert.private")),
+	log.Fatal(app.Run(getWebRunner(addr),
 		iris.WithoutPathCorrectionRedirection,
 		iris.WithOptimizations))
 }
 
+func getWebRunner(addr string, hostConfigs ...host.Configurator) iris.Runner {
+	tlsConfig := &tls.Config{
+		GetCertificate: serverCerts.GetCertificate, // 证书可热加载
+	}
+	if err := getTLSPolicy("web").Apply(tlsConfig); err != nil {
+		log.Fatal("Invalid TLS policy: ", err.Error())
+	}
+	s := &http.Server{
+		Addr:      addr,
+		TLSConfig: tlsConfig,
+	}
+	// 生成Runner
+	return func(app *iris.Application) error {
+		return app.NewHost(s).
+			Configure(hostConfigs...).
+			ListenAndServeTLS("", "")
+	}
+}
+
 //go:embed dist/*
 var Static embed.FS
 